@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jcuello/chirpy/internal/auth"
+	"github.com/jcuello/chirpy/internal/database"
+)
+
+// accountDeletionMode decides what happens to a deleted account's chirps.
+type accountDeletionMode string
+
+const (
+	// deleteAccounts removes the user and everything they posted.
+	deleteAccounts accountDeletionMode = "delete"
+	// anonymizeAccounts removes the user but keeps their chirps, without an
+	// author, so threads they took part in still read.
+	anonymizeAccounts accountDeletionMode = "anonymize"
+)
+
+const defaultAccountDeletionGrace = 14 * 24 * time.Hour
+
+// handleDeleteUser schedules the caller's account for deletion once the
+// grace period is over, or deletes it right away when there is none. Either
+// way every session is signed out; logging in again before the deadline
+// cancels the deletion.
+func (cfg *apiConfig) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	userId, _ := auth.UserIDFromContext(r.Context())
+
+	params := accountDeletionRequest{}
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil || params.Password == "" {
+		respondWithError(w, 400, "Invalid body")
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), userId)
+	if err == sql.ErrNoRows {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+	if !cfg.currentPasswordOK(w, r, user, params.Password) {
+		return
+	}
+
+	err = cfg.db.RevokeAllUserRefreshTokens(r.Context(), uuid.NullUUID{UUID: userId, Valid: true})
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	deleteAfter := cfg.now().UTC().Add(cfg.deletionGrace)
+	err = cfg.db.ScheduleUserDeletion(r.Context(), database.ScheduleUserDeletionParams{
+		ID:          userId,
+		DeleteAfter: sql.NullTime{Time: deleteAfter, Valid: true},
+	})
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	if cfg.deletionGrace <= 0 {
+		_, err = cfg.purgeAccount(r.Context(), userId, deleteAfter)
+		if err != nil {
+			respondWithInternalServerError(w)
+			return
+		}
+		respondWithJson(w, 204, struct{}{})
+		return
+	}
+
+	respondWithJson(w, 202, accountDeletion{DeleteAfter: deleteAfter})
+}
+
+// cancelAccountDeletion keeps an account scheduled for deletion, because its
+// owner has logged in again.
+func (cfg *apiConfig) cancelAccountDeletion(ctx context.Context, user database.User) {
+	if !user.DeleteAfter.Valid {
+		return
+	}
+	_, err := cfg.db.CancelUserDeletion(ctx, user.ID)
+	if err != nil {
+		fmt.Printf("Unable to cancel deletion of %v: %v\n", user.ID, err)
+	}
+}
+
+// purgeAccount deletes the user the way cfg.deletionMode says, provided
+// their deletion is still due by dueBy. It reports whether it deleted them.
+func (cfg *apiConfig) purgeAccount(ctx context.Context, userId uuid.UUID, dueBy time.Time) (bool, error) {
+	due := sql.NullTime{Time: dueBy, Valid: true}
+	var purged int64
+	var err error
+	if cfg.deletionMode == anonymizeAccounts {
+		purged, err = cfg.db.PurgeUserKeepChirps(ctx, database.PurgeUserKeepChirpsParams{ID: userId, DueBy: due})
+	} else {
+		purged, err = cfg.db.PurgeUser(ctx, database.PurgeUserParams{ID: userId, DueBy: due})
+	}
+	return purged == 1, err
+}
+
+// purgeDueAccounts deletes every account whose grace period is over and
+// reports how many it deleted.
+func (cfg *apiConfig) purgeDueAccounts(ctx context.Context) (int, error) {
+	now := cfg.now().UTC()
+	due, err := cfg.db.ListUsersDueForDeletion(ctx, sql.NullTime{Time: now, Valid: true})
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, userId := range due {
+		ok, err := cfg.purgeAccount(ctx, userId, now)
+		if err != nil {
+			return purged, err
+		}
+		if ok {
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// purgeAccountsEvery runs purgeDueAccounts on a timer until ctx is done.
+func (cfg *apiConfig) purgeAccountsEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		purged, err := cfg.purgeDueAccounts(ctx)
+		if err != nil {
+			fmt.Printf("Unable to purge deleted accounts: %v\n", err)
+		}
+		if purged > 0 {
+			fmt.Printf("Purged %d deleted accounts.\n", purged)
+		}
+	}
+}
@@ -4,8 +4,116 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"time"
+
+	"github.com/jcuello/chirpy/internal/auth"
+	"github.com/jcuello/chirpy/internal/database"
+	"github.com/jcuello/chirpy/internal/mail"
+	"github.com/jcuello/chirpy/internal/moderation"
+	"github.com/jcuello/chirpy/internal/password"
+	"github.com/jcuello/chirpy/internal/throttle"
 )
 
+func newAPIConfig(db database.Store, keys *auth.KeySet, polkaApiKey, platform string) *apiConfig {
+	return &apiConfig{
+		db:                db,
+		keys:              keys,
+		passwords:         auth.NewPasswordHasher(auth.DefaultPasswordParams),
+		passwordPolicy:    password.DefaultPolicy,
+		polkaApiKey:       polkaApiKey,
+		platform:          platform,
+		moderation:        moderation.NewDefault(),
+		now:               time.Now,
+		mailer:            mail.NewLogMailer(os.Stdout, "chirpy@localhost"),
+		baseURL:           "http://localhost:8080",
+		deletionMode:      deleteAccounts,
+		deletionGrace:     defaultAccountDeletionGrace,
+		exports:           newExportJobs(os.TempDir()),
+		exportInlineLimit: defaultExportInlineLimit,
+
+		accountThrottle: throttle.New(throttle.NewMemoryStore(), throttle.DefaultAccountPolicy),
+		ipThrottle:      throttle.New(throttle.NewMemoryStore(), throttle.DefaultIPPolicy),
+
+		passwordResetEmailThrottle: throttle.New(throttle.NewMemoryStore(), passwordResetEmailPolicy),
+		passwordResetIPThrottle:    throttle.New(throttle.NewMemoryStore(), passwordResetIPPolicy),
+		passwordResetSends:         make(chan struct{}, maxPasswordResetSends),
+
+		verificationUserThrottle:  throttle.New(throttle.NewMemoryStore(), verificationUserPolicy),
+		verificationEmailThrottle: throttle.New(throttle.NewMemoryStore(), verificationEmailPolicy),
+	}
+}
+
+// handler builds the routes for this config. Each call returns an independent
+// mux, so several servers can run side by side in one process.
+func (cfg *apiConfig) handler() http.Handler {
+	serveMux := http.NewServeMux()
+	appUrlPrefix := "/app/"
+	appFileServerHandler := http.StripPrefix(appUrlPrefix, http.FileServer(http.Dir(".")))
+
+	serveMux.Handle(appUrlPrefix, cfg.middlewareMetricsInc(appFileServerHandler))
+	serveMux.HandleFunc("GET /api/healthz", func(resp http.ResponseWriter, request *http.Request) {
+		resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		resp.WriteHeader(http.StatusOK)
+		resp.Write([]byte("OK\n"))
+
+	})
+	authn := auth.NewMiddleware(cfg.keys)
+	required := func(h http.HandlerFunc, scopes ...auth.Scope) http.Handler {
+		return authn.RequireAuth(h, scopes...)
+	}
+	optional := func(h http.HandlerFunc) http.Handler {
+		return authn.OptionalAuth(h)
+	}
+
+	serveMux.HandleFunc("GET /.well-known/jwks.json", cfg.handleGetJWKS)
+	serveMux.Handle("POST /api/chirps", required(cfg.handlePostChirp, auth.ScopeChirpsWrite))
+	serveMux.Handle("GET /api/chirps", optional(cfg.handleGetChirps))
+	serveMux.Handle("GET /api/chirps/{chirpID}", optional(cfg.handleGetSingleChirp))
+	serveMux.Handle("GET /api/chirps/{chirpID}/thread", optional(cfg.handleGetChirpThread))
+	serveMux.Handle("PUT /api/chirps/{chirpID}", required(cfg.handlePutChirp, auth.ScopeChirpsWrite))
+	serveMux.Handle("DELETE /api/chirps/{chirpID}", required(cfg.handleDeleteChirps, auth.ScopeChirpsWrite))
+	serveMux.HandleFunc("GET /api/chirps/{chirpID}/revisions", cfg.handleGetChirpRevisions)
+	serveMux.Handle("POST /api/chirps/{chirpID}/likes", required(cfg.handlePostLike))
+	serveMux.Handle("DELETE /api/chirps/{chirpID}/likes", required(cfg.handleDeleteLike))
+
+	serveMux.HandleFunc("POST /api/users", cfg.handlePostUser)
+	serveMux.Handle("DELETE /api/users/me", required(cfg.handleDeleteUser))
+	serveMux.Handle("GET /api/users/me/export", required(cfg.handleExportUser))
+	serveMux.Handle("GET /api/users/me/export/{jobID}", required(cfg.handleGetExportJob))
+	serveMux.Handle("GET /api/users/me/export/{jobID}/download", required(cfg.handleDownloadExport))
+	serveMux.Handle("PUT /api/users", required(cfg.handlePutUser))
+	serveMux.HandleFunc("GET /api/users/verify", cfg.handleVerifyEmail)
+	serveMux.Handle("POST /api/users/verify", required(cfg.handleResendVerification))
+	serveMux.Handle("POST /api/users/2fa", required(cfg.handleStartTOTP))
+	serveMux.Handle("POST /api/users/2fa/confirm", required(cfg.handleConfirmTOTP))
+	serveMux.Handle("DELETE /api/users/2fa", required(cfg.handleDisableTOTP))
+	serveMux.HandleFunc("POST /api/password-reset/request", cfg.handleRequestPasswordReset)
+	serveMux.HandleFunc("POST /api/password-reset/confirm", cfg.handleConfirmPasswordReset)
+	serveMux.HandleFunc("GET /api/users/{handleOrID}", cfg.handleGetUserProfile)
+	serveMux.Handle("GET /api/users/{userID}/likes", optional(cfg.handleGetUserLikes))
+	serveMux.Handle("POST /api/users/{userID}/follow", required(cfg.handleFollow))
+	serveMux.Handle("DELETE /api/users/{userID}/follow", required(cfg.handleUnfollow))
+	serveMux.HandleFunc("GET /api/users/{userID}/followers", cfg.handleGetFollowers)
+	serveMux.HandleFunc("GET /api/users/{userID}/following", cfg.handleGetFollowing)
+	serveMux.Handle("GET /api/timeline", required(cfg.handleGetTimeline))
+
+	serveMux.HandleFunc("POST /api/polka/webhooks", cfg.handlePolkaWebhook)
+
+	serveMux.HandleFunc("POST /api/login", cfg.handleLogin)
+	serveMux.HandleFunc("POST /api/login/mfa", cfg.handleLoginMFA)
+	serveMux.HandleFunc("POST /api/refresh", cfg.handleRefresh)
+	serveMux.HandleFunc("POST /api/revoke", cfg.handleRevoke)
+	serveMux.Handle("GET /api/sessions", required(cfg.handleGetSessions))
+	serveMux.Handle("DELETE /api/sessions", required(cfg.handleDeleteSessions))
+	serveMux.Handle("DELETE /api/sessions/{sessionID}", required(cfg.handleDeleteSession))
+
+	serveMux.HandleFunc("GET /admin/metrics", cfg.viewMetrics())
+	serveMux.HandleFunc("POST /admin/reset", cfg.resetMetrics())
+	serveMux.Handle("POST /admin/moderation/reload", required(cfg.handleReloadModeration, auth.ScopeAdmin))
+
+	return serveMux
+}
+
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cfg.fileserverHits.Add(1)
@@ -26,7 +134,7 @@ func (cfg *apiConfig) resetMetrics() http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 
-		if os.Getenv("PLATFORM") == "dev" {
+		if cfg.platform == "dev" {
 			w.WriteHeader(http.StatusOK)
 			cfg.fileserverHits.Store(0)
 			response := fmt.Sprintf("Hits: %v\n", cfg.fileserverHits.Load())
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,7 +15,12 @@ import (
 	"github.com/jcuello/chirpy/internal/database"
 )
 
-func handlePostUser(w http.ResponseWriter, r *http.Request) {
+const (
+	accessTokenExpiration  = 60 * time.Minute
+	refreshTokenExpiration = 60 * 24 * time.Hour
+)
+
+func (cfg *apiConfig) handlePostUser(w http.ResponseWriter, r *http.Request) {
 	respBody := UserPost{}
 	defer r.Body.Close()
 
@@ -22,8 +31,18 @@ func handlePostUser(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, 400, "Invalid body")
 		return
 	}
+	if !validEmail(respBody.Email) {
+		respondWithError(w, 400, "Invalid email address")
+		return
+	}
+	if respBody.Handle != "" && !cfg.handleAvailable(w, r, respBody.Handle, uuid.Nil) {
+		return
+	}
+	if !cfg.passwordAcceptable(w, r, respBody.Password, respBody.Email, respBody.Handle) {
+		return
+	}
 
-	hash, err := auth.HashPassword(respBody.Password)
+	hash, err := cfg.passwords.HashPassword(respBody.Password)
 	if err != nil {
 		respondWithInternalServerError(w)
 		return
@@ -33,25 +52,46 @@ func handlePostUser(w http.ResponseWriter, r *http.Request) {
 		database.CreateUserParams{
 			Email:          sql.NullString{String: respBody.Email, Valid: true},
 			HashedPassword: hash,
+			Handle:         sql.NullString{String: respBody.Handle, Valid: respBody.Handle != ""},
 		})
 
+	if database.IsUniqueViolation(err) {
+		// handleAvailable looked before the insert, so the handle can
+		// still have been taken in between. Unless the email is in use, it
+		// was.
+		_, emailErr := cfg.db.GetUser(r.Context(), sql.NullString{String: respBody.Email, Valid: true})
+		if emailErr == nil || respBody.Handle == "" {
+			respondWithError(w, 409, "Email address already in use")
+		} else {
+			respondWithError(w, 409, "Handle already taken")
+		}
+		return
+	}
 	if err != nil {
 		respondWithError(w, 500, "Unable to create user")
 		return
 	}
 
+	// The account exists either way; the user can ask for another email.
+	err = cfg.sendVerificationEmail(r.Context(), dbUser.ID, dbUser.Email.String)
+	if err != nil {
+		fmt.Printf("Unable to send verification email to %v: %v\n", dbUser.ID, err)
+	}
+
 	user := User{
-		ID:          dbUser.ID,
-		CreatedAt:   dbUser.CreatedAt.Time,
-		UpdatedAt:   dbUser.UpdatedAt.Time,
-		Email:       dbUser.Email.String,
-		IsChirpyRed: dbUser.IsChirpyRed.Bool,
+		ID:            dbUser.ID,
+		CreatedAt:     dbUser.CreatedAt.Time,
+		UpdatedAt:     dbUser.UpdatedAt.Time,
+		Email:         dbUser.Email.String,
+		EmailVerified: dbUser.EmailVerifiedAt.Valid,
+		IsChirpyRed:   dbUser.IsChirpyRed.Bool,
+		Handle:        dbUser.Handle.String,
 	}
 
 	respondWithJson(w, 201, user)
 }
 
-func handleLogin(w http.ResponseWriter, r *http.Request) {
+func (cfg *apiConfig) handleLogin(w http.ResponseWriter, r *http.Request) {
 	userLogin := UserLogin{}
 	defer r.Body.Close()
 
@@ -62,13 +102,24 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	throttleKeys := newLoginKeys(r, userLogin.Email)
+	if !cfg.loginAllowed(w, r, throttleKeys) {
+		return
+	}
+
 	user, err := cfg.db.GetUser(r.Context(), sql.NullString{String: userLogin.Email, Valid: true})
+	if err == sql.ErrNoRows {
+		// Hash anyway, so unknown addresses take as long as wrong passwords.
+		cfg.passwords.CheckDummyHash(userLogin.Password)
+		respondWithError(w, 401, "Incorrect email or password")
+		return
+	}
 	if err != nil {
 		respondWithInternalServerError(w)
 		return
 	}
 
-	passMatch, err := auth.CheckPasswordHash(userLogin.Password, user.HashedPassword)
+	passMatch, needsRehash, err := cfg.passwords.CheckPasswordHash(userLogin.Password, user.HashedPassword)
 	if err != nil {
 		respondWithInternalServerError(w)
 		return
@@ -79,74 +130,232 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accessTokenExpiration := 60 * time.Minute
-	token, err := auth.MakeJWT(user.ID, cfg.jwtSecret, accessTokenExpiration)
+	if needsRehash {
+		cfg.rehashPassword(r.Context(), user, userLogin.Password)
+	}
+
+	mfaRequired, err := cfg.mfaEnabled(r.Context(), user.ID)
 	if err != nil {
 		respondWithInternalServerError(w)
 		return
 	}
+	if mfaRequired {
+		mfaToken, err := auth.MakeMFAToken(user.ID, cfg.keys, mfaTokenExpiration)
+		if err != nil {
+			respondWithInternalServerError(w)
+			return
+		}
+		// The account's failures are only cleared once the second factor
+		// is in too, or knowing the password would reset the count of
+		// wrong codes.
+		cfg.firstFactorPassed(r.Context(), throttleKeys)
+		respondWithJson(w, 200, mfaChallenge{MFARequired: true, MFAToken: mfaToken})
+		return
+	}
+
+	cfg.loginSucceeded(r.Context(), throttleKeys)
+	cfg.startSession(w, r, user)
+}
+
+// rehashPassword replaces a password hash made with outdated parameters. The
+// login goes ahead even if this fails; the next one will try again. If the
+// password changed since user was loaded, the new hash is left alone.
+func (cfg *apiConfig) rehashPassword(ctx context.Context, user database.User, password string) {
+	hash, err := cfg.passwords.HashPassword(password)
+	if err == nil {
+		_, err = cfg.db.RehashUserPassword(ctx, database.RehashUserPasswordParams{
+			ID:                user.ID,
+			HashedPassword:    hash,
+			OldHashedPassword: user.HashedPassword,
+		})
+	}
+	if err != nil {
+		fmt.Printf("Unable to rehash password of %v: %v\n", user.ID, err)
+	}
+}
+
+// startSession responds to a completed login with the user and a new pair
+// of tokens. Logging in calls off a pending account deletion.
+func (cfg *apiConfig) startSession(w http.ResponseWriter, r *http.Request, user database.User) {
+	cfg.cancelAccountDeletion(r.Context(), user)
 
-	refreshToken, err := auth.MakeRefreshToken()
+	// Each login starts a new token family.
+	familyID := uuid.New()
+	token, err := cfg.makeAccessToken(user, familyID)
 	if err != nil {
 		respondWithInternalServerError(w)
 		return
 	}
 
-	_, err = cfg.db.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
-		Token:     refreshToken,
-		UserID:    uuid.NullUUID{UUID: user.ID, Valid: true},
-		ExpiresAt: sql.NullTime{Time: time.Now().Add(60 * 24 * time.Hour), Valid: true},
-	})
+	refreshToken, err := cfg.createRefreshToken(r, user.ID, familyID)
 	if err != nil {
 		respondWithInternalServerError(w)
 		return
 	}
 
 	respondWithJson(w, 200, User{
-		ID:           user.ID,
-		CreatedAt:    user.CreatedAt.Time,
-		UpdatedAt:    user.UpdatedAt.Time,
-		Email:        user.Email.String,
-		IsChirpyRed:  user.IsChirpyRed.Bool,
-		Token:        token,
-		RefreshToken: refreshToken,
+		ID:            user.ID,
+		CreatedAt:     user.CreatedAt.Time,
+		UpdatedAt:     user.UpdatedAt.Time,
+		Email:         user.Email.String,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		IsChirpyRed:   user.IsChirpyRed.Bool,
+		Handle:        user.Handle.String,
+		Token:         token,
+		RefreshToken:  refreshToken,
 	})
 }
 
-func handleRefresh(w http.ResponseWriter, r *http.Request) {
+// handleRefresh trades a refresh token for a new access token and a new
+// refresh token in the same family. The presented token is revoked, so
+// seeing it again means it was copied; the whole family is then revoked.
+func (cfg *apiConfig) handleRefresh(w http.ResponseWriter, r *http.Request) {
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
 		respondWithError(w, 401, "Unauthorized")
 		return
 	}
 
-	refreshToken, err := cfg.db.GetUserFromRefreshToken(r.Context(), token)
+	presented, err := cfg.findRefreshToken(r.Context(), token, cfg.db.GetRefreshToken)
+	if err == sql.ErrNoRows {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
 	if err != nil {
-		if err == sql.ErrNoRows {
-			respondWithError(w, 401, "Unauthorized")
-		} else {
-			respondWithInternalServerError(w)
-		}
+		respondWithInternalServerError(w)
 		return
 	}
 
-	newToken, err := auth.MakeJWT(refreshToken.UserID.UUID, cfg.jwtSecret, 60*time.Minute)
+	newID := uuid.New()
+	newSecret, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+	rotated, err := cfg.db.RotateRefreshToken(r.Context(), database.RotateRefreshTokenParams{
+		ID:           presented.ID,
+		NewID:        newID,
+		NewTokenHash: auth.HashToken(newSecret),
+		ExpiresAt:    sql.NullTime{Time: cfg.now().Add(refreshTokenExpiration), Valid: true},
+		UserAgent:    sql.NullString{String: r.UserAgent(), Valid: r.UserAgent() != ""},
+		IpAddress:    sql.NullString{String: clientIP(r), Valid: true},
+	})
+	if err == sql.ErrNoRows {
+		cfg.detectRefreshTokenReuse(r.Context(), presented.ID)
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
 
-	respondWithJson(w, 200, struct {
-		Token string `json:"token"`
-	}{
-		Token: newToken,
+	// Scopes and entitlements are read again so changes since login apply.
+	user, err := cfg.db.GetUserByID(r.Context(), rotated.UserID.UUID)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	newToken, err := cfg.makeAccessToken(user, rotated.FamilyID)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	respondWithJson(w, 200, tokenPair{
+		Token:        newToken,
+		RefreshToken: auth.JoinRefreshToken(newID, newSecret),
+	})
+}
+
+// makeAccessToken issues an access token for user in session sessionID.
+// Everyone may post chirps; verified addresses listed in ADMIN_EMAILS also
+// get the admin scope, so changing an account's email to one cannot grant it.
+func (cfg *apiConfig) makeAccessToken(user database.User, sessionID uuid.UUID) (string, error) {
+	scopes := []auth.Scope{auth.ScopeChirpsWrite}
+	if user.EmailVerifiedAt.Valid && slices.ContainsFunc(cfg.adminEmails, func(email string) bool {
+		return strings.EqualFold(email, user.Email.String)
+	}) {
+		scopes = append(scopes, auth.ScopeAdmin)
+	}
+
+	claims := auth.NewClaims(user.ID, scopes...)
+	claims.SessionID = sessionID.String()
+	claims.IsChirpyRed = user.IsChirpyRed.Bool
+	return auth.MakeJWT(claims, cfg.keys, accessTokenExpiration)
+}
+
+// findRefreshToken looks a presented refresh token up by its id with get,
+// and reports sql.ErrNoRows unless its secret matches the stored digest too.
+func (cfg *apiConfig) findRefreshToken(ctx context.Context, token string, get func(context.Context, uuid.UUID) (database.RefreshToken, error)) (database.RefreshToken, error) {
+	id, secret, err := auth.SplitRefreshToken(token)
+	if err != nil {
+		return database.RefreshToken{}, sql.ErrNoRows
+	}
+	refreshToken, err := get(ctx, id)
+	if err != nil {
+		return database.RefreshToken{}, err
+	}
+	if !auth.TokenMatches(secret, refreshToken.TokenHash) {
+		return database.RefreshToken{}, sql.ErrNoRows
+	}
+	return refreshToken, nil
+}
+
+// detectRefreshTokenReuse revokes every token in the family of a refresh
+// token that was presented after being revoked.
+func (cfg *apiConfig) detectRefreshTokenReuse(ctx context.Context, id uuid.UUID) {
+	presented, err := cfg.db.GetRefreshToken(ctx, id)
+	if err != nil || !presented.RevokedAt.Valid {
+		return
+	}
+
+	err = cfg.db.RevokeRefreshTokenFamily(ctx, presented.FamilyID)
+	if err != nil {
+		fmt.Printf("Unable to revoke refresh token family %v: %v\n", presented.FamilyID, err)
+	}
+}
+
+// createRefreshToken issues a refresh token in familyID, recording the client
+// that asked for it so it shows up in the session list.
+func (cfg *apiConfig) createRefreshToken(r *http.Request, userID, familyID uuid.UUID) (string, error) {
+	id := uuid.New()
+	secret, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = cfg.db.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		ID:        id,
+		TokenHash: auth.HashToken(secret),
+		UserID:    uuid.NullUUID{UUID: userID, Valid: true},
+		ExpiresAt: sql.NullTime{Time: cfg.now().Add(refreshTokenExpiration), Valid: true},
+		FamilyID:  familyID,
+		UserAgent: sql.NullString{String: r.UserAgent(), Valid: r.UserAgent() != ""},
+		IpAddress: sql.NullString{String: clientIP(r), Valid: true},
 	})
+	if err != nil {
+		return "", err
+	}
+	return auth.JoinRefreshToken(id, secret), nil
+}
+
+// handleGetJWKS publishes the public keys access tokens can be verified with,
+// so other services never need the signing key.
+func (cfg *apiConfig) handleGetJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJson(w, 200, cfg.keys.JWKS())
 }
 
-func handleRevoke(w http.ResponseWriter, r *http.Request) {
+func (cfg *apiConfig) handleRevoke(w http.ResponseWriter, r *http.Request) {
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
 		respondWithError(w, 401, "Unauthorized")
 		return
 	}
 
-	refreshToken, err := cfg.db.GetUserFromRefreshToken(r.Context(), token)
+	refreshToken, err := cfg.findRefreshToken(r.Context(), token, cfg.db.GetUserFromRefreshToken)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			respondWithError(w, 401, "Unauthorized")
@@ -156,7 +365,7 @@ func handleRevoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = cfg.db.RevokeRefreshToken(r.Context(), refreshToken.Token)
+	err = cfg.db.RevokeRefreshToken(r.Context(), refreshToken.ID)
 	if err != nil {
 		respondWithInternalServerError(w)
 		return
@@ -164,3 +373,204 @@ func handleRevoke(w http.ResponseWriter, r *http.Request) {
 
 	respondWithJson(w, 204, struct{}{})
 }
+
+// handlePutUser updates the caller's email, password, or both. A new
+// password needs the current one and signs out every other session; a new
+// email has to be verified again.
+func (cfg *apiConfig) handlePutUser(w http.ResponseWriter, r *http.Request) {
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	userId := claims.UserID()
+
+	defer r.Body.Close()
+
+	body := userUpdate{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&body)
+	changeProfile := body.Handle != nil || body.DisplayName != nil || body.Bio != nil || body.AvatarURL != nil
+	if err != nil || (body.Email == "" && body.Password == "" && !changeProfile) {
+		respondWithError(w, 400, "Invalid body.")
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), userId)
+	if err == sql.ErrNoRows {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	changeEmail := body.Email != "" && body.Email != user.Email.String
+	if changeEmail {
+		if !validEmail(body.Email) {
+			respondWithError(w, 400, "Invalid email address")
+			return
+		}
+		existing, err := cfg.db.GetUser(r.Context(), sql.NullString{String: body.Email, Valid: true})
+		if err == nil && existing.ID != user.ID {
+			respondWithError(w, 409, "Email address already in use")
+			return
+		}
+		if err != nil && err != sql.ErrNoRows {
+			respondWithInternalServerError(w)
+			return
+		}
+	}
+
+	if changeProfile {
+		if body.Handle != nil && *body.Handle != "" && !cfg.handleAvailable(w, r, *body.Handle, user.ID) {
+			return
+		}
+		if !profileFieldsOK(w, body.DisplayName, body.Bio, body.AvatarURL) {
+			return
+		}
+	}
+
+	if body.Password != "" && !cfg.passwordAcceptable(w, r, body.Password, user.Email.String, body.Email, user.Handle.String) {
+		return
+	}
+
+	// With only an access token, a thief could otherwise point the account
+	// at an address of their own and reset the password from there.
+	if (body.Password != "" || changeEmail) && !cfg.currentPasswordOK(w, r, user, body.CurrentPassword) {
+		return
+	}
+	if changeEmail && !cfg.verificationAllowed(w, r, user.ID, body.Email) {
+		return
+	}
+
+	if body.Password != "" {
+		newHashedPass, err := cfg.passwords.HashPassword(body.Password)
+		if err != nil {
+			respondWithInternalServerError(w)
+			return
+		}
+		err = cfg.db.SetUserPassword(r.Context(), database.SetUserPasswordParams{
+			ID:             user.ID,
+			HashedPassword: newHashedPass,
+		})
+		if err != nil {
+			respondWithInternalServerError(w)
+			return
+		}
+
+		err = cfg.revokeOtherSessions(r.Context(), claims)
+		if err != nil {
+			respondWithInternalServerError(w)
+			return
+		}
+
+		// A reset link already sent must not undo the change.
+		err = cfg.db.DeletePasswordResetTokens(r.Context(), user.ID)
+		if err != nil {
+			respondWithInternalServerError(w)
+			return
+		}
+	}
+
+	// The new address takes over only once its verification link is opened.
+	if changeEmail {
+		user, err = cfg.db.RequestEmailChange(r.Context(), database.RequestEmailChangeParams{
+			ID:           user.ID,
+			PendingEmail: sql.NullString{String: body.Email, Valid: true},
+		})
+		if err != nil {
+			respondWithInternalServerError(w)
+			return
+		}
+
+		err = cfg.sendVerificationEmail(r.Context(), user.ID, body.Email)
+		if err != nil {
+			fmt.Printf("Unable to send verification email to %v: %v\n", user.ID, err)
+		}
+	}
+
+	if changeProfile {
+		profile := database.UpdateUserProfileParams{
+			ID:          user.ID,
+			Handle:      user.Handle,
+			DisplayName: user.DisplayName,
+			Bio:         user.Bio,
+			AvatarUrl:   user.AvatarUrl,
+		}
+		if body.Handle != nil {
+			profile.Handle = sql.NullString{String: *body.Handle, Valid: *body.Handle != ""}
+		}
+		if body.DisplayName != nil {
+			profile.DisplayName = *body.DisplayName
+		}
+		if body.Bio != nil {
+			profile.Bio = *body.Bio
+		}
+		if body.AvatarURL != nil {
+			profile.AvatarUrl = *body.AvatarURL
+		}
+		user, err = cfg.db.UpdateUserProfile(r.Context(), profile)
+		if database.IsUniqueViolation(err) {
+			// Taken since handleAvailable looked.
+			respondWithError(w, 409, "Handle already taken")
+			return
+		}
+		if err != nil {
+			respondWithInternalServerError(w)
+			return
+		}
+	}
+
+	respondWithJson(w, 200, struct {
+		ID            uuid.UUID `json:"id"`
+		Email         string    `json:"email"`
+		EmailVerified bool      `json:"email_verified"`
+		PendingEmail  string    `json:"pending_email,omitempty"`
+		Handle        string    `json:"handle,omitempty"`
+		DisplayName   string    `json:"display_name"`
+		Bio           string    `json:"bio"`
+		AvatarURL     string    `json:"avatar_url"`
+	}{
+		ID:            user.ID,
+		Email:         user.Email.String,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		PendingEmail:  user.PendingEmail.String,
+		Handle:        user.Handle.String,
+		DisplayName:   user.DisplayName,
+		Bio:           user.Bio,
+		AvatarURL:     user.AvatarUrl,
+	})
+}
+
+// currentPasswordOK writes an error unless plaintext is user's password, and
+// reports whether to go on. It is guessed here as it would be at login, so
+// it shares the login throttle.
+func (cfg *apiConfig) currentPasswordOK(w http.ResponseWriter, r *http.Request, user database.User, plaintext string) bool {
+	throttleKeys := newLoginKeys(r, user.Email.String)
+	if !cfg.loginAllowed(w, r, throttleKeys) {
+		return false
+	}
+	passMatch, _, err := cfg.passwords.CheckPasswordHash(plaintext, user.HashedPassword)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return false
+	}
+	if !passMatch {
+		respondWithError(w, 403, "Incorrect current password")
+		return false
+	}
+	cfg.loginSucceeded(r.Context(), throttleKeys)
+	return true
+}
+
+// revokeOtherSessions signs the caller out everywhere but the session their
+// access token came from. Tokens that name no session sign out everywhere.
+func (cfg *apiConfig) revokeOtherSessions(ctx context.Context, claims *auth.Claims) error {
+	userId := uuid.NullUUID{UUID: claims.UserID(), Valid: true}
+	sessionID, ok := claims.Session()
+	if !ok {
+		return cfg.db.RevokeAllUserRefreshTokens(ctx, userId)
+	}
+	return cfg.db.RevokeOtherUserSessions(ctx, database.RevokeOtherUserSessionsParams{
+		UserID:   userId,
+		FamilyID: sessionID,
+	})
+}
@@ -1,28 +1,20 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"net/http"
-	"sort"
 	"strings"
-	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/jcuello/chirpy/internal/auth"
 	"github.com/jcuello/chirpy/internal/database"
 )
 
-func handlePostChirp(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, 401, "Unauthorized")
-		return
-	}
-
-	userId, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, 401, "Unauthorized")
+func (cfg *apiConfig) handlePostChirp(w http.ResponseWriter, r *http.Request) {
+	userId, _ := auth.UserIDFromContext(r.Context())
+	if !cfg.emailVerified(w, r, userId) {
 		return
 	}
 
@@ -30,7 +22,7 @@ func handlePostChirp(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	decoder := json.NewDecoder(r.Body)
-	err = decoder.Decode(&respBody)
+	err := decoder.Decode(&respBody)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err != nil || respBody.Body == nil {
@@ -38,16 +30,30 @@ func handlePostChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if utf8.RuneCountInString(*respBody.Body) > 140 {
-		respondWithError(w, 400, "Chirp is too long")
+	decision, err := cfg.prepareChirpBody(*respBody.Body)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
 		return
 	}
 
-	cleanedBody := cleanChirpBody(*respBody.Body)
+	inReplyTo := uuid.NullUUID{}
+	if respBody.InReplyTo != nil {
+		parent, err := cfg.db.GetChirp(r.Context(), *respBody.InReplyTo)
+		if err != nil && err != sql.ErrNoRows {
+			respondWithInternalServerError(w)
+			return
+		}
+		if err == sql.ErrNoRows || parent.DeletedAt.Valid {
+			respondWithError(w, 400, "in_reply_to chirp not found")
+			return
+		}
+		inReplyTo = uuid.NullUUID{UUID: parent.ID, Valid: true}
+	}
 
 	chirp, err := cfg.db.CreateChirp(r.Context(), database.CreateChirpParams{
-		Body:   sql.NullString{String: cleanedBody, Valid: true},
-		UserID: uuid.NullUUID{UUID: userId, Valid: true},
+		Body:      sql.NullString{String: decision.Text, Valid: true},
+		UserID:    uuid.NullUUID{UUID: userId, Valid: true},
+		InReplyTo: inReplyTo,
 	})
 
 	if err != nil {
@@ -55,55 +61,79 @@ func handlePostChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondWithJson(w, 201, chirpCreated{
-		Id:        chirp.ID,
-		CreatedAt: chirp.CreatedAt.Time,
-		UpdatedAt: chirp.UpdatedAt.Time,
-		Body:      &chirp.Body.String,
-		UserId:    userId.String(),
-	})
+	cfg.flagChirp(r.Context(), chirp.ID, decision)
+
+	result := newChirpResponse(chirp)
+	likedByMe := false
+	result.LikedByMe = &likedByMe
+
+	respondWithJson(w, 201, result)
 }
 
-func handleGetChirps(w http.ResponseWriter, r *http.Request) {
-	authorStringId := r.URL.Query().Get("author_id")
-	authorId, _ := uuid.Parse(authorStringId)
+func (cfg *apiConfig) handleGetChirps(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
 
-	var chirps []database.Chirp
-	var err error
-	if authorStringId == "" {
-		chirps, err = cfg.db.GetAllChirps(r.Context())
-	} else {
-		chirps, err = cfg.db.GetAuthorChirps(r.Context(), uuid.NullUUID{UUID: authorId, Valid: true})
+	authorId := uuid.NullUUID{}
+	if authorStringId := query.Get("author_id"); authorStringId != "" {
+		id, err := uuid.Parse(authorStringId)
+		if err != nil {
+			respondWithError(w, 400, "Invalid author_id")
+			return
+		}
+		authorId = uuid.NullUUID{UUID: id, Valid: true}
 	}
 
+	limit, cursor, err := pageParams(query)
 	if err != nil {
-		respondWithError(w, 500, "Unable to get chirps.")
+		respondWithError(w, 400, err.Error())
 		return
 	}
 
-	sortStr := r.URL.Query().Get("sort")
-	if strings.ToLower(sortStr) == "desc" {
-		sort.Slice(chirps, func(i, j int) bool {
-			return chirps[i].CreatedAt.Time.After(chirps[j].CreatedAt.Time)
-		})
+	cursorCreatedAt := sql.NullTime{}
+	cursorId := uuid.NullUUID{}
+	if cursor != nil {
+		cursorCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		cursorId = uuid.NullUUID{UUID: cursor.ID, Valid: true}
 	}
 
-	chirpsResult := []chirpCreated{}
+	// Fetch one extra row so we know whether another page exists.
+	pageSize := int32(limit + 1)
 
-	for _, c := range chirps {
-		chirpsResult = append(chirpsResult, chirpCreated{
-			Id:        c.ID,
-			CreatedAt: c.CreatedAt.Time,
-			UpdatedAt: c.UpdatedAt.Time,
-			Body:      &c.Body.String,
-			UserId:    c.UserID.UUID.String(),
+	var chirps []database.Chirp
+	if strings.ToLower(query.Get("sort")) == "desc" {
+		chirps, err = cfg.db.ListChirpsDesc(r.Context(), database.ListChirpsDescParams{
+			AuthorID:        authorId,
+			CursorCreatedAt: cursorCreatedAt,
+			CursorID:        cursorId,
+			PageSize:        pageSize,
+		})
+	} else {
+		chirps, err = cfg.db.ListChirpsAsc(r.Context(), database.ListChirpsAscParams{
+			AuthorID:        authorId,
+			CursorCreatedAt: cursorCreatedAt,
+			CursorID:        cursorId,
+			PageSize:        pageSize,
 		})
 	}
 
-	respondWithJson(w, 200, chirpsResult)
+	if err != nil {
+		respondWithError(w, 500, "Unable to get chirps.")
+		return
+	}
+
+	page := chirpPage{}
+	chirps, page.NextCursor = trimPage(chirps, limit)
+
+	page.Chirps, err = cfg.chirpResponses(r.Context(), chirps, cfg.viewerID(r), expandAuthor(r))
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	respondWithJson(w, 200, page)
 }
 
-func handleGetSingleChirp(w http.ResponseWriter, r *http.Request) {
+func (cfg *apiConfig) handleGetSingleChirp(w http.ResponseWriter, r *http.Request) {
 	chirpId := r.PathValue("chirpID")
 	chirpUUID, err := uuid.Parse(chirpId)
 
@@ -122,78 +152,180 @@ func handleGetSingleChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	chirpsResult := chirpCreated{
-		Id:        c.ID,
-		CreatedAt: c.CreatedAt.Time,
-		UpdatedAt: c.UpdatedAt.Time,
-		Body:      &c.Body.String,
-		UserId:    c.UserID.UUID.String(),
+	chirpsResult, err := cfg.chirpResponses(r.Context(), []database.Chirp{c}, cfg.viewerID(r), expandAuthor(r))
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
 	}
 
-	respondWithJson(w, 200, chirpsResult)
+	respondWithJson(w, 200, chirpsResult[0])
 }
 
-func handlePutChirp(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
+func (cfg *apiConfig) handleGetChirpThread(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
 	if err != nil {
-		respondWithError(w, 401, "Unauthorized")
+		respondWithError(w, 400, "Invalid chirp ID")
 		return
 	}
 
-	userId, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	limit, cursor, err := pageParams(r.URL.Query())
 	if err != nil {
-		respondWithError(w, 401, "Unauthorized")
+		respondWithError(w, 400, err.Error())
 		return
 	}
 
-	defer r.Body.Close()
+	chirp, err := cfg.db.GetChirp(r.Context(), chirpID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, 404, "Chirp not found.")
+		} else {
+			respondWithInternalServerError(w)
+		}
+		return
+	}
 
-	body := UserPost{}
-	decoder := json.NewDecoder(r.Body)
-	err = decoder.Decode(&body)
+	ancestors, err := cfg.db.GetChirpAncestors(r.Context(), chirp.ID)
 	if err != nil {
-		respondWithError(w, 400, "Invalid body.")
+		respondWithInternalServerError(w)
 		return
 	}
 
-	newHashedPass, err := auth.HashPassword(body.Password)
+	params := database.ListChirpDescendantsParams{
+		RootID:   uuid.NullUUID{UUID: chirp.ID, Valid: true},
+		PageSize: int32(limit + 1),
+	}
+	if cursor != nil {
+		params.CursorCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		params.CursorID = uuid.NullUUID{UUID: cursor.ID, Valid: true}
+	}
+	replies, err := cfg.db.ListChirpDescendants(r.Context(), params)
 	if err != nil {
 		respondWithInternalServerError(w)
 		return
 	}
 
-	err = cfg.db.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
-		Email:          sql.NullString{String: body.Email, Valid: true},
-		HashedPassword: newHashedPass,
-	})
+	thread := chirpThread{}
+	replies, thread.NextCursor = trimPage(replies, limit)
 
+	// Resolve everything in one pass so counts are fetched in a batch.
+	all := append(append([]database.Chirp{chirp}, ancestors...), replies...)
+	results, err := cfg.chirpResponses(r.Context(), all, cfg.viewerID(r), expandAuthor(r))
 	if err != nil {
 		respondWithInternalServerError(w)
 		return
 	}
 
-	respondWithJson(w, 200, struct {
-		ID    uuid.UUID `json:"id"`
-		Email string    `json:"email"`
-	}{
-		ID:    userId,
-		Email: body.Email,
-	})
+	thread.Chirp = results[0]
+	thread.Ancestors = results[1 : 1+len(ancestors)]
+	thread.Replies = results[1+len(ancestors):]
+
+	respondWithJson(w, 200, thread)
 }
 
-func handleDeleteChirps(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
+func (cfg *apiConfig) handlePutChirp(w http.ResponseWriter, r *http.Request) {
+	userId, _ := auth.UserIDFromContext(r.Context())
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
 	if err != nil {
-		respondWithError(w, 401, "Unauthorized")
+		respondWithError(w, 400, "Invalid chirpID")
+		return
+	}
+
+	chirp, err := cfg.db.GetChirp(r.Context(), chirpID)
+	if err != nil || chirp.DeletedAt.Valid {
+		if err == nil || err == sql.ErrNoRows {
+			respondWithError(w, 404, "Chirp Not Found")
+		} else {
+			respondWithInternalServerError(w)
+		}
+		return
+	}
+
+	if chirp.UserID.UUID != userId {
+		respondWithError(w, 403, "Unauthorized")
+		return
+	}
+
+	respBody := chirpPost{}
+	defer r.Body.Close()
+
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&respBody)
+	if err != nil || respBody.Body == nil {
+		respondWithError(w, 400, "Invalid body")
 		return
 	}
 
-	userId, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	decision, err := cfg.prepareChirpBody(*respBody.Body)
 	if err != nil {
-		respondWithError(w, 401, "Unauthorized")
+		respondWithError(w, 400, err.Error())
 		return
 	}
 
+	// Saving an identical body would only add a noise revision.
+	if decision.Text != chirp.Body.String {
+		chirp, err = cfg.db.EditChirp(r.Context(), database.EditChirpParams{
+			ID:   chirp.ID,
+			Body: sql.NullString{String: decision.Text, Valid: true},
+		})
+		if err != nil {
+			if err == sql.ErrNoRows {
+				respondWithError(w, 404, "Chirp Not Found")
+			} else {
+				respondWithInternalServerError(w)
+			}
+			return
+		}
+		cfg.flagChirp(r.Context(), chirp.ID, decision)
+	}
+
+	results, err := cfg.chirpResponses(r.Context(), []database.Chirp{chirp}, uuid.NullUUID{UUID: userId, Valid: true}, expandAuthor(r))
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	respondWithJson(w, 200, results[0])
+}
+
+func (cfg *apiConfig) handleGetChirpRevisions(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid chirp ID")
+		return
+	}
+
+	chirp, err := cfg.db.GetChirp(r.Context(), chirpID)
+	if err != nil || chirp.DeletedAt.Valid {
+		if err == nil || err == sql.ErrNoRows {
+			respondWithError(w, 404, "Chirp not found.")
+		} else {
+			respondWithInternalServerError(w)
+		}
+		return
+	}
+
+	revisions, err := cfg.db.ListChirpRevisions(r.Context(), chirp.ID)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	result := chirpRevisions{Revisions: []chirpRevision{}}
+	for _, rev := range revisions {
+		result.Revisions = append(result.Revisions, chirpRevision{
+			Id:        rev.ID,
+			Body:      rev.Body,
+			CreatedAt: rev.CreatedAt,
+		})
+	}
+
+	respondWithJson(w, 200, result)
+}
+
+func (cfg *apiConfig) handleDeleteChirps(w http.ResponseWriter, r *http.Request) {
+	userId, _ := auth.UserIDFromContext(r.Context())
+
 	chirpStrId := r.PathValue("chirpID")
 	chirpID, err := uuid.Parse(chirpStrId)
 	if err != nil {
@@ -203,8 +335,8 @@ func handleDeleteChirps(w http.ResponseWriter, r *http.Request) {
 
 	chirp, err := cfg.db.GetChirp(r.Context(), chirpID)
 
-	if err != nil {
-		if err == sql.ErrNoRows {
+	if err != nil || chirp.DeletedAt.Valid {
+		if err == nil || err == sql.ErrNoRows {
 			respondWithError(w, 404, "Chirp Not Found")
 
 		} else {
@@ -218,7 +350,18 @@ func handleDeleteChirps(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = cfg.db.DeleteChirp(r.Context(), chirp.ID)
+	// A chirp with replies is kept as a tombstone so the thread stays intact.
+	hasReplies, err := cfg.db.ChirpHasReplies(r.Context(), uuid.NullUUID{UUID: chirp.ID, Valid: true})
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	if hasReplies {
+		err = cfg.db.TombstoneChirp(r.Context(), chirp.ID)
+	} else {
+		err = cfg.db.DeleteChirpAndTombstones(r.Context(), chirp.ID)
+	}
 	if err != nil {
 		respondWithInternalServerError(w)
 		return
@@ -226,3 +369,104 @@ func handleDeleteChirps(w http.ResponseWriter, r *http.Request) {
 
 	respondWithJson(w, 204, struct{}{})
 }
+
+// newChirpResponse converts a row without any aggregate counts. Tombstones
+// keep their place in a thread but lose their body and author.
+func newChirpResponse(c database.Chirp) chirpCreated {
+	result := chirpCreated{
+		Id:        c.ID,
+		CreatedAt: c.CreatedAt.Time,
+		UpdatedAt: c.UpdatedAt.Time,
+		Edited:    c.EditedAt.Valid,
+		Deleted:   c.DeletedAt.Valid,
+	}
+	if c.InReplyTo.Valid {
+		result.InReplyTo = &c.InReplyTo.UUID
+	}
+	if !c.DeletedAt.Valid {
+		result.Body = &c.Body.String
+		// Chirps kept from a deleted account have no author.
+		if c.UserID.Valid {
+			result.UserId = c.UserID.UUID.String()
+		}
+	}
+	return result
+}
+
+// chirpResponses converts rows and fills in their reply and like counts with
+// one query each. liked_by_me is only set when viewer is valid, and author
+// only when withAuthors is set.
+func (cfg *apiConfig) chirpResponses(ctx context.Context, chirps []database.Chirp, viewer uuid.NullUUID, withAuthors bool) ([]chirpCreated, error) {
+	results := []chirpCreated{}
+	if len(chirps) == 0 {
+		return results, nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(chirps))
+	for _, c := range chirps {
+		ids = append(ids, c.ID)
+	}
+
+	counts, err := cfg.db.GetReplyCounts(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	replyCounts := map[uuid.UUID]int64{}
+	for _, row := range counts {
+		replyCounts[row.InReplyTo.UUID] = row.ReplyCount
+	}
+
+	likes, err := cfg.db.GetLikeCounts(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	likeCounts := map[uuid.UUID]int64{}
+	for _, row := range likes {
+		likeCounts[row.ChirpID] = row.LikeCount
+	}
+
+	var liked map[uuid.UUID]bool
+	if viewer.Valid {
+		likedIds, err := cfg.db.GetLikedChirpIDs(ctx, database.GetLikedChirpIDsParams{
+			UserID:   viewer.UUID,
+			ChirpIds: ids,
+		})
+		if err != nil {
+			return nil, err
+		}
+		liked = map[uuid.UUID]bool{}
+		for _, id := range likedIds {
+			liked[id] = true
+		}
+	}
+
+	var authors map[uuid.UUID]authorSummary
+	if withAuthors {
+		authors, err = cfg.authorSummaries(ctx, chirps)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range chirps {
+		result := newChirpResponse(c)
+		if author, ok := authors[c.UserID.UUID]; ok && result.UserId != "" {
+			result.Author = &author
+		}
+		result.ReplyCount = replyCounts[c.ID]
+		result.LikeCount = likeCounts[c.ID]
+		if liked != nil {
+			likedByMe := liked[c.ID]
+			result.LikedByMe = &likedByMe
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// viewerID returns the caller's user ID on routes wrapped in OptionalAuth.
+// Anonymous and invalid callers get an invalid NullUUID.
+func (cfg *apiConfig) viewerID(r *http.Request) uuid.NullUUID {
+	userId, ok := auth.UserIDFromContext(r.Context())
+	return uuid.NullUUID{UUID: userId, Valid: ok}
+}
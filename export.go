@@ -0,0 +1,414 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jcuello/chirpy/internal/auth"
+	"github.com/jcuello/chirpy/internal/database"
+)
+
+const (
+	// defaultExportInlineLimit is how many chirps an account can have before
+	// its export is built in the background instead of during the request.
+	defaultExportInlineLimit = 1000
+	exportJobTimeout         = 10 * time.Minute
+	exportJobExpiration      = 24 * time.Hour
+	exportFilename           = "chirpy-export.zip"
+)
+
+// exportChirpPageSize is how many chirps an export reads at a time, so a
+// prolific account is never held in memory all at once.
+var exportChirpPageSize int32 = 500
+
+type exportStatus string
+
+const (
+	exportPending exportStatus = "pending"
+	exportReady   exportStatus = "ready"
+	exportFailed  exportStatus = "failed"
+)
+
+// exportJob is an archive being built, or already built, in the background.
+type exportJob struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Status    exportStatus
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	path      string
+}
+
+// exportJobs keeps the background exports of one server. Finished archives
+// live in dir until they expire.
+type exportJobs struct {
+	dir  string
+	mu   sync.Mutex
+	jobs map[uuid.UUID]*exportJob
+}
+
+func newExportJobs(dir string) *exportJobs {
+	return &exportJobs{dir: dir, jobs: map[uuid.UUID]*exportJob{}}
+}
+
+// start registers a new job for the user, unless one is already pending or
+// ready, in which case it returns that one and created is false.
+func (e *exportJobs) start(userId uuid.UUID, now time.Time) (job exportJob, created bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.sweep(now)
+	for _, existing := range e.jobs {
+		if existing.UserID == userId && existing.Status != exportFailed {
+			return *existing, false
+		}
+	}
+
+	started := &exportJob{
+		ID:        uuid.New(),
+		UserID:    userId,
+		Status:    exportPending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(exportJobExpiration),
+	}
+	e.jobs[started.ID] = started
+	return *started, true
+}
+
+// get returns the user's job with the given ID. Other users' jobs look the
+// same as missing ones.
+func (e *exportJobs) get(userId, id uuid.UUID, now time.Time) (exportJob, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.sweep(now)
+	job, ok := e.jobs[id]
+	if !ok || job.UserID != userId {
+		return exportJob{}, false
+	}
+	return *job, true
+}
+
+// finish records the outcome of a job. A failed job leaves no file behind.
+func (e *exportJobs) finish(id uuid.UUID, path string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	job, ok := e.jobs[id]
+	if !ok {
+		os.Remove(path)
+		return
+	}
+	if err != nil {
+		os.Remove(path)
+		job.Status = exportFailed
+		return
+	}
+	job.Status = exportReady
+	job.path = path
+}
+
+// sweep forgets expired jobs and deletes their archives. The caller must hold
+// e.mu.
+func (e *exportJobs) sweep(now time.Time) {
+	for id, job := range e.jobs {
+		if now.Before(job.ExpiresAt) {
+			continue
+		}
+		if job.path != "" {
+			os.Remove(job.path)
+		}
+		delete(e.jobs, id)
+	}
+}
+
+// handleExportUser sends the caller everything Chirpy stores about them as a
+// zip of JSON files. Accounts with more than cfg.exportInlineLimit chirps get
+// a background job to poll instead.
+func (cfg *apiConfig) handleExportUser(w http.ResponseWriter, r *http.Request) {
+	userId, _ := auth.UserIDFromContext(r.Context())
+
+	count, err := cfg.db.CountAuthorChirps(r.Context(), uuid.NullUUID{UUID: userId, Valid: true})
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	if count > cfg.exportInlineLimit {
+		job, created := cfg.exports.start(userId, cfg.now().UTC())
+		if created {
+			go cfg.runExportJob(job)
+		}
+		w.Header().Set("Location", exportStatusPath(job.ID))
+		respondWithJson(w, 202, newExportJobResponse(job))
+		return
+	}
+
+	export, err := cfg.loadExport(r.Context(), userId)
+	if err == sql.ErrNoRows {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+exportFilename+`"`)
+	w.WriteHeader(200)
+	if err := cfg.writeExport(r.Context(), w, export, cfg.now().UTC()); err != nil {
+		fmt.Printf("Unable to stream export for %v: %v\n", userId, err)
+	}
+}
+
+func (cfg *apiConfig) handleGetExportJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := cfg.exportJobFromPath(w, r)
+	if !ok {
+		return
+	}
+	respondWithJson(w, 200, newExportJobResponse(job))
+}
+
+func (cfg *apiConfig) handleDownloadExport(w http.ResponseWriter, r *http.Request) {
+	job, ok := cfg.exportJobFromPath(w, r)
+	if !ok {
+		return
+	}
+	if job.Status != exportReady {
+		respondWithError(w, 409, "Export is not ready")
+		return
+	}
+
+	f, err := os.Open(job.path)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Disposition", `attachment; filename="`+exportFilename+`"`)
+	http.ServeContent(w, r, exportFilename, job.CreatedAt, f)
+}
+
+// exportJobFromPath looks up the caller's job named by the jobID path value,
+// writing a 400 or 404 when there is none.
+func (cfg *apiConfig) exportJobFromPath(w http.ResponseWriter, r *http.Request) (exportJob, bool) {
+	userId, _ := auth.UserIDFromContext(r.Context())
+
+	jobID, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid jobID")
+		return exportJob{}, false
+	}
+	job, ok := cfg.exports.get(userId, jobID, cfg.now().UTC())
+	if !ok {
+		respondWithError(w, 404, "Export not found")
+		return exportJob{}, false
+	}
+	return job, true
+}
+
+// runExportJob writes the job's archive to a temporary file. It outlives the
+// request that started it.
+func (cfg *apiConfig) runExportJob(job exportJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), exportJobTimeout)
+	defer cancel()
+
+	f, err := os.CreateTemp(cfg.exports.dir, "chirpy-export-*.zip")
+	if err != nil {
+		fmt.Printf("Unable to create export for %v: %v\n", job.UserID, err)
+		cfg.exports.finish(job.ID, "", err)
+		return
+	}
+
+	export, err := cfg.loadExport(ctx, job.UserID)
+	if err == nil {
+		err = cfg.writeExport(ctx, f, export, job.CreatedAt)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Printf("Unable to export %v: %v\n", job.UserID, err)
+	}
+	cfg.exports.finish(job.ID, f.Name(), err)
+}
+
+// userExport is what goes into an archive apart from the chirps, loaded up
+// front so most failing queries are reported before any of the archive is
+// sent. Chirps are read page by page while the archive is written.
+type userExport struct {
+	userId       uuid.UUID
+	profile      exportedProfile
+	sessions     []exportedSession
+	subscription exportedSubscription
+}
+
+func (cfg *apiConfig) loadExport(ctx context.Context, userId uuid.UUID) (userExport, error) {
+	user, err := cfg.db.GetUserByID(ctx, userId)
+	if err != nil {
+		return userExport{}, err
+	}
+	twoFactor, err := cfg.mfaEnabled(ctx, userId)
+	if err != nil {
+		return userExport{}, err
+	}
+	tokens, err := cfg.db.ListUserRefreshTokens(ctx, uuid.NullUUID{UUID: userId, Valid: true})
+	if err != nil {
+		return userExport{}, err
+	}
+
+	export := userExport{
+		userId: userId,
+		profile: exportedProfile{
+			ID:               user.ID,
+			CreatedAt:        user.CreatedAt.Time,
+			UpdatedAt:        user.UpdatedAt.Time,
+			Email:            user.Email.String,
+			EmailVerified:    user.EmailVerifiedAt.Valid,
+			PendingEmail:     user.PendingEmail.String,
+			Handle:           user.Handle.String,
+			DisplayName:      user.DisplayName,
+			Bio:              user.Bio,
+			AvatarURL:        user.AvatarUrl,
+			TwoFactorEnabled: twoFactor,
+		},
+		sessions:     []exportedSession{},
+		subscription: exportedSubscription{IsChirpyRed: user.IsChirpyRed.Bool},
+	}
+	if user.DeleteAfter.Valid {
+		export.profile.DeleteAfter = &user.DeleteAfter.Time
+	}
+	// A session is a token family; each refresh adds a token to it. Tokens
+	// come oldest first, so the last one of a family says how the session
+	// stands. Token hashes stay out: they are credentials, not personal data.
+	families := map[uuid.UUID]int{}
+	for _, rt := range tokens {
+		i, ok := families[rt.FamilyID]
+		if !ok {
+			i = len(export.sessions)
+			families[rt.FamilyID] = i
+			export.sessions = append(export.sessions, exportedSession{
+				SessionID: rt.FamilyID,
+				CreatedAt: rt.CreatedAt.Time,
+			})
+		}
+		s := &export.sessions[i]
+		s.LastUsedAt = rt.CreatedAt.Time
+		s.ExpiresAt = rt.ExpiresAt.Time
+		s.UserAgent = rt.UserAgent.String
+		s.IPAddress = rt.IpAddress.String
+		s.RevokedAt = nil
+		if rt.RevokedAt.Valid {
+			s.RevokedAt = &rt.RevokedAt.Time
+		}
+	}
+	return export, nil
+}
+
+// writeExport streams the export to w as a zip archive, one JSON file per
+// kind of data, dated modified.
+func (cfg *apiConfig) writeExport(ctx context.Context, w io.Writer, e userExport, modified time.Time) error {
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{"profile.json", encodeExportFile(e.profile)},
+		{"chirps.json", func(w io.Writer) error { return cfg.writeExportChirps(ctx, w, e.userId) }},
+		{"sessions.json", encodeExportFile(e.sessions)},
+		{"subscription.json", encodeExportFile(e.subscription)},
+	}
+	for _, file := range files {
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: modified,
+		})
+		if err != nil {
+			return err
+		}
+		if err := file.write(f); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func encodeExportFile(data any) func(io.Writer) error {
+	return func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(data)
+	}
+}
+
+// writeExportChirps writes the user's chirps, oldest first, as a JSON array
+// laid out like encodeExportFile's. It pages through them with the same
+// keyset as GET /api/chirps, encoding each page before reading the next.
+func (cfg *apiConfig) writeExportChirps(ctx context.Context, w io.Writer, userId uuid.UUID) error {
+	params := database.ListChirpsAscParams{
+		AuthorID: uuid.NullUUID{UUID: userId, Valid: true},
+		PageSize: exportChirpPageSize,
+	}
+	sep := "[\n  "
+	for {
+		chirps, err := cfg.db.ListChirpsAsc(ctx, params)
+		if err != nil {
+			return err
+		}
+		for _, c := range chirps {
+			data, err := json.MarshalIndent(newChirpResponse(c), "  ", "  ")
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, sep); err != nil {
+				return err
+			}
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+			sep = ",\n  "
+		}
+		if len(chirps) < int(params.PageSize) {
+			break
+		}
+		last := chirps[len(chirps)-1]
+		params.CursorCreatedAt = last.CreatedAt
+		params.CursorID = uuid.NullUUID{UUID: last.ID, Valid: true}
+	}
+
+	end := "\n]\n"
+	if sep == "[\n  " {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(w, end)
+	return err
+}
+
+func exportStatusPath(id uuid.UUID) string {
+	return "/api/users/me/export/" + id.String()
+}
+
+func newExportJobResponse(job exportJob) exportJobResponse {
+	result := exportJobResponse{
+		ID:        job.ID,
+		Status:    job.Status,
+		CreatedAt: job.CreatedAt,
+		ExpiresAt: job.ExpiresAt,
+	}
+	if job.Status == exportReady {
+		result.DownloadURL = exportStatusPath(job.ID) + "/download"
+	}
+	return result
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/jcuello/chirpy/internal/auth"
+	"github.com/jcuello/chirpy/internal/database"
+)
+
+// followTarget resolves the user in the path for the authenticated caller. It
+// writes the error response itself and reports whether the caller may go on.
+func (cfg *apiConfig) followTarget(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userId, _ := auth.UserIDFromContext(r.Context())
+
+	targetID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid userID")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	if targetID == userId {
+		respondWithError(w, 400, "You cannot follow yourself")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userId, targetID, true
+}
+
+func (cfg *apiConfig) handleFollow(w http.ResponseWriter, r *http.Request) {
+	userId, targetID, ok := cfg.followTarget(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := cfg.db.GetUserByID(r.Context(), targetID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, 404, "User not found")
+		} else {
+			respondWithInternalServerError(w)
+		}
+		return
+	}
+
+	err = cfg.db.FollowUser(r.Context(), database.FollowUserParams{
+		FollowerID: userId,
+		FolloweeID: targetID,
+	})
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	respondWithJson(w, 204, struct{}{})
+}
+
+func (cfg *apiConfig) handleUnfollow(w http.ResponseWriter, r *http.Request) {
+	userId, targetID, ok := cfg.followTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.UnfollowUser(r.Context(), database.UnfollowUserParams{
+		FollowerID: userId,
+		FolloweeID: targetID,
+	})
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	respondWithJson(w, 204, struct{}{})
+}
+
+func (cfg *apiConfig) handleGetFollowers(w http.ResponseWriter, r *http.Request) {
+	cfg.listFollows(w, r, func(params followListParams) ([]database.Follow, error) {
+		return cfg.db.ListFollowers(r.Context(), database.ListFollowersParams(params))
+	}, func(f database.Follow) uuid.UUID {
+		return f.FollowerID
+	})
+}
+
+func (cfg *apiConfig) handleGetFollowing(w http.ResponseWriter, r *http.Request) {
+	cfg.listFollows(w, r, func(params followListParams) ([]database.Follow, error) {
+		return cfg.db.ListFollowing(r.Context(), database.ListFollowingParams(params))
+	}, func(f database.Follow) uuid.UUID {
+		return f.FolloweeID
+	})
+}
+
+// followListParams has the shape shared by ListFollowers and ListFollowing.
+type followListParams struct {
+	UserID          uuid.UUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageSize        int32
+}
+
+// listFollows serves a page of followers or followees. other picks the user on
+// the far side of each follow row.
+func (cfg *apiConfig) listFollows(w http.ResponseWriter, r *http.Request, list func(followListParams) ([]database.Follow, error), other func(database.Follow) uuid.UUID) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid userID")
+		return
+	}
+
+	limit, cursor, err := pageParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	params := followListParams{
+		UserID:   userID,
+		PageSize: int32(limit + 1),
+	}
+	if cursor != nil {
+		params.CursorCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		params.CursorID = uuid.NullUUID{UUID: cursor.ID, Valid: true}
+	}
+
+	follows, err := list(params)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	page := followPage{Users: []followEntry{}}
+	if len(follows) > limit {
+		follows = follows[:limit]
+		last := follows[len(follows)-1]
+		page.NextCursor = chirpCursor{CreatedAt: last.CreatedAt, ID: other(last)}.encode()
+	}
+
+	for _, f := range follows {
+		page.Users = append(page.Users, followEntry{
+			UserID:     other(f),
+			FollowedAt: f.CreatedAt,
+		})
+	}
+
+	respondWithJson(w, 200, page)
+}
+
+// handleGetTimeline serves the caller's chirps and those of everyone they
+// follow, newest first.
+func (cfg *apiConfig) handleGetTimeline(w http.ResponseWriter, r *http.Request) {
+	userId, _ := auth.UserIDFromContext(r.Context())
+
+	limit, cursor, err := pageParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	params := database.ListTimelineParams{
+		UserID:   uuid.NullUUID{UUID: userId, Valid: true},
+		PageSize: int32(limit + 1),
+	}
+	if cursor != nil {
+		params.CursorCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		params.CursorID = uuid.NullUUID{UUID: cursor.ID, Valid: true}
+	}
+
+	chirps, err := cfg.db.ListTimeline(r.Context(), params)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	page := chirpPage{}
+	chirps, page.NextCursor = trimPage(chirps, limit)
+
+	page.Chirps, err = cfg.chirpResponses(r.Context(), chirps, params.UserID, expandAuthor(r))
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	respondWithJson(w, 200, page)
+}
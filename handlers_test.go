@@ -0,0 +1,1382 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jcuello/chirpy/internal/auth"
+	"github.com/jcuello/chirpy/internal/database"
+	"github.com/jcuello/chirpy/internal/mail"
+	"github.com/jcuello/chirpy/internal/mail/mailtest"
+	"github.com/jcuello/chirpy/internal/moderation"
+	"github.com/jcuello/chirpy/internal/password"
+	"github.com/jcuello/chirpy/internal/throttle"
+)
+
+// tickingClock moves forward a millisecond on every read so rows created in
+// quick succession still get distinct, ordered timestamps.
+type tickingClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *tickingClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(time.Millisecond)
+	return c.now
+}
+
+func (c *tickingClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// testPasswordParams make each hash take microseconds instead of a good part
+// of a second.
+var testPasswordParams = auth.PasswordParams{Memory: 64, Iterations: 1, Parallelism: 1}
+
+func newTestServer(t *testing.T) (*apiConfig, *httptest.Server) {
+	t.Helper()
+	clock := &tickingClock{now: time.Now().UTC()}
+	_, signingKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.NewKeySet(signingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := newAPIConfig(database.NewMemoryStoreWithClock(clock.Now), keys, "test-polka-key", "dev")
+	cfg.now = clock.Now
+	cfg.mailer = mail.NewLogMailer(io.Discard, "chirpy@example.com")
+	cfg.passwords = auth.NewPasswordHasher(testPasswordParams)
+	// Most tests use short passwords; TestPasswordPolicy covers the policy.
+	cfg.passwordPolicy = password.Policy{}
+	srv := httptest.NewServer(cfg.handler())
+	t.Cleanup(srv.Close)
+	return cfg, srv
+}
+
+func doJSON(t *testing.T, srv *httptest.Server, method, path, token string, body any, out any) int {
+	t.Helper()
+
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req, err := http.NewRequest(method, srv.URL+path, &reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func createAndLogin(t *testing.T, srv *httptest.Server, email, password string) User {
+	t.Helper()
+
+	status := doJSON(t, srv, "POST", "/api/users", "", UserPost{Email: email, Password: password}, nil)
+	if status != 201 {
+		t.Fatalf("POST /api/users status = %d, want 201", status)
+	}
+
+	user := User{}
+	status = doJSON(t, srv, "POST", "/api/login", "", UserLogin{Email: email, Password: password}, &user)
+	if status != 200 {
+		t.Fatalf("POST /api/login status = %d, want 200", status)
+	}
+	return user
+}
+
+func TestPostAndListChirps(t *testing.T) {
+	_, srv := newTestServer(t)
+	user := createAndLogin(t, srv, "walt@example.com", "04234")
+
+	for _, body := range []string{"one", "two", "three"} {
+		status := doJSON(t, srv, "POST", "/api/chirps", user.Token, map[string]string{"body": body}, nil)
+		if status != 201 {
+			t.Fatalf("POST /api/chirps status = %d, want 201", status)
+		}
+	}
+
+	page := chirpPage{}
+	if status := doJSON(t, srv, "GET", "/api/chirps?limit=2&sort=desc", "", nil, &page); status != 200 {
+		t.Fatalf("GET /api/chirps status = %d, want 200", status)
+	}
+	if len(page.Chirps) != 2 || *page.Chirps[0].Body != "three" || page.NextCursor == "" {
+		t.Fatalf("first page = %+v", page)
+	}
+
+	next := chirpPage{}
+	doJSON(t, srv, "GET", "/api/chirps?limit=2&sort=desc&cursor="+page.NextCursor, "", nil, &next)
+	if len(next.Chirps) != 1 || *next.Chirps[0].Body != "one" || next.NextCursor != "" {
+		t.Fatalf("second page = %+v", next)
+	}
+}
+
+func TestPostChirpRequiresAuth(t *testing.T) {
+	_, srv := newTestServer(t)
+
+	status := doJSON(t, srv, "POST", "/api/chirps", "", map[string]string{"body": "hi"}, nil)
+	if status != 401 {
+		t.Errorf("POST /api/chirps without token status = %d, want 401", status)
+	}
+}
+
+func TestServersAreIsolated(t *testing.T) {
+	_, first := newTestServer(t)
+	_, second := newTestServer(t)
+
+	user := createAndLogin(t, first, "walt@example.com", "04234")
+	doJSON(t, first, "POST", "/api/chirps", user.Token, map[string]string{"body": "hello"}, nil)
+
+	page := chirpPage{}
+	doJSON(t, second, "GET", "/api/chirps", "", nil, &page)
+	if len(page.Chirps) != 0 {
+		t.Errorf("second server sees %d chirps, want 0", len(page.Chirps))
+	}
+}
+
+func TestChirpThreadKeepsTombstone(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	user := createAndLogin(t, srv, "walt@example.com", "04234")
+
+	root := chirpCreated{}
+	doJSON(t, srv, "POST", "/api/chirps", user.Token, map[string]any{"body": "root"}, &root)
+	reply := chirpCreated{}
+	status := doJSON(t, srv, "POST", "/api/chirps", user.Token, map[string]any{"body": "reply", "in_reply_to": root.Id}, &reply)
+	if status != 201 || reply.InReplyTo == nil || *reply.InReplyTo != root.Id {
+		t.Fatalf("reply status = %d, chirp = %+v", status, reply)
+	}
+	nested := chirpCreated{}
+	doJSON(t, srv, "POST", "/api/chirps", user.Token, map[string]any{"body": "nested", "in_reply_to": reply.Id}, &nested)
+
+	status = doJSON(t, srv, "POST", "/api/chirps", user.Token, map[string]any{"body": "orphan", "in_reply_to": uuid.New()}, nil)
+	if status != 400 {
+		t.Errorf("reply to missing chirp status = %d, want 400", status)
+	}
+
+	if status := doJSON(t, srv, "DELETE", "/api/chirps/"+root.Id.String(), user.Token, nil, nil); status != 204 {
+		t.Fatalf("DELETE root status = %d, want 204", status)
+	}
+
+	thread := chirpThread{}
+	if status := doJSON(t, srv, "GET", "/api/chirps/"+nested.Id.String()+"/thread", "", nil, &thread); status != 200 {
+		t.Fatalf("GET thread status = %d, want 200", status)
+	}
+	if len(thread.Ancestors) != 2 {
+		t.Fatalf("ancestors = %+v, want 2", thread.Ancestors)
+	}
+	if tombstone := thread.Ancestors[0]; tombstone.Id != root.Id || !tombstone.Deleted || tombstone.Body != nil {
+		t.Errorf("root ancestor = %+v, want tombstone", tombstone)
+	}
+	if thread.Ancestors[1].ReplyCount != 1 {
+		t.Errorf("reply count = %d, want 1", thread.Ancestors[1].ReplyCount)
+	}
+
+	rootThread := chirpThread{}
+	doJSON(t, srv, "GET", "/api/chirps/"+root.Id.String()+"/thread?limit=1", "", nil, &rootThread)
+	if len(rootThread.Replies) != 1 || rootThread.Replies[0].Id != reply.Id || rootThread.NextCursor == "" {
+		t.Fatalf("root thread first page = %+v", rootThread)
+	}
+
+	// Once the last reply goes, the tombstones above it have nothing left to
+	// hold together and go too.
+	if status := doJSON(t, srv, "DELETE", "/api/chirps/"+reply.Id.String(), user.Token, nil, nil); status != 204 {
+		t.Fatalf("DELETE reply status = %d, want 204", status)
+	}
+	if status := doJSON(t, srv, "DELETE", "/api/chirps/"+nested.Id.String(), user.Token, nil, nil); status != 204 {
+		t.Fatalf("DELETE nested status = %d, want 204", status)
+	}
+	for name, id := range map[string]uuid.UUID{"root": root.Id, "reply": reply.Id} {
+		if _, err := cfg.db.GetChirp(context.Background(), id); err != sql.ErrNoRows {
+			t.Errorf("%s tombstone after its last reply was deleted: error = %v, want sql.ErrNoRows", name, err)
+		}
+	}
+}
+
+func TestLikes(t *testing.T) {
+	_, srv := newTestServer(t)
+	author := createAndLogin(t, srv, "walt@example.com", "04234")
+	fan := createAndLogin(t, srv, "jesse@example.com", "yo")
+
+	chirp := chirpCreated{}
+	doJSON(t, srv, "POST", "/api/chirps", author.Token, map[string]any{"body": "say my name"}, &chirp)
+	path := "/api/chirps/" + chirp.Id.String()
+
+	if status := doJSON(t, srv, "POST", path+"/likes", "", nil, nil); status != 401 {
+		t.Errorf("anonymous like status = %d, want 401", status)
+	}
+	for i := 0; i < 2; i++ {
+		if status := doJSON(t, srv, "POST", path+"/likes", fan.Token, nil, nil); status != 204 {
+			t.Fatalf("like status = %d, want 204", status)
+		}
+	}
+
+	got := chirpCreated{}
+	doJSON(t, srv, "GET", path, fan.Token, nil, &got)
+	if got.LikeCount != 1 || got.LikedByMe == nil || !*got.LikedByMe {
+		t.Errorf("fan view = %+v, want like_count 1 and liked_by_me", got)
+	}
+
+	got = chirpCreated{}
+	doJSON(t, srv, "GET", path, "", nil, &got)
+	if got.LikeCount != 1 || got.LikedByMe != nil {
+		t.Errorf("anonymous view = %+v, want like_count 1 without liked_by_me", got)
+	}
+
+	page := chirpPage{}
+	doJSON(t, srv, "GET", "/api/users/"+fan.ID.String()+"/likes", "", nil, &page)
+	if len(page.Chirps) != 1 || page.Chirps[0].Id != chirp.Id {
+		t.Errorf("liked chirps = %+v", page.Chirps)
+	}
+
+	doJSON(t, srv, "DELETE", path+"/likes", fan.Token, nil, nil)
+	got = chirpCreated{}
+	doJSON(t, srv, "GET", path, fan.Token, nil, &got)
+	if got.LikeCount != 0 || *got.LikedByMe {
+		t.Errorf("after unlike = %+v", got)
+	}
+}
+
+func TestFollowAndTimeline(t *testing.T) {
+	_, srv := newTestServer(t)
+	walt := createAndLogin(t, srv, "walt@example.com", "04234")
+	jesse := createAndLogin(t, srv, "jesse@example.com", "yo")
+	saul := createAndLogin(t, srv, "saul@example.com", "better")
+
+	doJSON(t, srv, "POST", "/api/chirps", walt.Token, map[string]any{"body": "walt"}, nil)
+	doJSON(t, srv, "POST", "/api/chirps", saul.Token, map[string]any{"body": "saul"}, nil)
+	doJSON(t, srv, "POST", "/api/chirps", jesse.Token, map[string]any{"body": "jesse"}, nil)
+
+	if status := doJSON(t, srv, "POST", "/api/users/"+jesse.ID.String()+"/follow", jesse.Token, nil, nil); status != 400 {
+		t.Errorf("self follow status = %d, want 400", status)
+	}
+	if status := doJSON(t, srv, "POST", "/api/users/"+walt.ID.String()+"/follow", jesse.Token, nil, nil); status != 204 {
+		t.Fatalf("follow status = %d, want 204", status)
+	}
+
+	timeline := chirpPage{}
+	if status := doJSON(t, srv, "GET", "/api/timeline", jesse.Token, nil, &timeline); status != 200 {
+		t.Fatalf("GET /api/timeline status = %d, want 200", status)
+	}
+	if len(timeline.Chirps) != 2 || *timeline.Chirps[0].Body != "jesse" || *timeline.Chirps[1].Body != "walt" {
+		t.Errorf("timeline = %+v, want jesse then walt", timeline.Chirps)
+	}
+
+	followers := followPage{}
+	doJSON(t, srv, "GET", "/api/users/"+walt.ID.String()+"/followers", "", nil, &followers)
+	if len(followers.Users) != 1 || followers.Users[0].UserID != jesse.ID {
+		t.Errorf("followers = %+v, want jesse", followers.Users)
+	}
+
+	doJSON(t, srv, "DELETE", "/api/users/"+walt.ID.String()+"/follow", jesse.Token, nil, nil)
+	following := followPage{}
+	doJSON(t, srv, "GET", "/api/users/"+jesse.ID.String()+"/following", "", nil, &following)
+	if len(following.Users) != 0 {
+		t.Errorf("following after unfollow = %+v, want none", following.Users)
+	}
+}
+
+func TestEditChirpKeepsRevisions(t *testing.T) {
+	_, srv := newTestServer(t)
+	author := createAndLogin(t, srv, "walt@example.com", "04234")
+	other := createAndLogin(t, srv, "jesse@example.com", "yo")
+
+	chirp := chirpCreated{}
+	doJSON(t, srv, "POST", "/api/chirps", author.Token, map[string]any{"body": "first draft"}, &chirp)
+	path := "/api/chirps/" + chirp.Id.String()
+
+	if status := doJSON(t, srv, "PUT", path, other.Token, map[string]any{"body": "hijacked"}, nil); status != 403 {
+		t.Errorf("PUT by another user status = %d, want 403", status)
+	}
+
+	edited := chirpCreated{}
+	if status := doJSON(t, srv, "PUT", path, author.Token, map[string]any{"body": "final kerfuffle"}, &edited); status != 200 {
+		t.Fatalf("PUT status = %d, want 200", status)
+	}
+	if !edited.Edited || *edited.Body != "final ****" {
+		t.Errorf("edited chirp = %+v", edited)
+	}
+
+	revisions := chirpRevisions{}
+	doJSON(t, srv, "GET", path+"/revisions", "", nil, &revisions)
+	if len(revisions.Revisions) != 1 || revisions.Revisions[0].Body != "first draft" {
+		t.Errorf("revisions = %+v, want the first draft", revisions.Revisions)
+	}
+}
+
+func TestPostChirpRejectedByModeration(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	filter, err := moderation.New(context.Background(), moderation.ModeReject, moderation.DefaultWords)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.moderation = filter
+	user := createAndLogin(t, srv, "walt@example.com", "04234")
+
+	if status := doJSON(t, srv, "POST", "/api/chirps", user.Token, map[string]any{"body": "what a kerfuffle!"}, nil); status != 400 {
+		t.Errorf("POST rejected chirp status = %d, want 400", status)
+	}
+	if status := doJSON(t, srv, "POST", "/api/chirps", user.Token, map[string]any{"body": "all good"}, nil); status != 201 {
+		t.Errorf("POST clean chirp status = %d, want 201", status)
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	_, srv := newTestServer(t)
+	user := createAndLogin(t, srv, "walt@example.com", "04234")
+
+	first := tokenPair{}
+	if status := doJSON(t, srv, "POST", "/api/refresh", user.RefreshToken, nil, &first); status != 200 {
+		t.Fatalf("refresh status = %d, want 200", status)
+	}
+	if first.Token == "" || first.RefreshToken == "" || first.RefreshToken == user.RefreshToken {
+		t.Fatalf("refresh did not rotate: %+v", first)
+	}
+
+	second := tokenPair{}
+	if status := doJSON(t, srv, "POST", "/api/refresh", first.RefreshToken, nil, &second); status != 200 {
+		t.Fatalf("second refresh status = %d, want 200", status)
+	}
+
+	// Replaying the original token revokes the whole family.
+	if status := doJSON(t, srv, "POST", "/api/refresh", user.RefreshToken, nil, nil); status != 401 {
+		t.Errorf("reused token status = %d, want 401", status)
+	}
+	if status := doJSON(t, srv, "POST", "/api/refresh", second.RefreshToken, nil, nil); status != 401 {
+		t.Errorf("latest token after reuse status = %d, want 401", status)
+	}
+}
+
+func TestRefreshTokensStoredHashed(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	user := createAndLogin(t, srv, "gus@example.com", "04234")
+
+	id, secret, err := auth.SplitRefreshToken(user.RefreshToken)
+	if err != nil {
+		t.Fatalf("SplitRefreshToken() error = %v", err)
+	}
+	stored, err := cfg.db.GetRefreshToken(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetRefreshToken(id) error = %v", err)
+	}
+	if stored.UserID.UUID != user.ID {
+		t.Errorf("stored token user = %v, want %v", stored.UserID.UUID, user.ID)
+	}
+	if stored.TokenHash != auth.HashToken(secret) {
+		t.Errorf("stored token hash = %q, want the digest of the secret", stored.TokenHash)
+	}
+
+	// The id alone is not enough to use the token.
+	forged := auth.JoinRefreshToken(id, strings.Repeat("0", len(secret)))
+	if status := doJSON(t, srv, "POST", "/api/refresh", forged, nil, nil); status != 401 {
+		t.Errorf("refresh with a wrong secret = %d, want 401", status)
+	}
+	if status := doJSON(t, srv, "POST", "/api/revoke", forged, nil, nil); status != 401 {
+		t.Errorf("revoke with a wrong secret = %d, want 401", status)
+	}
+	if status := doJSON(t, srv, "POST", "/api/refresh", user.RefreshToken, nil, nil); status != 200 {
+		t.Errorf("refresh after forged attempts = %d, want 200", status)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	_, srv := newTestServer(t)
+	user := createAndLogin(t, srv, "saul@example.com", "04234")
+
+	laptop := User{}
+	if status := doJSON(t, srv, "POST", "/api/login", "", UserLogin{Email: "saul@example.com", Password: "04234"}, &laptop); status != 200 {
+		t.Fatalf("second login status = %d, want 200", status)
+	}
+
+	var raw map[string][]map[string]any
+	if status := doJSON(t, srv, "GET", "/api/sessions", user.Token, nil, &raw); status != 200 {
+		t.Fatalf("GET /api/sessions status = %d, want 200", status)
+	}
+	if len(raw["sessions"]) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(raw["sessions"]))
+	}
+	for _, s := range raw["sessions"] {
+		for _, v := range s {
+			if v == user.RefreshToken || v == laptop.RefreshToken {
+				t.Fatalf("session exposes a refresh token: %v", s)
+			}
+		}
+		if s["ip_address"] != "127.0.0.1" {
+			t.Errorf("ip_address = %v, want 127.0.0.1", s["ip_address"])
+		}
+	}
+
+	// Rotating keeps the session id, so the list still has two entries.
+	if status := doJSON(t, srv, "POST", "/api/refresh", laptop.RefreshToken, nil, &tokenPair{}); status != 200 {
+		t.Fatalf("refresh status = %d, want 200", status)
+	}
+	list := sessionList{}
+	doJSON(t, srv, "GET", "/api/sessions", user.Token, nil, &list)
+	if len(list.Sessions) != 2 {
+		t.Fatalf("got %d sessions after refresh, want 2", len(list.Sessions))
+	}
+	newest := list.Sessions[0]
+	if !newest.LastUsedAt.After(newest.CreatedAt) {
+		t.Errorf("last_used_at %v not after created_at %v", newest.LastUsedAt, newest.CreatedAt)
+	}
+
+	other := createAndLogin(t, srv, "kim@example.com", "04234")
+	if status := doJSON(t, srv, "DELETE", "/api/sessions/"+newest.ID.String(), other.Token, nil, nil); status != 404 {
+		t.Errorf("deleting someone else's session status = %d, want 404", status)
+	}
+	if status := doJSON(t, srv, "DELETE", "/api/sessions/"+newest.ID.String(), user.Token, nil, nil); status != 204 {
+		t.Fatalf("DELETE session status = %d, want 204", status)
+	}
+	doJSON(t, srv, "GET", "/api/sessions", user.Token, nil, &list)
+	if len(list.Sessions) != 1 {
+		t.Fatalf("got %d sessions after delete, want 1", len(list.Sessions))
+	}
+
+	if status := doJSON(t, srv, "DELETE", "/api/sessions", user.Token, nil, nil); status != 204 {
+		t.Fatalf("DELETE /api/sessions status = %d, want 204", status)
+	}
+	if status := doJSON(t, srv, "POST", "/api/refresh", user.RefreshToken, nil, nil); status != 401 {
+		t.Errorf("refresh after logout everywhere status = %d, want 401", status)
+	}
+	if status := doJSON(t, srv, "POST", "/api/refresh", other.RefreshToken, nil, &tokenPair{}); status != 200 {
+		t.Errorf("other user's refresh status = %d, want 200", status)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	user := createAndLogin(t, srv, "mike@example.com", "04234")
+
+	jwks := auth.JWKSet{}
+	if status := doJSON(t, srv, "GET", "/.well-known/jwks.json", "", nil, &jwks); status != 200 {
+		t.Fatalf("GET jwks status = %d, want 200", status)
+	}
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != cfg.keys.SigningKeyID() || jwks.Keys[0].Alg != "EdDSA" {
+		t.Fatalf("jwks = %+v", jwks)
+	}
+
+	// The published key alone is enough to verify an access token.
+	raw, err := base64.RawURLEncoding.DecodeString(jwks.Keys[0].X)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Parse(user.Token, func(t *jwt.Token) (any, error) {
+		return ed25519.PublicKey(raw), nil
+	}, jwt.WithValidMethods([]string{jwks.Keys[0].Alg}))
+	if err != nil || !token.Valid {
+		t.Errorf("access token did not verify against the JWKS: %v", err)
+	}
+	if token.Header["kid"] != jwks.Keys[0].Kid {
+		t.Errorf("token kid = %v, want %v", token.Header["kid"], jwks.Keys[0].Kid)
+	}
+}
+
+func TestAccessTokenScopes(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	cfg.adminEmails = []string{"Hank@example.com"}
+	user := createAndLogin(t, srv, "marie@example.com", "04234")
+	admin := createAndLogin(t, srv, "hank@example.com", "04234")
+
+	if status := doJSON(t, srv, "POST", "/admin/moderation/reload", admin.Token, nil, nil); status != 403 {
+		t.Errorf("reload as unverified admin status = %d, want 403", status)
+	}
+	_, err := cfg.db.VerifyUserEmail(context.Background(), database.VerifyUserEmailParams{
+		ID:    admin.ID,
+		Email: sql.NullString{String: "hank@example.com", Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := doJSON(t, srv, "POST", "/api/login", "", UserLogin{Email: "hank@example.com", Password: "04234"}, &admin); status != 200 {
+		t.Fatalf("login status = %d, want 200", status)
+	}
+
+	claims, err := auth.ValidateJWT(user.Token, cfg.keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !claims.HasScope(auth.ScopeChirpsWrite) || claims.HasScope(auth.ScopeAdmin) {
+		t.Errorf("user scopes = %v", claims.Scopes())
+	}
+
+	if status := doJSON(t, srv, "POST", "/admin/moderation/reload", user.Token, nil, nil); status != 403 {
+		t.Errorf("reload as user status = %d, want 403", status)
+	}
+	if status := doJSON(t, srv, "POST", "/admin/moderation/reload", admin.Token, nil, nil); status != 204 {
+		t.Errorf("reload as admin status = %d, want 204", status)
+	}
+
+	readOnly, err := auth.MakeJWT(auth.NewClaims(user.ID), cfg.keys, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := doJSON(t, srv, "POST", "/api/chirps", readOnly, map[string]any{"body": "hi"}, nil); status != 403 {
+		t.Errorf("POST /api/chirps without chirps:write status = %d, want 403", status)
+	}
+}
+
+func TestLoginThrottling(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	cfg.accountThrottle = throttle.New(dbThrottleStore{cfg.db}, throttle.DefaultAccountPolicy)
+	createAndLogin(t, srv, "tuco@example.com", "04234")
+
+	wrong := UserLogin{Email: "tuco@example.com", Password: "nope"}
+	for i := range throttle.DefaultAccountPolicy.FreeAttempts + 1 {
+		if status := doJSON(t, srv, "POST", "/api/login", "", wrong, nil); status != 401 {
+			t.Fatalf("attempt %d status = %d, want 401", i+1, status)
+		}
+	}
+
+	body, _ := json.Marshal(UserLogin{Email: "tuco@example.com", Password: "04234"})
+	resp, err := srv.Client().Post(srv.URL+"/api/login", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 429 || resp.Header.Get("Retry-After") != "1" {
+		t.Errorf("throttled login = %d, Retry-After %q, want 429 and 1", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	// Unknown accounts count against the address as well.
+	if status := doJSON(t, srv, "POST", "/api/login", "", UserLogin{Email: "nobody@example.com", Password: "x"}, nil); status != 401 {
+		t.Errorf("unknown email status = %d, want 401", status)
+	}
+}
+
+func TestLoginThrottlingConcurrent(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	cfg.accountThrottle = throttle.New(dbThrottleStore{cfg.db}, throttle.DefaultAccountPolicy)
+	createAndLogin(t, srv, "tuco@example.com", "04234")
+
+	// Guesses sent all at once must not all get in before the first fails.
+	const guesses = 10
+	statuses := make(chan int, guesses)
+	var wg sync.WaitGroup
+	for range guesses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses <- doJSON(t, srv, "POST", "/api/login", "", UserLogin{Email: "tuco@example.com", Password: "nope"}, nil)
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	checked := 0
+	for status := range statuses {
+		switch status {
+		case 401:
+			checked++
+		case 429:
+		default:
+			t.Errorf("status = %d, want 401 or 429", status)
+		}
+	}
+	if want := throttle.DefaultAccountPolicy.FreeAttempts + 1; checked != want {
+		t.Errorf("%d of %d parallel guesses were checked, want %d", checked, guesses, want)
+	}
+}
+
+// useMailServer sends cfg's mail to an in-process SMTP server.
+func useMailServer(t *testing.T, cfg *apiConfig) *mailtest.Server {
+	t.Helper()
+	server := mailtest.NewServer()
+	t.Cleanup(server.Close)
+	cfg.mailer = &mail.SMTPMailer{Addr: server.Addr, From: "chirpy@example.com"}
+	return server
+}
+
+// mailedToken pulls the token query parameter out of the last email sent to
+// to.
+func mailedToken(t *testing.T, server *mailtest.Server, to string) string {
+	t.Helper()
+	messages := server.Messages()
+	for i := len(messages) - 1; i >= 0; i-- {
+		if len(messages[i].To) == 0 || messages[i].To[0] != to {
+			continue
+		}
+		_, rest, ok := strings.Cut(messages[i].Body, "token=")
+		if !ok {
+			break
+		}
+		token, _, _ := strings.Cut(rest, "\n")
+		unescaped, err := url.QueryUnescape(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return unescaped
+	}
+	t.Fatalf("no token mailed to %s", to)
+	return ""
+}
+
+func TestEmailVerification(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	cfg.requireVerifiedEmail = true
+	mailServer := useMailServer(t, cfg)
+
+	if status := doJSON(t, srv, "POST", "/api/users", "", UserPost{Email: "Lydia <lydia@example.com>", Password: "04234"}, nil); status != 400 {
+		t.Errorf("display-name email status = %d, want 400", status)
+	}
+
+	user := createAndLogin(t, srv, "lydia@example.com", "04234")
+	if user.EmailVerified {
+		t.Error("new user already verified")
+	}
+	if status := doJSON(t, srv, "POST", "/api/chirps", user.Token, map[string]any{"body": "hi"}, nil); status != 403 {
+		t.Errorf("unverified POST /api/chirps status = %d, want 403", status)
+	}
+
+	token := mailedToken(t, mailServer, "lydia@example.com")
+	if status := doJSON(t, srv, "GET", "/api/users/verify?token=garbage", "", nil, nil); status != 400 {
+		t.Errorf("bad token status = %d, want 400", status)
+	}
+	if status := doJSON(t, srv, "GET", "/api/users/verify?token="+url.QueryEscape(token), "", nil, nil); status != 200 {
+		t.Fatalf("verify status = %d, want 200", status)
+	}
+
+	if status := doJSON(t, srv, "POST", "/api/chirps", user.Token, map[string]any{"body": "hi"}, nil); status != 201 {
+		t.Errorf("verified POST /api/chirps status = %d, want 201", status)
+	}
+	if status := doJSON(t, srv, "POST", "/api/users/verify", user.Token, nil, nil); status != 409 {
+		t.Errorf("resend after verifying status = %d, want 409", status)
+	}
+}
+
+func TestResendVerificationThrottled(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	mailServer := useMailServer(t, cfg)
+
+	user := createAndLogin(t, srv, "lydia@example.com", "04234")
+	other := createAndLogin(t, srv, "todd@example.com", "04234")
+	change := userUpdate{Email: "jesse@example.com", CurrentPassword: "04234"}
+	if status := doJSON(t, srv, "PUT", "/api/users", user.Token, change, nil); status != 200 {
+		t.Fatalf("email change status = %d, want 200", status)
+	}
+	for i := range verificationEmailPolicy.FreeAttempts {
+		if status := doJSON(t, srv, "POST", "/api/users/verify", user.Token, nil, nil); status != 204 {
+			t.Fatalf("resend %d status = %d, want 204", i+1, status)
+		}
+	}
+
+	req, _ := http.NewRequest("POST", srv.URL+"/api/users/verify", nil)
+	req.Header.Set("Authorization", "Bearer "+user.Token)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 429 || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("resend past the limit = %d, Retry-After %q, want 429 and a wait", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	// Another account cannot pick up where this one stopped.
+	change.Email = "Jesse@example.com"
+	if status := doJSON(t, srv, "PUT", "/api/users", other.Token, change, nil); status != 429 {
+		t.Errorf("email change to a throttled address status = %d, want 429", status)
+	}
+
+	// Two welcome emails, then every link to the new address that got through.
+	if n := len(mailServer.Messages()); n != 2+1+verificationEmailPolicy.FreeAttempts {
+		t.Errorf("sent %d emails, want %d", n, 2+1+verificationEmailPolicy.FreeAttempts)
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	mailServer := useMailServer(t, cfg)
+
+	createAndLogin(t, srv, "skyler@example.com", "04234")
+	laptop := createAndLogin(t, srv, "walt@example.com", "04234")
+	var phone User
+	if status := doJSON(t, srv, "POST", "/api/login", "", UserLogin{Email: "walt@example.com", Password: "04234"}, &phone); status != 200 {
+		t.Fatalf("login status = %d, want 200", status)
+	}
+
+	takeover := userUpdate{Email: "skyler@example.com", Password: "heisenberg", CurrentPassword: "04234"}
+	if status := doJSON(t, srv, "PUT", "/api/users", laptop.Token, takeover, nil); status != 409 {
+		t.Errorf("PUT with another user's email status = %d, want 409", status)
+	}
+	if status := doJSON(t, srv, "POST", "/api/login", "", UserLogin{Email: "skyler@example.com", Password: "04234"}, nil); status != 200 {
+		t.Errorf("other user's login status = %d, want 200", status)
+	}
+
+	if status := doJSON(t, srv, "PUT", "/api/users", laptop.Token, userUpdate{Password: "heisenberg"}, nil); status != 403 {
+		t.Errorf("PUT without current password status = %d, want 403", status)
+	}
+	if status := doJSON(t, srv, "PUT", "/api/users", laptop.Token, userUpdate{Password: "heisenberg", CurrentPassword: "nope"}, nil); status != 403 {
+		t.Errorf("PUT with wrong current password status = %d, want 403", status)
+	}
+	if status := doJSON(t, srv, "PUT", "/api/users", laptop.Token, userUpdate{Password: "heisenberg", CurrentPassword: "04234"}, nil); status != 200 {
+		t.Fatalf("password change status = %d, want 200", status)
+	}
+	if status := doJSON(t, srv, "POST", "/api/refresh", laptop.RefreshToken, nil, nil); status != 200 {
+		t.Errorf("refresh in the changing session status = %d, want 200", status)
+	}
+	if status := doJSON(t, srv, "POST", "/api/refresh", phone.RefreshToken, nil, nil); status != 401 {
+		t.Errorf("refresh in another session status = %d, want 401", status)
+	}
+	if status := doJSON(t, srv, "POST", "/api/login", "", UserLogin{Email: "walt@example.com", Password: "heisenberg"}, nil); status != 200 {
+		t.Errorf("login with new password status = %d, want 200", status)
+	}
+
+	if status := doJSON(t, srv, "PUT", "/api/users", laptop.Token, userUpdate{Email: "heisenberg@example.com"}, nil); status != 403 {
+		t.Errorf("email change without current password status = %d, want 403", status)
+	}
+	var updated struct {
+		Email        string `json:"email"`
+		PendingEmail string `json:"pending_email"`
+	}
+	if status := doJSON(t, srv, "PUT", "/api/users", laptop.Token, userUpdate{Email: "heisenberg@example.com", CurrentPassword: "heisenberg"}, &updated); status != 200 {
+		t.Fatalf("email change status = %d, want 200", status)
+	}
+	if updated.Email != "walt@example.com" || updated.PendingEmail != "heisenberg@example.com" {
+		t.Errorf("updated user = %+v, want the new address pending", updated)
+	}
+
+	// Until the new address is verified, the old one stays in charge.
+	if status := doJSON(t, srv, "POST", "/api/login", "", UserLogin{Email: "heisenberg@example.com", Password: "heisenberg"}, nil); status != 401 {
+		t.Errorf("login with unverified new email status = %d, want 401", status)
+	}
+	token := mailedToken(t, mailServer, "heisenberg@example.com")
+	if status := doJSON(t, srv, "GET", "/api/users/verify?token="+url.QueryEscape(token), "", nil, nil); status != 200 {
+		t.Fatalf("verify new email status = %d, want 200", status)
+	}
+	if status := doJSON(t, srv, "POST", "/api/login", "", UserLogin{Email: "walt@example.com", Password: "heisenberg"}, nil); status != 401 {
+		t.Errorf("login with old email status = %d, want 401", status)
+	}
+	var relogged User
+	if status := doJSON(t, srv, "POST", "/api/login", "", UserLogin{Email: "heisenberg@example.com", Password: "heisenberg"}, &relogged); status != 200 {
+		t.Fatalf("login with new email status = %d, want 200", status)
+	}
+	if !relogged.EmailVerified {
+		t.Error("new email not verified after following the link")
+	}
+
+	if status := doJSON(t, srv, "POST", "/api/users", "", UserPost{Email: "Skyler@example.com", Password: "04234"}, nil); status != 409 {
+		t.Errorf("sign-up with a registered email in another case status = %d, want 409", status)
+	}
+}
+
+// waitForMail waits for server to have accepted n messages, for handlers
+// that send in the background.
+func waitForMail(t *testing.T, server *mailtest.Server, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(server.Messages()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d emails, want %d", len(server.Messages()), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	mailServer := useMailServer(t, cfg)
+
+	user := createAndLogin(t, srv, "gus@example.com", "04234")
+	waitForMail(t, mailServer, 1)
+
+	for _, email := range []string{"nobody@example.com", "Gus@Example.com"} {
+		if status := doJSON(t, srv, "POST", "/api/password-reset/request", "", passwordResetRequest{Email: email}, nil); status != 202 {
+			t.Errorf("request for %s status = %d, want 202", email, status)
+		}
+	}
+	waitForMail(t, mailServer, 2)
+	token := mailedToken(t, mailServer, "gus@example.com")
+
+	if status := doJSON(t, srv, "POST", "/api/password-reset/confirm", "", passwordResetConfirm{Token: "garbage", Password: "pollos"}, nil); status != 400 {
+		t.Errorf("bad token status = %d, want 400", status)
+	}
+	if status := doJSON(t, srv, "POST", "/api/password-reset/confirm", "", passwordResetConfirm{Token: token, Password: "pollos"}, nil); status != 204 {
+		t.Fatalf("confirm status = %d, want 204", status)
+	}
+	if status := doJSON(t, srv, "POST", "/api/password-reset/confirm", "", passwordResetConfirm{Token: token, Password: "hermanos"}, nil); status != 400 {
+		t.Errorf("reused token status = %d, want 400", status)
+	}
+
+	if status := doJSON(t, srv, "POST", "/api/refresh", user.RefreshToken, nil, nil); status != 401 {
+		t.Errorf("refresh after reset status = %d, want 401", status)
+	}
+	if status := doJSON(t, srv, "POST", "/api/login", "", UserLogin{Email: "gus@example.com", Password: "04234"}, nil); status != 401 {
+		t.Errorf("login with old password status = %d, want 401", status)
+	}
+	if status := doJSON(t, srv, "POST", "/api/login", "", UserLogin{Email: "gus@example.com", Password: "pollos"}, nil); status != 200 {
+		t.Errorf("login with new password status = %d, want 200", status)
+	}
+
+	if n := len(mailServer.Messages()); n != 2 {
+		t.Errorf("sent %d emails, want 2", n)
+	}
+
+	// Requests count per address whatever its case, registered or not.
+	for i := 1; i < passwordResetEmailPolicy.FreeAttempts; i++ {
+		if status := doJSON(t, srv, "POST", "/api/password-reset/request", "", passwordResetRequest{Email: "gus@example.com"}, nil); status != 202 {
+			t.Errorf("request %d status = %d, want 202", i+1, status)
+		}
+	}
+	for _, email := range []string{"GUS@example.com", "nobody@example.com"} {
+		if status := doJSON(t, srv, "POST", "/api/password-reset/request", "", passwordResetRequest{Email: email}, nil); status != 202 {
+			t.Errorf("last free request for %s status = %d, want 202", email, status)
+		}
+	}
+	if status := doJSON(t, srv, "POST", "/api/password-reset/request", "", passwordResetRequest{Email: "gus@example.com"}, nil); status != 429 {
+		t.Errorf("request past the limit status = %d, want 429", status)
+	}
+}
+
+func TestPasswordChangeSpendsResetLinks(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	mailServer := useMailServer(t, cfg)
+
+	user := createAndLogin(t, srv, "gus@example.com", "04234")
+	waitForMail(t, mailServer, 1)
+	if status := doJSON(t, srv, "POST", "/api/password-reset/request", "", passwordResetRequest{Email: "gus@example.com"}, nil); status != 202 {
+		t.Fatalf("request status = %d, want 202", status)
+	}
+	waitForMail(t, mailServer, 2)
+	token := mailedToken(t, mailServer, "gus@example.com")
+
+	if status := doJSON(t, srv, "PUT", "/api/users", user.Token, userUpdate{Password: "pollos", CurrentPassword: "04234"}, nil); status != 200 {
+		t.Fatalf("password change status = %d, want 200", status)
+	}
+	if status := doJSON(t, srv, "POST", "/api/password-reset/confirm", "", passwordResetConfirm{Token: token, Password: "hermanos"}, nil); status != 400 {
+		t.Errorf("reset link sent before the change status = %d, want 400", status)
+	}
+	if status := doJSON(t, srv, "POST", "/api/login", "", UserLogin{Email: "gus@example.com", Password: "pollos"}, nil); status != 200 {
+		t.Errorf("login with changed password status = %d, want 200", status)
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	clock := &tickingClock{now: time.Now().UTC()}
+	cfg.now = clock.Now
+	user := createAndLogin(t, srv, "jesse@example.com", "04234")
+
+	var enrollment totpEnrollment
+	if status := doJSON(t, srv, "POST", "/api/users/2fa", user.Token, nil, &enrollment); status != 200 {
+		t.Fatalf("enroll status = %d, want 200", status)
+	}
+	if !strings.HasPrefix(enrollment.OTPAuthURI, "otpauth://totp/") || !strings.Contains(enrollment.OTPAuthURI, enrollment.Secret) {
+		t.Errorf("otpauth_uri = %q", enrollment.OTPAuthURI)
+	}
+	code := func() string {
+		code, err := auth.TOTPCode(enrollment.Secret, clock.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	if status := doJSON(t, srv, "POST", "/api/users/2fa/confirm", user.Token, totpCode{Code: "abcdef"}, nil); status != 400 {
+		t.Errorf("confirm with bad code status = %d, want 400", status)
+	}
+	var recovery recoveryCodeList
+	if status := doJSON(t, srv, "POST", "/api/users/2fa/confirm", user.Token, totpCode{Code: code()}, &recovery); status != 200 {
+		t.Fatalf("confirm status = %d, want 200", status)
+	}
+	if len(recovery.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(recovery.RecoveryCodes), recoveryCodeCount)
+	}
+	if status := doJSON(t, srv, "POST", "/api/users/2fa", user.Token, nil, nil); status != 409 {
+		t.Errorf("enroll again status = %d, want 409", status)
+	}
+
+	login := UserLogin{Email: "jesse@example.com", Password: "04234"}
+	var challenge mfaChallenge
+	if status := doJSON(t, srv, "POST", "/api/login", "", login, &challenge); status != 200 {
+		t.Fatalf("login status = %d, want 200", status)
+	}
+	if !challenge.MFARequired || challenge.MFAToken == "" {
+		t.Fatalf("login response = %+v, want an MFA challenge", challenge)
+	}
+	if status := doJSON(t, srv, "GET", "/api/timeline", challenge.MFAToken, nil, nil); status != 401 {
+		t.Errorf("MFA token as access token status = %d, want 401", status)
+	}
+
+	// The step used to confirm enrollment cannot be used again.
+	if status := doJSON(t, srv, "POST", "/api/login/mfa", "", mfaLogin{MFAToken: challenge.MFAToken, Code: code()}, nil); status != 401 {
+		t.Errorf("replayed code status = %d, want 401", status)
+	}
+	clock.Advance(30 * time.Second)
+	var session User
+	if status := doJSON(t, srv, "POST", "/api/login/mfa", "", mfaLogin{MFAToken: challenge.MFAToken, Code: code()}, &session); status != 200 {
+		t.Fatalf("MFA login status = %d, want 200", status)
+	}
+	if session.Token == "" || session.RefreshToken == "" {
+		t.Errorf("MFA login response = %+v, want tokens", session)
+	}
+
+	recoveryCode := strings.ToUpper(recovery.RecoveryCodes[0])
+	if status := doJSON(t, srv, "POST", "/api/login/mfa", "", mfaLogin{MFAToken: challenge.MFAToken, Code: recoveryCode}, nil); status != 200 {
+		t.Errorf("recovery code login status = %d, want 200", status)
+	}
+	if status := doJSON(t, srv, "POST", "/api/login/mfa", "", mfaLogin{MFAToken: challenge.MFAToken, Code: recoveryCode}, nil); status != 401 {
+		t.Errorf("reused recovery code status = %d, want 401", status)
+	}
+
+	if status := doJSON(t, srv, "DELETE", "/api/users/2fa", session.Token, totpCode{Code: "abcdef"}, nil); status != 403 {
+		t.Errorf("disable with bad code status = %d, want 403", status)
+	}
+	if status := doJSON(t, srv, "DELETE", "/api/users/2fa", session.Token, totpCode{Code: recovery.RecoveryCodes[1]}, nil); status != 204 {
+		t.Fatalf("disable status = %d, want 204", status)
+	}
+	var plain User
+	if status := doJSON(t, srv, "POST", "/api/login", "", login, &plain); status != 200 || plain.Token == "" {
+		t.Errorf("login after disabling status = %d, token = %q", status, plain.Token)
+	}
+}
+
+func TestPasswordRehashOnLogin(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	createAndLogin(t, srv, "mike@example.com", "04234")
+
+	stronger := testPasswordParams
+	stronger.Iterations = 2
+	cfg.passwords = auth.NewPasswordHasher(stronger)
+
+	storedHash := func() string {
+		user, err := cfg.db.GetUser(context.Background(), sql.NullString{String: "mike@example.com", Valid: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user.HashedPassword
+	}
+	old := storedHash()
+	if _, needsRehash, _ := cfg.passwords.CheckPasswordHash("04234", old); !needsRehash {
+		t.Fatal("hash made with the old params does not need rehashing")
+	}
+
+	if status := doJSON(t, srv, "POST", "/api/login", "", UserLogin{Email: "mike@example.com", Password: "nope"}, nil); status != 401 {
+		t.Fatalf("wrong password status = %d, want 401", status)
+	}
+	if storedHash() != old {
+		t.Error("a failed login rehashed the password")
+	}
+
+	if status := doJSON(t, srv, "POST", "/api/login", "", UserLogin{Email: "mike@example.com", Password: "04234"}, nil); status != 200 {
+		t.Fatalf("login status = %d, want 200", status)
+	}
+	match, needsRehash, err := cfg.passwords.CheckPasswordHash("04234", storedHash())
+	if err != nil || !match || needsRehash {
+		t.Errorf("after login CheckPasswordHash() = %v, %v, %v, want true, false, nil", match, needsRehash, err)
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	cfg.passwordPolicy = password.DefaultPolicy
+	mailServer := useMailServer(t, cfg)
+
+	var refused passwordPolicyError
+	if status := doJSON(t, srv, "POST", "/api/users", "", UserPost{Email: "todd@example.com", Password: "todd1234"}, &refused); status != 400 {
+		t.Fatalf("weak password status = %d, want 400", status)
+	}
+	if len(refused.Violations) != 1 || refused.Violations[0].Code != "too_guessable" {
+		t.Errorf("violations = %+v, want too_guessable", refused.Violations)
+	}
+
+	user := createAndLogin(t, srv, "todd@example.com", "vfr4-bgt5-nhy6")
+
+	weak := userUpdate{Password: "short", CurrentPassword: "vfr4-bgt5-nhy6"}
+	if status := doJSON(t, srv, "PUT", "/api/users", user.Token, weak, &refused); status != 400 {
+		t.Errorf("weak password change status = %d, want 400", status)
+	}
+	if len(refused.Violations) == 0 || refused.Violations[0].Code != "too_short" {
+		t.Errorf("violations = %+v, want too_short first", refused.Violations)
+	}
+
+	if status := doJSON(t, srv, "POST", "/api/password-reset/request", "", passwordResetRequest{Email: "todd@example.com"}, nil); status != 202 {
+		t.Fatalf("reset request status = %d, want 202", status)
+	}
+	waitForMail(t, mailServer, 2)
+	token := mailedToken(t, mailServer, "todd@example.com")
+
+	if status := doJSON(t, srv, "POST", "/api/password-reset/confirm", "", passwordResetConfirm{Token: token, Password: "password"}, nil); status != 400 {
+		t.Errorf("weak reset status = %d, want 400", status)
+	}
+	// The refused attempt did not use up the link.
+	if status := doJSON(t, srv, "POST", "/api/password-reset/confirm", "", passwordResetConfirm{Token: token, Password: "mju7-nhy6-bgt5"}, nil); status != 204 {
+		t.Errorf("reset status = %d, want 204", status)
+	}
+}
+
+func TestAccountDeletion(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	clock := &tickingClock{now: time.Now().UTC()}
+	cfg.now = clock.Now
+
+	walt := createAndLogin(t, srv, "walt@example.com", "04234")
+	doJSON(t, srv, "POST", "/api/chirps", walt.Token, map[string]string{"body": "say my name"}, nil)
+
+	if status := doJSON(t, srv, "DELETE", "/api/users/me", walt.Token, accountDeletionRequest{}, nil); status != 400 {
+		t.Errorf("DELETE without password status = %d, want 400", status)
+	}
+	if status := doJSON(t, srv, "DELETE", "/api/users/me", walt.Token, accountDeletionRequest{Password: "nope"}, nil); status != 403 {
+		t.Errorf("DELETE with wrong password status = %d, want 403", status)
+	}
+
+	var scheduled accountDeletion
+	if status := doJSON(t, srv, "DELETE", "/api/users/me", walt.Token, accountDeletionRequest{Password: "04234"}, &scheduled); status != 202 {
+		t.Fatalf("DELETE status = %d, want 202", status)
+	}
+	if want := clock.Now().Add(cfg.deletionGrace); scheduled.DeleteAfter.After(want) || scheduled.DeleteAfter.Before(want.Add(-time.Minute)) {
+		t.Errorf("delete_after = %v, want about %v", scheduled.DeleteAfter, want)
+	}
+	if status := doJSON(t, srv, "POST", "/api/refresh", walt.RefreshToken, nil, nil); status != 401 {
+		t.Errorf("refresh after DELETE status = %d, want 401", status)
+	}
+
+	// Logging in during the grace period keeps the account.
+	if status := doJSON(t, srv, "POST", "/api/login", "", UserLogin{Email: "walt@example.com", Password: "04234"}, &walt); status != 200 {
+		t.Fatalf("login during grace period status = %d, want 200", status)
+	}
+	clock.Advance(cfg.deletionGrace + time.Hour)
+	if purged, err := cfg.purgeDueAccounts(context.Background()); err != nil || purged != 0 {
+		t.Fatalf("purge after cancelled deletion = %d, %v; want 0", purged, err)
+	}
+
+	if status := doJSON(t, srv, "DELETE", "/api/users/me", walt.Token, accountDeletionRequest{Password: "04234"}, nil); status != 202 {
+		t.Fatalf("second DELETE status = %d, want 202", status)
+	}
+	if purged, err := cfg.purgeDueAccounts(context.Background()); err != nil || purged != 0 {
+		t.Fatalf("purge during grace period = %d, %v; want 0", purged, err)
+	}
+	clock.Advance(cfg.deletionGrace + time.Hour)
+	if purged, err := cfg.purgeDueAccounts(context.Background()); err != nil || purged != 1 {
+		t.Fatalf("purge after grace period = %d, %v; want 1", purged, err)
+	}
+	if status := doJSON(t, srv, "POST", "/api/login", "", UserLogin{Email: "walt@example.com", Password: "04234"}, nil); status != 401 {
+		t.Errorf("login after purge status = %d, want 401", status)
+	}
+	page := chirpPage{}
+	doJSON(t, srv, "GET", "/api/chirps", "", nil, &page)
+	if len(page.Chirps) != 0 {
+		t.Errorf("chirps after hard delete = %+v, want none", page.Chirps)
+	}
+
+	cfg.deletionMode = anonymizeAccounts
+	cfg.deletionGrace = 0
+	jesse := createAndLogin(t, srv, "jesse@example.com", "yo")
+	doJSON(t, srv, "POST", "/api/chirps", jesse.Token, map[string]string{"body": "yeah science"}, nil)
+	if status := doJSON(t, srv, "DELETE", "/api/users/me", jesse.Token, accountDeletionRequest{Password: "yo"}, nil); status != 204 {
+		t.Fatalf("DELETE without grace status = %d, want 204", status)
+	}
+	if status := doJSON(t, srv, "POST", "/api/login", "", UserLogin{Email: "jesse@example.com", Password: "yo"}, nil); status != 401 {
+		t.Errorf("login after immediate delete status = %d, want 401", status)
+	}
+	page = chirpPage{}
+	doJSON(t, srv, "GET", "/api/chirps", "", nil, &page)
+	if len(page.Chirps) != 1 || *page.Chirps[0].Body != "yeah science" || page.Chirps[0].UserId != "" {
+		t.Errorf("chirps after anonymizing = %+v, want one without an author", page.Chirps)
+	}
+}
+
+// downloadExport fetches an export archive and decodes its JSON files by
+// name.
+func downloadExport(t *testing.T, srv *httptest.Server, path, token string) map[string]json.RawMessage {
+	t.Helper()
+	req, err := http.NewRequest("GET", srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "application/zip" {
+		t.Fatalf("GET %s = %d %s, want 200 application/zip", path, resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("GET %s: reading zip: %v", path, err)
+	}
+	files := map[string]json.RawMessage{}
+	for _, f := range archive.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = contents
+	}
+	return files
+}
+
+func TestExport(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	cfg.exports = newExportJobs(t.TempDir())
+
+	// Page through chirps one at a time.
+	defer func(size int32) { exportChirpPageSize = size }(exportChirpPageSize)
+	exportChirpPageSize = 1
+
+	walt := createAndLogin(t, srv, "walt@example.com", "04234")
+	for _, body := range []string{"say my name", "I am the one who knocks"} {
+		doJSON(t, srv, "POST", "/api/chirps", walt.Token, map[string]string{"body": body}, nil)
+	}
+	// Refreshing adds a token to the session, not another session.
+	if status := doJSON(t, srv, "POST", "/api/refresh", walt.RefreshToken, nil, nil); status != 200 {
+		t.Fatalf("refresh status = %d, want 200", status)
+	}
+
+	files := downloadExport(t, srv, "/api/users/me/export", walt.Token)
+	var profile exportedProfile
+	var chirps []chirpCreated
+	var sessions []exportedSession
+	var subscription exportedSubscription
+	for name, v := range map[string]any{"profile.json": &profile, "chirps.json": &chirps, "sessions.json": &sessions, "subscription.json": &subscription} {
+		if err := json.Unmarshal(files[name], v); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if profile.ID != walt.ID || profile.Email != "walt@example.com" {
+		t.Errorf("profile = %+v", profile)
+	}
+	if len(chirps) != 2 || *chirps[1].Body != "I am the one who knocks" {
+		t.Errorf("chirps = %+v", chirps)
+	}
+	if len(sessions) != 1 || sessions[0].RevokedAt != nil || !sessions[0].LastUsedAt.After(sessions[0].CreatedAt) {
+		t.Errorf("sessions = %+v, want one active session used since it started", sessions)
+	}
+	if strings.Contains(string(files["sessions.json"]), "hash") {
+		t.Errorf("sessions.json leaks token hashes: %s", files["sessions.json"])
+	}
+	if subscription.IsChirpyRed {
+		t.Errorf("subscription = %+v, want not Chirpy Red", subscription)
+	}
+
+	cfg.exportInlineLimit = 1
+	var job exportJobResponse
+	if status := doJSON(t, srv, "GET", "/api/users/me/export", walt.Token, nil, &job); status != 202 {
+		t.Fatalf("large export status = %d, want 202", status)
+	}
+	var again exportJobResponse
+	doJSON(t, srv, "GET", "/api/users/me/export", walt.Token, nil, &again)
+	if again.ID != job.ID {
+		t.Errorf("second request started job %v, want %v", again.ID, job.ID)
+	}
+
+	jesse := createAndLogin(t, srv, "jesse@example.com", "yo")
+	if status := doJSON(t, srv, "GET", "/api/users/me/export/"+job.ID.String(), jesse.Token, nil, nil); status != 404 {
+		t.Errorf("other user's export status = %d, want 404", status)
+	}
+	if got := strings.TrimSpace(string(downloadExport(t, srv, "/api/users/me/export", jesse.Token)["chirps.json"])); got != "[]" {
+		t.Errorf("chirps.json without chirps = %s, want []", got)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Status != exportReady {
+		if job.Status == exportFailed || time.Now().After(deadline) {
+			t.Fatalf("export job = %+v", job)
+		}
+		time.Sleep(10 * time.Millisecond)
+		doJSON(t, srv, "GET", "/api/users/me/export/"+job.ID.String(), walt.Token, nil, &job)
+	}
+	files = downloadExport(t, srv, job.DownloadURL, walt.Token)
+	chirps = nil
+	if err := json.Unmarshal(files["chirps.json"], &chirps); err != nil || len(chirps) != 2 {
+		t.Errorf("chirps from background export = %+v, %v", chirps, err)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	_, srv := newTestServer(t)
+
+	if status := doJSON(t, srv, "POST", "/api/users", "", UserPost{Email: "walt@example.com", Password: "04234", Handle: "Heisenberg"}, nil); status != 201 {
+		t.Fatalf("POST /api/users with handle status = %d, want 201", status)
+	}
+	var walt User
+	doJSON(t, srv, "POST", "/api/login", "", UserLogin{Email: "walt@example.com", Password: "04234"}, &walt)
+	if walt.Handle != "Heisenberg" {
+		t.Errorf("login handle = %q, want Heisenberg", walt.Handle)
+	}
+
+	for handle, want := range map[string]int{"heisenberg": 409, "ME": 400, "1walt": 400, "jp": 400, "jesse-p": 400} {
+		if status := doJSON(t, srv, "POST", "/api/users", "", UserPost{Email: "jesse@example.com", Password: "yo", Handle: handle}, nil); status != want {
+			t.Errorf("POST /api/users with handle %q status = %d, want %d", handle, status, want)
+		}
+	}
+
+	jesse := createAndLogin(t, srv, "jesse@example.com", "yo")
+	badAvatar := "javascript:alert(1)"
+	if status := doJSON(t, srv, "PUT", "/api/users", jesse.Token, userUpdate{AvatarURL: &badAvatar}, nil); status != 400 {
+		t.Errorf("PUT with bad avatar_url status = %d, want 400", status)
+	}
+	handle, name, bio, avatar := "CapnCook", "Jesse", "Yeah, science!", "https://example.com/jesse.png"
+	if status := doJSON(t, srv, "PUT", "/api/users", jesse.Token, userUpdate{Handle: &handle, DisplayName: &name, Bio: &bio, AvatarURL: &avatar}, nil); status != 200 {
+		t.Fatalf("PUT profile status = %d, want 200", status)
+	}
+
+	doJSON(t, srv, "POST", "/api/chirps", walt.Token, map[string]string{"body": "say my name"}, nil)
+
+	var raw map[string]any
+	if status := doJSON(t, srv, "GET", "/api/users/HEISENBERG", "", nil, &raw); status != 200 {
+		t.Fatalf("GET profile by handle status = %d, want 200", status)
+	}
+	if _, ok := raw["email"]; ok {
+		t.Errorf("public profile includes email: %v", raw)
+	}
+	if raw["handle"] != "Heisenberg" || raw["chirp_count"] != float64(1) || raw["is_chirpy_red"] != false {
+		t.Errorf("profile by handle = %v", raw)
+	}
+
+	var profile publicProfile
+	if status := doJSON(t, srv, "GET", "/api/users/"+jesse.ID.String(), "", nil, &profile); status != 200 {
+		t.Fatalf("GET profile by ID status = %d, want 200", status)
+	}
+	if profile.Handle != "CapnCook" || profile.DisplayName != "Jesse" || profile.Bio != bio || profile.AvatarURL != avatar || profile.JoinedAt.IsZero() {
+		t.Errorf("profile by ID = %+v", profile)
+	}
+	if status := doJSON(t, srv, "GET", "/api/users/nobody", "", nil, nil); status != 404 {
+		t.Errorf("GET unknown profile status = %d, want 404", status)
+	}
+
+	page := chirpPage{}
+	doJSON(t, srv, "GET", "/api/chirps", "", nil, &page)
+	if len(page.Chirps) != 1 || page.Chirps[0].Author != nil {
+		t.Errorf("chirps without expand = %+v", page.Chirps)
+	}
+	page = chirpPage{}
+	doJSON(t, srv, "GET", "/api/chirps?expand=author", "", nil, &page)
+	if len(page.Chirps) != 1 || page.Chirps[0].Author == nil || page.Chirps[0].Author.Handle != "Heisenberg" {
+		t.Errorf("chirps with expand=author = %+v", page.Chirps)
+	}
+
+	cleared := ""
+	if status := doJSON(t, srv, "PUT", "/api/users", jesse.Token, userUpdate{Handle: &cleared}, nil); status != 200 {
+		t.Fatalf("PUT clearing handle status = %d, want 200", status)
+	}
+	if status := doJSON(t, srv, "GET", "/api/users/capncook", "", nil, nil); status != 404 {
+		t.Errorf("GET cleared handle status = %d, want 404", status)
+	}
+}
+
+// staleHandleStore never finds a user by handle, as if every handle were
+// taken just after handleAvailable looked.
+type staleHandleStore struct {
+	database.Store
+}
+
+func (staleHandleStore) GetUserByHandle(ctx context.Context, handle string) (database.User, error) {
+	return database.User{}, sql.ErrNoRows
+}
+
+func TestHandleTakenAfterCheck(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	cfg.db = staleHandleStore{cfg.db}
+
+	if status := doJSON(t, srv, "POST", "/api/users", "", UserPost{Email: "walt@example.com", Password: "04234", Handle: "Heisenberg"}, nil); status != 201 {
+		t.Fatalf("POST /api/users status = %d, want 201", status)
+	}
+
+	var taken chirpError
+	if status := doJSON(t, srv, "POST", "/api/users", "", UserPost{Email: "jesse@example.com", Password: "yo", Handle: "heisenberg"}, &taken); status != 409 || taken.Error != "Handle already taken" {
+		t.Errorf("POST with a taken handle = %d %q, want 409 Handle already taken", status, taken.Error)
+	}
+	var inUse chirpError
+	if status := doJSON(t, srv, "POST", "/api/users", "", UserPost{Email: "walt@example.com", Password: "yo", Handle: "Walt"}, &inUse); status != 409 || inUse.Error != "Email address already in use" {
+		t.Errorf("POST with a used email = %d %q, want 409 Email address already in use", status, inUse.Error)
+	}
+
+	jesse := createAndLogin(t, srv, "jesse@example.com", "yo")
+	handle := "HEISENBERG"
+	taken = chirpError{}
+	if status := doJSON(t, srv, "PUT", "/api/users", jesse.Token, userUpdate{Handle: &handle}, &taken); status != 409 || taken.Error != "Handle already taken" {
+		t.Errorf("PUT with a taken handle = %d %q, want 409 Handle already taken", status, taken.Error)
+	}
+}
+
+func TestTwoFactorCodesThrottled(t *testing.T) {
+	cfg, srv := newTestServer(t)
+	clock := &tickingClock{now: time.Now().UTC()}
+	cfg.now = clock.Now
+	user := createAndLogin(t, srv, "jesse@example.com", "04234")
+
+	var enrollment totpEnrollment
+	doJSON(t, srv, "POST", "/api/users/2fa", user.Token, nil, &enrollment)
+	code, err := auth.TOTPCode(enrollment.Secret, clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := doJSON(t, srv, "POST", "/api/users/2fa/confirm", user.Token, totpCode{Code: code}, nil); status != 200 {
+		t.Fatalf("confirm status = %d, want 200", status)
+	}
+
+	// Logging in with the right password again must not wipe out the
+	// wrong codes guessed so far.
+	login := UserLogin{Email: "jesse@example.com", Password: "04234"}
+	for i := 0; i <= throttle.DefaultAccountPolicy.FreeAttempts; i++ {
+		var challenge mfaChallenge
+		if status := doJSON(t, srv, "POST", "/api/login", "", login, &challenge); status != 200 {
+			t.Fatalf("login %d status = %d, want 200", i, status)
+		}
+		if status := doJSON(t, srv, "POST", "/api/login/mfa", "", mfaLogin{MFAToken: challenge.MFAToken, Code: "000000"}, nil); status != 401 {
+			t.Fatalf("wrong code %d status = %d, want 401", i, status)
+		}
+	}
+	if status := doJSON(t, srv, "POST", "/api/login", "", login, nil); status != 429 {
+		t.Errorf("login after repeated wrong codes status = %d, want 429", status)
+	}
+
+	// Disabling 2FA with an access token is throttled the same way.
+	clock.Advance(time.Hour)
+	for i := 0; i <= throttle.DefaultAccountPolicy.FreeAttempts; i++ {
+		if status := doJSON(t, srv, "DELETE", "/api/users/2fa", user.Token, totpCode{Code: "000000"}, nil); status != 403 {
+			t.Fatalf("disable with wrong code %d status = %d, want 403", i, status)
+		}
+	}
+	if status := doJSON(t, srv, "DELETE", "/api/users/2fa", user.Token, totpCode{Code: "000000"}, nil); status != 429 {
+		t.Errorf("disable after repeated wrong codes status = %d, want 429", status)
+	}
+}
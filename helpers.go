@@ -1,38 +1,65 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"slices"
+	"net/mail"
 	"strings"
-	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jcuello/chirpy/internal/database"
+	"github.com/jcuello/chirpy/internal/moderation"
+	"github.com/jcuello/chirpy/internal/password"
 )
 
 var somethingWentWrongResponse = chirpError{Error: "Something went wrong"}
 
-func cleanChirpBody(body string) string {
-	badWords := []string{"kerfuffle", "sharbert", "fornax"}
-	words := strings.Split(body, " ")
-	results := []string{}
-	for _, word := range words {
-		lowered := strings.ToLower(word)
-		hasPunct := false
+const maxChirpLength = 140
 
-		for _, char := range lowered {
-			if unicode.IsPunct(char) {
-				hasPunct = true
-				break
-			}
-		}
+var (
+	errChirpTooLong  = errors.New("Chirp is too long")
+	errChirpRejected = errors.New("Chirp contains prohibited language")
+)
 
-		if !hasPunct && slices.Contains(badWords, lowered) {
-			results = append(results, "****")
-		} else {
-			results = append(results, word)
-		}
+// prepareChirpBody applies the rules every stored chirp body must pass. The
+// decision's Text is the body to store.
+func (cfg *apiConfig) prepareChirpBody(body string) (moderation.Decision, error) {
+	if utf8.RuneCountInString(body) > maxChirpLength {
+		return moderation.Decision{}, errChirpTooLong
 	}
-	return strings.Join(results, " ")
+
+	decision := cfg.moderation.Apply(body)
+	if decision.Rejected {
+		return decision, errChirpRejected
+	}
+	return decision, nil
+}
+
+// flagChirp queues a chirp for review. The chirp is already saved, so a
+// failure here is logged rather than returned to the author.
+func (cfg *apiConfig) flagChirp(ctx context.Context, chirpID uuid.UUID, decision moderation.Decision) {
+	if !decision.Flagged {
+		return
+	}
+
+	err := cfg.db.FlagChirp(ctx, database.FlagChirpParams{
+		ChirpID: chirpID,
+		Matches: strings.Join(decision.Matches, ", "),
+	})
+	if err != nil {
+		fmt.Printf("Unable to flag chirp %v: %v\n", chirpID, err)
+	}
+}
+
+// validEmail accepts a bare address such as walt@example.com, without a
+// display name or angle brackets.
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
 }
 
 func respondWithError(w http.ResponseWriter, statusCode int, msg string) {
@@ -62,3 +89,24 @@ func respondWithJson(w http.ResponseWriter, statusCode int, payload interface{})
 		w.Write(data)
 	}
 }
+
+// passwordAcceptable writes a 400 listing every policy violation when plaintext
+// is not good enough as a new password, and reports whether to go on.
+// userInputs are the account's details, which make poor passwords.
+func (cfg *apiConfig) passwordAcceptable(w http.ResponseWriter, r *http.Request, plaintext string, userInputs ...string) bool {
+	err := cfg.passwordPolicy.Check(r.Context(), plaintext, userInputs...)
+	var invalid *password.ValidationError
+	if errors.As(err, &invalid) {
+		respondWithJson(w, 400, passwordPolicyError{
+			Error:      "Password does not meet the requirements",
+			Violations: invalid.Violations,
+		})
+		return false
+	}
+	if err != nil {
+		fmt.Printf("Unable to check password against the policy: %v\n", err)
+		respondWithInternalServerError(w)
+		return false
+	}
+	return true
+}
@@ -2,6 +2,8 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -9,7 +11,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/alexedwards/argon2id"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
@@ -20,52 +21,37 @@ const (
 	TokenTypeAccess TokenType = "chirpy-access"
 )
 
-func HashPassword(password string) (string, error) {
-	return argon2id.CreateHash(password, argon2id.DefaultParams)
-}
-
-func CheckPasswordHash(password, hash string) (bool, error) {
-	return argon2id.ComparePasswordAndHash(password, hash)
-}
-
-func MakeJWT(userId uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+// MakeJWT signs claims as an access token that expires after expiresIn. The
+// issuer, audience, token ID and timestamps are always set here.
+func MakeJWT(claims Claims, keys *KeySet, expiresIn time.Duration) (string, error) {
 	utcNow := time.Now().UTC()
-	issuedAt := jwt.NewNumericDate(utcNow)
-	expiresAt := jwt.NewNumericDate(utcNow).Add(expiresIn)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    "chirpy-access",
-		IssuedAt:  issuedAt,
-		ExpiresAt: jwt.NewNumericDate(expiresAt),
-		Subject:   userId.String(),
-	})
-
-	key := []byte(tokenSecret)
-	return token.SignedString(key)
+	claims.Issuer = string(TokenTypeAccess)
+	claims.Audience = jwt.ClaimStrings{Audience}
+	claims.ID = uuid.NewString()
+	claims.IssuedAt = jwt.NewNumericDate(utcNow)
+	claims.ExpiresAt = jwt.NewNumericDate(utcNow.Add(expiresIn))
+	return keys.sign(claims)
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, &claimsStruct, func(t *jwt.Token) (any, error) {
-		return []byte(tokenSecret), nil
-	})
-
+// ValidateJWT verifies an access token and returns its claims. The token has
+// to be signed with an algorithm the key set uses, name the Chirpy issuer and
+// audience, and carry an expiry.
+func ValidateJWT(tokenString string, keys *KeySet) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, keys.keyfunc,
+		jwt.WithValidMethods(keys.methods()),
+		jwt.WithIssuer(string(TokenTypeAccess)),
+		jwt.WithAudience(Audience),
+		jwt.WithExpirationRequired(),
+	)
 	if err != nil {
-		return uuid.Nil, err
+		return nil, err
 	}
 
-	if claims, ok := token.Claims.(*jwt.RegisteredClaims); ok {
-		id, err := uuid.Parse(claims.Subject)
-		if err != nil {
-			return uuid.Nil, fmt.Errorf("invalid user id")
-		}
-
-		if claims.Issuer != string(TokenTypeAccess) {
-			return uuid.Nil, fmt.Errorf("invalid issuer")
-		}
-		return id, nil
-	} else {
-		return uuid.Nil, fmt.Errorf("unknown claims type")
+	if _, err := uuid.Parse(claims.Subject); err != nil {
+		return nil, fmt.Errorf("invalid user id")
 	}
+	return claims, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -92,6 +78,46 @@ func MakeRefreshToken() (string, error) {
 	return hex.EncodeToString(bits), nil
 }
 
+// MakePasswordResetToken returns a token for a password reset link. Like a
+// refresh token, it is stored only as its HashToken digest.
+func MakePasswordResetToken() (string, error) {
+	return MakeRefreshToken()
+}
+
+// HashToken returns the hex SHA-256 digest of a high-entropy token such as a
+// refresh token. Only the digest is stored, so a database dump cannot be
+// replayed. It is not suitable for passwords.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// TokenMatches reports whether token is the one hash was made from. It takes
+// the same time however much of the digest matches.
+func TokenMatches(token, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashToken(token)), []byte(hash)) == 1
+}
+
+// JoinRefreshToken returns the value handed to the client for a refresh token
+// stored under id with the digest of secret.
+func JoinRefreshToken(id uuid.UUID, secret string) string {
+	return id.String() + "." + secret
+}
+
+// SplitRefreshToken undoes JoinRefreshToken. The id finds the stored token;
+// the secret must then match its digest.
+func SplitRefreshToken(token string) (uuid.UUID, string, error) {
+	rawID, secret, ok := strings.Cut(token, ".")
+	if !ok || secret == "" {
+		return uuid.Nil, "", errors.New("malformed refresh token")
+	}
+	id, err := uuid.Parse(rawID)
+	if err != nil {
+		return uuid.Nil, "", errors.New("malformed refresh token")
+	}
+	return id, secret, nil
+}
+
 func GetAPIKey(headers http.Header) (string, error) {
 	authHeader := strings.TrimSpace(headers.Get("Authorization"))
 
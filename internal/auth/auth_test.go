@@ -12,8 +12,9 @@ func TestCheckPasswordHash(t *testing.T) {
 	// First, we need to create some hashed passwords for testing
 	password1 := "correctPassword123!"
 	password2 := "anotherPassword456!"
-	hash1, _ := HashPassword(password1)
-	hash2, _ := HashPassword(password2)
+	hasher := NewPasswordHasher(testPasswordParams)
+	hash1, _ := hasher.HashPassword(password1)
+	hash2, _ := hasher.HashPassword(password2)
 
 	tests := []struct {
 		name          string
@@ -61,7 +62,7 @@ func TestCheckPasswordHash(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			match, err := CheckPasswordHash(tt.password, tt.hash)
+			match, _, err := hasher.CheckPasswordHash(tt.password, tt.hash)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("CheckPasswordHash() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -74,8 +75,8 @@ func TestCheckPasswordHash(t *testing.T) {
 
 func TestMakeJWT(t *testing.T) {
 	userId := uuid.MustParse("4a651dff-ce24-48c0-a1ae-cde0340f54f2")
-	secret := "super-secret-password"
-	jwt, err := MakeJWT(userId, secret, 10*time.Second)
+	keys := NewHMACKeySet("super-secret-password")
+	jwt, err := MakeJWT(NewClaims(userId), keys, 10*time.Second)
 
 	if err != nil {
 		t.Fatal(err)
@@ -86,33 +87,33 @@ func TestMakeJWT(t *testing.T) {
 
 func TestValidateJWT(t *testing.T) {
 	userID := uuid.New()
-	validToken, _ := MakeJWT(userID, "secret", time.Hour)
+	validToken, _ := MakeJWT(NewClaims(userID), NewHMACKeySet("secret"), time.Hour)
 
 	tests := []struct {
 		name        string
 		tokenString string
-		tokenSecret string
+		keys        *KeySet
 		wantUserID  uuid.UUID
 		wantErr     bool
 	}{
 		{
 			name:        "Valid token",
 			tokenString: validToken,
-			tokenSecret: "secret",
+			keys:        NewHMACKeySet("secret"),
 			wantUserID:  userID,
 			wantErr:     false,
 		},
 		{
 			name:        "Invalid token",
 			tokenString: "invalid.token.string",
-			tokenSecret: "secret",
+			keys:        NewHMACKeySet("secret"),
 			wantUserID:  uuid.Nil,
 			wantErr:     true,
 		},
 		{
 			name:        "Wrong secret",
 			tokenString: validToken,
-			tokenSecret: "wrong_secret",
+			keys:        NewHMACKeySet("wrong_secret"),
 			wantUserID:  uuid.Nil,
 			wantErr:     true,
 		},
@@ -120,12 +121,15 @@ func TestValidateJWT(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotUserID, err := ValidateJWT(tt.tokenString, tt.tokenSecret)
+			claims, err := ValidateJWT(tt.tokenString, tt.keys)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ValidateJWT() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if gotUserID != tt.wantUserID {
+			if err != nil {
+				return
+			}
+			if gotUserID := claims.UserID(); gotUserID != tt.wantUserID {
 				t.Errorf("ValidateJWT() gotUserID = %v, want %v", gotUserID, tt.wantUserID)
 			}
 		})
@@ -172,3 +176,38 @@ func TestGetBearerToken(t *testing.T) {
 		})
 	}
 }
+
+func TestHashToken(t *testing.T) {
+	// echo -n abc | sha256sum
+	want := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if got := HashToken("abc"); got != want {
+		t.Errorf("HashToken(abc) = %v, want %v", got, want)
+	}
+	if HashToken("abc") == HashToken("abd") {
+		t.Error("HashToken() collides on different input")
+	}
+}
+
+func TestTokenMatches(t *testing.T) {
+	hash := HashToken("abc")
+	if !TokenMatches("abc", hash) {
+		t.Error("TokenMatches() rejected the token the hash was made from")
+	}
+	if TokenMatches("abd", hash) {
+		t.Error("TokenMatches() accepted a different token")
+	}
+}
+
+func TestSplitRefreshToken(t *testing.T) {
+	id := uuid.New()
+	gotID, secret, err := SplitRefreshToken(JoinRefreshToken(id, "secret"))
+	if err != nil || gotID != id || secret != "secret" {
+		t.Errorf("SplitRefreshToken() = %v, %q, %v, want %v, secret", gotID, secret, err, id)
+	}
+
+	for _, token := range []string{"", "secret", id.String(), id.String() + ".", "walt.secret"} {
+		if _, _, err := SplitRefreshToken(token); err == nil {
+			t.Errorf("SplitRefreshToken(%q) should fail", token)
+		}
+	}
+}
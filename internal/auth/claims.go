@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Audience is the aud every access token is issued for. Tokens minted for
+// anything else are rejected.
+const Audience = "chirpy-api"
+
+// Scope is one permission carried by an access token.
+type Scope string
+
+const (
+	ScopeChirpsWrite Scope = "chirps:write"
+	ScopeAdmin       Scope = "admin"
+)
+
+var ErrInsufficientScope = errors.New("insufficient scope")
+
+// Claims are the contents of a Chirpy access token. Scope is a
+// space-separated list, as in RFC 9068. SessionID names the refresh token
+// family the token was issued from.
+type Claims struct {
+	jwt.RegisteredClaims
+	Scope       string `json:"scope,omitempty"`
+	SessionID   string `json:"sid,omitempty"`
+	IsChirpyRed bool   `json:"is_chirpy_red"`
+}
+
+// NewClaims returns claims for userID holding scopes. MakeJWT fills in the
+// issuer, audience, token ID and lifetime.
+func NewClaims(userID uuid.UUID, scopes ...Scope) Claims {
+	names := make([]string, len(scopes))
+	for i, scope := range scopes {
+		names[i] = string(scope)
+	}
+	return Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: userID.String()},
+		Scope:            strings.Join(names, " "),
+	}
+}
+
+// UserID is the subject. ValidateJWT has already checked that it parses.
+func (c Claims) UserID() uuid.UUID {
+	id, _ := uuid.Parse(c.Subject)
+	return id
+}
+
+// Session returns the session the token belongs to, if it names one.
+func (c Claims) Session() (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.SessionID)
+	return id, err == nil
+}
+
+func (c Claims) Scopes() []Scope {
+	var scopes []Scope
+	for _, name := range strings.Fields(c.Scope) {
+		scopes = append(scopes, Scope(name))
+	}
+	return scopes
+}
+
+func (c Claims) HasScope(scope Scope) bool {
+	return slices.Contains(c.Scopes(), scope)
+}
+
+// Require returns ErrInsufficientScope unless the token holds every scope.
+func (c Claims) Require(scopes ...Scope) error {
+	for _, scope := range scopes {
+		if !c.HasScope(scope) {
+			return ErrInsufficientScope
+		}
+	}
+	return nil
+}
+
+// EmailAudience is the aud of email verification tokens. Access tokens
+// cannot be used in their place, or the other way round.
+const EmailAudience = "chirpy-verify-email"
+
+// EmailClaims prove that whoever holds the token received mail at Email.
+type EmailClaims struct {
+	jwt.RegisteredClaims
+	Email string `json:"email"`
+}
+
+// MakeEmailToken signs a token confirming that userID owns email.
+func MakeEmailToken(userID uuid.UUID, email string, keys *KeySet, expiresIn time.Duration) (string, error) {
+	utcNow := time.Now().UTC()
+	return keys.sign(EmailClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeAccess),
+			Audience:  jwt.ClaimStrings{EmailAudience},
+			Subject:   userID.String(),
+			ID:        uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(utcNow),
+			ExpiresAt: jwt.NewNumericDate(utcNow.Add(expiresIn)),
+		},
+		Email: email,
+	})
+}
+
+// ValidateEmailToken returns the user and address an email token was issued
+// for. The caller still has to check that the address is current.
+func ValidateEmailToken(tokenString string, keys *KeySet) (uuid.UUID, string, error) {
+	claims := &EmailClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, keys.keyfunc,
+		jwt.WithValidMethods(keys.methods()),
+		jwt.WithIssuer(string(TokenTypeAccess)),
+		jwt.WithAudience(EmailAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+
+	id, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.Nil, "", errors.New("invalid user id")
+	}
+	return id, claims.Email, nil
+}
+
+// MFAAudience is the aud of MFA challenge tokens. A challenge proves only
+// that the password was right, so it is not accepted as an access token.
+const MFAAudience = "chirpy-mfa"
+
+// MakeMFAToken signs a challenge for userID to redeem with a second factor.
+func MakeMFAToken(userID uuid.UUID, keys *KeySet, expiresIn time.Duration) (string, error) {
+	utcNow := time.Now().UTC()
+	return keys.sign(jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeAccess),
+		Audience:  jwt.ClaimStrings{MFAAudience},
+		Subject:   userID.String(),
+		ID:        uuid.NewString(),
+		IssuedAt:  jwt.NewNumericDate(utcNow),
+		ExpiresAt: jwt.NewNumericDate(utcNow.Add(expiresIn)),
+	})
+}
+
+// ValidateMFAToken returns the user an MFA challenge was issued to.
+func ValidateMFAToken(tokenString string, keys *KeySet) (uuid.UUID, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, keys.keyfunc,
+		jwt.WithValidMethods(keys.methods()),
+		jwt.WithIssuer(string(TokenTypeAccess)),
+		jwt.WithAudience(MFAAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	id, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.Nil, errors.New("invalid user id")
+	}
+	return id, nil
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func TestClaimsRoundTrip(t *testing.T) {
+	keys := NewHMACKeySet("secret")
+	userID := uuid.New()
+
+	sessionID := uuid.New()
+
+	claims := NewClaims(userID, ScopeChirpsWrite, ScopeAdmin)
+	claims.IsChirpyRed = true
+	claims.SessionID = sessionID.String()
+	token, err := MakeJWT(claims, keys, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ValidateJWT(token, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UserID() != userID || !got.IsChirpyRed || got.ID == "" {
+		t.Errorf("ValidateJWT() = %+v", got)
+	}
+	if !got.HasScope(ScopeAdmin) || !got.HasScope(ScopeChirpsWrite) {
+		t.Errorf("Scopes() = %v", got.Scopes())
+	}
+	if sid, ok := got.Session(); !ok || sid != sessionID {
+		t.Errorf("Session() = %v, %v, want %v", sid, ok, sessionID)
+	}
+
+	other, _ := MakeJWT(claims, keys, time.Minute)
+	if otherClaims, _ := ValidateJWT(other, keys); otherClaims.ID == got.ID {
+		t.Error("two tokens share a jti")
+	}
+}
+
+func TestClaimsRequire(t *testing.T) {
+	claims := NewClaims(uuid.New(), ScopeChirpsWrite)
+
+	if err := claims.Require(ScopeChirpsWrite); err != nil {
+		t.Errorf("Require(chirps:write) = %v", err)
+	}
+	if err := claims.Require(ScopeChirpsWrite, ScopeAdmin); !errors.Is(err, ErrInsufficientScope) {
+		t.Errorf("Require(chirps:write, admin) = %v, want ErrInsufficientScope", err)
+	}
+	if err := NewClaims(uuid.New()).Require(); err != nil {
+		t.Errorf("Require() with no scopes = %v", err)
+	}
+}
+
+func TestValidateJWTRejectsForeignTokens(t *testing.T) {
+	keys := NewHMACKeySet("secret")
+	valid := jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeAccess),
+		Audience:  jwt.ClaimStrings{Audience},
+		Subject:   uuid.NewString(),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}
+
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+		edit   func(c *jwt.RegisteredClaims)
+	}{
+		{"other audience", jwt.SigningMethodHS256, func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"billing"} }},
+		{"no audience", jwt.SigningMethodHS256, func(c *jwt.RegisteredClaims) { c.Audience = nil }},
+		{"no expiry", jwt.SigningMethodHS256, func(c *jwt.RegisteredClaims) { c.ExpiresAt = nil }},
+		{"other issuer", jwt.SigningMethodHS256, func(c *jwt.RegisteredClaims) { c.Issuer = "someone-else" }},
+		{"HS512", jwt.SigningMethodHS512, func(c *jwt.RegisteredClaims) {}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid
+			tt.edit(&claims)
+			token, err := jwt.NewWithClaims(tt.method, claims).SignedString([]byte("secret"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ValidateJWT(token, keys); err == nil {
+				t.Error("ValidateJWT() accepted the token")
+			}
+		})
+	}
+
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, valid).SignedString([]byte("secret"))
+	if _, err := ValidateJWT(token, keys); err != nil {
+		t.Errorf("ValidateJWT() rejected the baseline token: %v", err)
+	}
+}
+
+func TestEmailToken(t *testing.T) {
+	keys := NewHMACKeySet("secret")
+	userID := uuid.New()
+
+	token, err := MakeEmailToken(userID, "walt@example.com", keys, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotID, gotEmail, err := ValidateEmailToken(token, keys)
+	if err != nil || gotID != userID || gotEmail != "walt@example.com" {
+		t.Errorf("ValidateEmailToken() = %v, %q, %v", gotID, gotEmail, err)
+	}
+
+	// The audiences keep the two kinds of token apart.
+	if _, err := ValidateJWT(token, keys); err == nil {
+		t.Error("ValidateJWT() accepted an email token")
+	}
+	access, _ := MakeJWT(NewClaims(userID), keys, time.Hour)
+	if _, _, err := ValidateEmailToken(access, keys); err == nil {
+		t.Error("ValidateEmailToken() accepted an access token")
+	}
+
+	expired, _ := MakeEmailToken(userID, "walt@example.com", keys, -time.Minute)
+	if _, _, err := ValidateEmailToken(expired, keys); err == nil {
+		t.Error("ValidateEmailToken() accepted an expired token")
+	}
+}
+
+func TestMFAToken(t *testing.T) {
+	keys := NewHMACKeySet("secret")
+	userID := uuid.New()
+
+	token, err := MakeMFAToken(userID, keys, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotID, err := ValidateMFAToken(token, keys); err != nil || gotID != userID {
+		t.Errorf("ValidateMFAToken() = %v, %v", gotID, err)
+	}
+
+	if _, err := ValidateJWT(token, keys); err == nil {
+		t.Error("ValidateJWT() accepted an MFA challenge")
+	}
+	access, _ := MakeJWT(NewClaims(userID), keys, time.Hour)
+	if _, err := ValidateMFAToken(access, keys); err == nil {
+		t.Error("ValidateMFAToken() accepted an access token")
+	}
+
+	expired, _ := MakeMFAToken(userID, keys, -time.Minute)
+	if _, err := ValidateMFAToken(expired, keys); err == nil {
+		t.Error("ValidateMFAToken() accepted an expired token")
+	}
+}
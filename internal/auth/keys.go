@@ -0,0 +1,345 @@
+package auth
+
+import (
+	"cmp"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var errUnknownKey = errors.New("unknown signing key")
+
+// Key is one entry of a KeySet. Its ID is the RFC 7638 thumbprint of the
+// public key, so the same key always gets the same kid wherever it is loaded.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+
+	private crypto.PrivateKey // nil for verification-only keys
+	public  crypto.PublicKey
+}
+
+// KeySet signs tokens with one key and verifies them with any key it holds.
+// Keeping the previous public keys around lets tokens issued before a
+// rotation stay valid until they expire.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+
+	// secret is the HS256 fallback. Tokens without a kid are checked
+	// against it.
+	secret []byte
+}
+
+// NewHMACKeySet returns a KeySet that signs and verifies with a shared HS256
+// secret. Nothing is published in its JWKS.
+func NewHMACKeySet(secret string) *KeySet {
+	return &KeySet{keys: map[string]*Key{}, secret: []byte(secret)}
+}
+
+// NewKeySet signs with signer and also accepts tokens signed by the private
+// halves of verifyOnly. Ed25519, P-256 and RSA keys are supported.
+func NewKeySet(signer crypto.Signer, verifyOnly ...crypto.PublicKey) (*KeySet, error) {
+	ks := &KeySet{keys: map[string]*Key{}}
+
+	signing, err := newKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	signing.private = signer
+	ks.signing = signing
+	ks.keys[signing.ID] = signing
+
+	for _, public := range verifyOnly {
+		key, err := newKey(public)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := ks.keys[key.ID]; !ok {
+			ks.keys[key.ID] = key
+		}
+	}
+	return ks, nil
+}
+
+// LoadKeySetPEM builds a KeySet from a PEM private key and any number of PEM
+// public (or private) keys that are only used for verification.
+func LoadKeySetPEM(privatePEM []byte, verifyPEM []byte) (*KeySet, error) {
+	signers, publics, err := parsePEMKeys(privatePEM)
+	if err != nil {
+		return nil, err
+	}
+	if len(signers) != 1 || len(publics) != 0 {
+		return nil, errors.New("expected exactly one PEM private key to sign with")
+	}
+
+	verifySigners, verifyOnly, err := parsePEMKeys(verifyPEM)
+	if err != nil {
+		return nil, err
+	}
+	for _, signer := range verifySigners {
+		verifyOnly = append(verifyOnly, signer.Public())
+	}
+	return NewKeySet(signers[0], verifyOnly...)
+}
+
+// LoadKeySetDir reads every *.pem file in dir. Private keys are sorted by
+// file name and the last one signs, so naming files by date (2025-01.pem,
+// 2025-06.pem) rotates keys in order. Every key in the directory verifies.
+func LoadKeySetDir(dir string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(paths)
+
+	var signers []crypto.Signer
+	var verifyOnly []crypto.PublicKey
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		fileSigners, publics, err := parsePEMKeys(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		signers = append(signers, fileSigners...)
+		verifyOnly = append(verifyOnly, publics...)
+	}
+
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no private keys in %s", dir)
+	}
+	current := signers[len(signers)-1]
+	for _, signer := range signers[:len(signers)-1] {
+		verifyOnly = append(verifyOnly, signer.Public())
+	}
+	return NewKeySet(current, verifyOnly...)
+}
+
+// AllowHMAC keeps accepting HS256 tokens without a kid. It is meant for the
+// move from JWT_SECRET to asymmetric keys and can be dropped once the old
+// access tokens have expired.
+func (ks *KeySet) AllowHMAC(secret string) {
+	ks.secret = []byte(secret)
+}
+
+// SigningKeyID is the kid new tokens carry, or "" when signing with HMAC.
+func (ks *KeySet) SigningKeyID() string {
+	if ks.signing == nil {
+		return ""
+	}
+	return ks.signing.ID
+}
+
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	if ks.signing == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ks.secret)
+	}
+
+	token := jwt.NewWithClaims(ks.signing.Method, claims)
+	token.Header["kid"] = ks.signing.ID
+	return token.SignedString(ks.signing.private)
+}
+
+// keyfunc picks the verification key named by the token's kid. The alg in
+// the header has to match the key, or an RSA public key could be passed off
+// as an HMAC secret.
+func (ks *KeySet) keyfunc(t *jwt.Token) (any, error) {
+	kid, ok := t.Header["kid"].(string)
+	if !ok {
+		if ks.secret == nil || t.Method != jwt.SigningMethodHS256 {
+			return nil, errUnknownKey
+		}
+		return ks.secret, nil
+	}
+
+	key, ok := ks.keys[kid]
+	if !ok || t.Method.Alg() != key.Method.Alg() {
+		return nil, errUnknownKey
+	}
+	return key.public, nil
+}
+
+// methods lists the algorithms this key set accepts.
+func (ks *KeySet) methods() []string {
+	var algs []string
+	if ks.secret != nil {
+		algs = append(algs, jwt.SigningMethodHS256.Alg())
+	}
+	for _, key := range ks.keys {
+		if !slices.Contains(algs, key.Method.Alg()) {
+			algs = append(algs, key.Method.Alg())
+		}
+	}
+	return algs
+}
+
+// JWK is a public key in RFC 7517 form.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists every verification key, sorted by kid. HMAC secrets are never
+// included.
+func (ks *KeySet) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		jwk := key.jwk()
+		jwk.Kid = key.ID
+		jwk.Use = "sig"
+		jwk.Alg = key.Method.Alg()
+		set.Keys = append(set.Keys, jwk)
+	}
+	slices.SortFunc(set.Keys, func(a, b JWK) int { return cmp.Compare(a.Kid, b.Kid) })
+	return set
+}
+
+func newKey(public crypto.PublicKey) (*Key, error) {
+	key := &Key{public: public}
+	switch pub := public.(type) {
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return nil, errors.New("only P-256 ECDSA keys are supported")
+		}
+		key.Method = jwt.SigningMethodES256
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		key.Method = jwt.SigningMethodRS256
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", public)
+	}
+
+	id, err := thumbprint(key.jwk())
+	if err != nil {
+		return nil, err
+	}
+	key.ID = id
+	return key, nil
+}
+
+// jwk holds only the members that identify the key.
+func (k *Key) jwk() JWK {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := k.public.(type) {
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Crv: "Ed25519", X: b64(pub)}
+	case *ecdsa.PublicKey:
+		// Uncompressed point: 0x04 || X || Y, each padded to 32 bytes.
+		ecdhKey, _ := pub.ECDH()
+		point := ecdhKey.Bytes()
+		return JWK{Kty: "EC", Crv: "P-256", X: b64(point[1:33]), Y: b64(point[33:])}
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", N: b64(pub.N.Bytes()), E: b64(big.NewInt(int64(pub.E)).Bytes())}
+	}
+	return JWK{}
+}
+
+// thumbprint implements RFC 7638: SHA-256 over the required members in
+// lexical order with no whitespace.
+func thumbprint(jwk JWK) (string, error) {
+	var members any
+	switch jwk.Kty {
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	default:
+		return "", fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// parsePEMKeys splits PEM data into private and public keys. PKCS#8, PKIX
+// and the older PKCS#1 and SEC 1 encodings are all accepted.
+func parsePEMKeys(data []byte) ([]crypto.Signer, []crypto.PublicKey, error) {
+	var signers []crypto.Signer
+	var publics []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var parsed any
+		var err error
+		switch block.Type {
+		case "PRIVATE KEY":
+			parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			parsed, err = x509.ParseECPrivateKey(block.Bytes)
+		case "PUBLIC KEY":
+			parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		default:
+			return nil, nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if signer, ok := parsed.(crypto.Signer); ok {
+			signers = append(signers, signer)
+		} else {
+			publics = append(publics, parsed)
+		}
+	}
+	return signers, publics, nil
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func generateSigners(t *testing.T) map[string]crypto.Signer {
+	t.Helper()
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]crypto.Signer{"EdDSA": edKey, "ES256": ecKey, "RS256": rsaKey}
+}
+
+func privatePEM(t *testing.T, signer crypto.Signer) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func publicPEM(t *testing.T, signer crypto.Signer) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestKeySetAlgorithms(t *testing.T) {
+	userID := uuid.New()
+	for alg, signer := range generateSigners(t) {
+		t.Run(alg, func(t *testing.T) {
+			keys, err := NewKeySet(signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token, err := MakeJWT(NewClaims(userID), keys, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &jwt.RegisteredClaims{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.Method.Alg() != alg || parsed.Header["kid"] != keys.SigningKeyID() {
+				t.Errorf("header = %v, want alg %s and kid %s", parsed.Header, alg, keys.SigningKeyID())
+			}
+
+			claims, err := ValidateJWT(token, keys)
+			if err != nil || claims.UserID() != userID {
+				t.Fatalf("ValidateJWT() = %v, %v, want %v", claims, err, userID)
+			}
+
+			jwks := keys.JWKS()
+			if len(jwks.Keys) != 1 || jwks.Keys[0].Alg != alg || jwks.Keys[0].Kid != keys.SigningKeyID() {
+				t.Errorf("JWKS() = %+v", jwks)
+			}
+		})
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	signers := generateSigners(t)
+	userID := uuid.New()
+
+	old, _ := NewKeySet(signers["EdDSA"])
+	oldToken, _ := MakeJWT(NewClaims(userID), old, time.Minute)
+
+	rotated, err := NewKeySet(signers["ES256"], signers["EdDSA"].Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(oldToken, rotated); err != nil {
+		t.Errorf("token from the previous key rejected: %v", err)
+	}
+	if len(rotated.JWKS().Keys) != 2 {
+		t.Errorf("JWKS() has %d keys, want 2", len(rotated.JWKS().Keys))
+	}
+
+	retired, _ := NewKeySet(signers["ES256"])
+	if _, err := ValidateJWT(oldToken, retired); err == nil {
+		t.Error("token from a retired key accepted")
+	}
+}
+
+func TestKeySetRejectsAlgorithmMismatch(t *testing.T) {
+	signer := generateSigners(t)["RS256"]
+	keys, _ := NewKeySet(signer)
+
+	// An attacker who knows the public key signs an HS256 token with it.
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeAccess),
+		Audience:  jwt.ClaimStrings{Audience},
+		Subject:   uuid.NewString(),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	})
+	token.Header["kid"] = keys.SigningKeyID()
+	forged, err := token.SignedString(publicPEM(t, signer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(forged, keys); err == nil {
+		t.Error("HS256 token signed with the public key accepted")
+	}
+
+	// Tokens without a kid are only accepted when an HMAC secret is allowed.
+	legacy, _ := MakeJWT(NewClaims(uuid.New()), NewHMACKeySet("secret"), time.Minute)
+	if _, err := ValidateJWT(legacy, keys); err == nil {
+		t.Error("HS256 token accepted without AllowHMAC")
+	}
+	keys.AllowHMAC("secret")
+	if _, err := ValidateJWT(legacy, keys); err != nil {
+		t.Errorf("HS256 token rejected after AllowHMAC: %v", err)
+	}
+}
+
+func TestLoadKeySet(t *testing.T) {
+	signers := generateSigners(t)
+
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"2025-01.pem": privatePEM(t, signers["RS256"]),
+		"2025-06.pem": privatePEM(t, signers["EdDSA"]),
+		"retired.pem": publicPEM(t, signers["ES256"]),
+		"README.txt":  []byte("not a key"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fromDir, err := LoadKeySetDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fromDir.JWKS().Keys) != 3 {
+		t.Errorf("JWKS() has %d keys, want 3", len(fromDir.JWKS().Keys))
+	}
+
+	// The PEM form of the same signing key gets the same kid.
+	fromPEM, err := LoadKeySetPEM(privatePEM(t, signers["EdDSA"]), publicPEM(t, signers["RS256"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromDir.SigningKeyID() != fromPEM.SigningKeyID() {
+		t.Errorf("kid from dir = %s, from PEM = %s", fromDir.SigningKeyID(), fromPEM.SigningKeyID())
+	}
+
+	if _, err := LoadKeySetDir(t.TempDir()); err == nil {
+		t.Error("LoadKeySetDir() on an empty directory succeeded")
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	// RFC 7638, section 3.1.
+	jwk := JWK{
+		Kty: "RSA",
+		E:   "AQAB",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+	}
+	got, err := thumbprint(jwk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("thumbprint() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type contextKey struct{}
+
+// Middleware validates bearer access tokens once per request and puts the
+// claims in the request context.
+type Middleware struct {
+	keys  *KeySet
+	realm string
+}
+
+func NewMiddleware(keys *KeySet) *Middleware {
+	return &Middleware{keys: keys, realm: "chirpy"}
+}
+
+// RequireAuth rejects requests without a valid access token holding every
+// scope with 401 or 403 and an RFC 6750 WWW-Authenticate header.
+func (m *Middleware) RequireAuth(next http.Handler, scopes ...Scope) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := GetBearerToken(r.Header)
+		if err != nil {
+			// No credentials at all: the challenge carries no error code.
+			m.challenge(w, 401, "", "")
+			return
+		}
+
+		claims, err := ValidateJWT(token, m.keys)
+		if err != nil {
+			m.challenge(w, 401, "invalid_token", "")
+			return
+		}
+
+		if err := claims.Require(scopes...); err != nil {
+			names := make([]string, len(scopes))
+			for i, scope := range scopes {
+				names[i] = string(scope)
+			}
+			m.challenge(w, 403, "insufficient_scope", strings.Join(names, " "))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithClaims(r.Context(), claims)))
+	})
+}
+
+// OptionalAuth adds the claims to the context when the request carries a
+// valid access token and otherwise serves it anonymously.
+func (m *Middleware) OptionalAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, err := GetBearerToken(r.Header); err == nil {
+			if claims, err := ValidateJWT(token, m.keys); err == nil {
+				r = r.WithContext(WithClaims(r.Context(), claims))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *Middleware) challenge(w http.ResponseWriter, statusCode int, code, scope string) {
+	value := fmt.Sprintf("Bearer realm=%q", m.realm)
+	if code != "" {
+		value += fmt.Sprintf(", error=%q", code)
+	}
+	if scope != "" {
+		value += fmt.Sprintf(", scope=%q", scope)
+	}
+	w.Header().Set("WWW-Authenticate", value)
+
+	msg := "Unauthorized"
+	if statusCode == 403 {
+		msg = "Forbidden"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{msg})
+}
+
+// WithClaims returns a copy of ctx carrying claims.
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims stored by the middleware, if any.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(*Claims)
+	return claims, ok
+}
+
+// UserIDFromContext returns the authenticated user, if any.
+func UserIDFromContext(ctx context.Context) (uuid.UUID, bool) {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return uuid.Nil, false
+	}
+	return claims.UserID(), true
+}
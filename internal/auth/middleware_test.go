@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMiddleware(t *testing.T) {
+	keys := NewHMACKeySet("secret")
+	m := NewMiddleware(keys)
+	userID := uuid.New()
+
+	var seen uuid.UUID
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = UserIDFromContext(r.Context())
+		w.WriteHeader(204)
+	})
+
+	writer, _ := MakeJWT(NewClaims(userID, ScopeChirpsWrite), keys, time.Minute)
+	reader, _ := MakeJWT(NewClaims(userID), keys, time.Minute)
+
+	tests := []struct {
+		name       string
+		handler    http.Handler
+		token      string
+		wantStatus int
+		wantHeader string
+		wantUser   uuid.UUID
+	}{
+		{"required, no token", m.RequireAuth(next), "", 401, `Bearer realm="chirpy"`, uuid.Nil},
+		{"required, bad token", m.RequireAuth(next), "garbage", 401, `Bearer realm="chirpy", error="invalid_token"`, uuid.Nil},
+		{"required, valid token", m.RequireAuth(next), reader, 204, "", userID},
+		{"scoped, missing scope", m.RequireAuth(next, ScopeChirpsWrite), reader, 403, `Bearer realm="chirpy", error="insufficient_scope", scope="chirps:write"`, uuid.Nil},
+		{"scoped, has scope", m.RequireAuth(next, ScopeChirpsWrite), writer, 204, "", userID},
+		{"optional, no token", m.OptionalAuth(next), "", 204, "", uuid.Nil},
+		{"optional, bad token", m.OptionalAuth(next), "garbage", 204, "", uuid.Nil},
+		{"optional, valid token", m.OptionalAuth(next), reader, 204, "", userID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = uuid.Nil
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("WWW-Authenticate"); got != tt.wantHeader {
+				t.Errorf("WWW-Authenticate = %q, want %q", got, tt.wantHeader)
+			}
+			if seen != tt.wantUser {
+				t.Errorf("user in context = %v, want %v", seen, tt.wantUser)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+)
+
+// PasswordParams are the argon2id cost parameters. Salt and key lengths are
+// fixed at 16 and 32 bytes.
+type PasswordParams struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// DefaultPasswordParams is the second recommended option of RFC 9106: 64 MiB,
+// three passes, four lanes. Unlike argon2id.DefaultParams it does not depend
+// on the CPU count, so servers of different sizes agree on what is current.
+var DefaultPasswordParams = PasswordParams{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 4,
+}
+
+const (
+	passwordSaltLength = 16
+	passwordKeyLength  = 32
+)
+
+func (p PasswordParams) Validate() error {
+	if p.Iterations < 1 {
+		return errors.New("argon2id needs at least one iteration")
+	}
+	if p.Parallelism < 1 {
+		return errors.New("argon2id needs at least one lane")
+	}
+	if p.Memory < 8*uint32(p.Parallelism) {
+		return errors.New("argon2id needs at least 8 KiB of memory per lane")
+	}
+	return nil
+}
+
+func (p PasswordParams) argon2id() *argon2id.Params {
+	return &argon2id.Params{
+		Memory:      p.Memory,
+		Iterations:  p.Iterations,
+		Parallelism: p.Parallelism,
+		SaltLength:  passwordSaltLength,
+		KeyLength:   passwordKeyLength,
+	}
+}
+
+// PasswordHasher hashes new passwords with the configured parameters and
+// checks passwords against hashes made with any parameters.
+type PasswordHasher struct {
+	params PasswordParams
+
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+func NewPasswordHasher(params PasswordParams) *PasswordHasher {
+	return &PasswordHasher{params: params}
+}
+
+func (h *PasswordHasher) HashPassword(password string) (string, error) {
+	return argon2id.CreateHash(password, h.params.argon2id())
+}
+
+// CheckDummyHash does the work of checking password against a hash made with
+// the configured parameters, for when there is no real hash to check it
+// against, so that the lack of one takes no less time.
+func (h *PasswordHasher) CheckDummyHash(password string) {
+	h.dummyOnce.Do(func() {
+		h.dummyHash, _ = h.HashPassword("chirpy dummy password")
+	})
+	h.CheckPasswordHash(password, h.dummyHash)
+}
+
+// CheckPasswordHash reports whether password matches hash and, if it does,
+// whether hash was made with other parameters than the configured ones and
+// should be replaced while the password is at hand.
+func (h *PasswordHasher) CheckPasswordHash(password, hash string) (match, needsRehash bool, err error) {
+	match, params, err := argon2id.CheckHash(password, hash)
+	if err != nil || !match {
+		return false, false, err
+	}
+	return true, *params != *h.params.argon2id(), nil
+}
+
+// maxCalibrationSteps bounds how far CalibratePasswordParams walks up from
+// its estimate when timings are noisy.
+const maxCalibrationSteps = 32
+
+// CalibratePasswordParams finds the fewest iterations at which hashing with
+// memory and parallelism takes at least target on this machine, and returns
+// them with the time the last hash took. Raise memory rather than accept a
+// large iteration count; memory is what makes guessing expensive on GPUs.
+func CalibratePasswordParams(target time.Duration, memory uint32, parallelism uint8) (PasswordParams, time.Duration, error) {
+	return calibrate(target, PasswordParams{Memory: memory, Parallelism: parallelism}, timeHash)
+}
+
+func calibrate(target time.Duration, params PasswordParams, measure func(PasswordParams) (time.Duration, error)) (PasswordParams, time.Duration, error) {
+	params.Iterations = 1
+	if err := params.Validate(); err != nil {
+		return params, 0, err
+	}
+
+	elapsed, err := measure(params)
+	if err != nil || elapsed >= target {
+		return params, elapsed, err
+	}
+
+	// The cost is close to linear in iterations, so estimate, then step up
+	// until the target is actually met.
+	estimate := math.Ceil(float64(target) / float64(max(elapsed, 1)))
+	params.Iterations = uint32(min(estimate, math.MaxUint32))
+	for range maxCalibrationSteps {
+		elapsed, err = measure(params)
+		if err != nil || elapsed >= target {
+			break
+		}
+		params.Iterations++
+	}
+	return params, elapsed, err
+}
+
+// timeHash returns the fastest of three hashes with params, to keep other
+// work on the machine out of the measurement.
+func timeHash(params PasswordParams) (time.Duration, error) {
+	fastest := time.Duration(math.MaxInt64)
+	for range 3 {
+		start := time.Now()
+		_, err := argon2id.CreateHash("calibration", params.argon2id())
+		if err != nil {
+			return 0, err
+		}
+		fastest = min(fastest, time.Since(start))
+	}
+	return fastest, nil
+}
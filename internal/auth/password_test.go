@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// testPasswordParams keep tests fast. They are far too cheap for real use.
+var testPasswordParams = PasswordParams{Memory: 64, Iterations: 1, Parallelism: 1}
+
+func TestCheckPasswordHashNeedsRehash(t *testing.T) {
+	old := NewPasswordHasher(testPasswordParams)
+	hash, err := old.HashPassword("04234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	match, needsRehash, err := old.CheckPasswordHash("04234", hash)
+	if err != nil || !match || needsRehash {
+		t.Errorf("same params: CheckPasswordHash() = %v, %v, %v, want true, false, nil", match, needsRehash, err)
+	}
+
+	stronger := testPasswordParams
+	stronger.Iterations = 2
+	current := NewPasswordHasher(stronger)
+	match, needsRehash, err = current.CheckPasswordHash("04234", hash)
+	if err != nil || !match || !needsRehash {
+		t.Errorf("new params: CheckPasswordHash() = %v, %v, %v, want true, true, nil", match, needsRehash, err)
+	}
+
+	// A wrong password says nothing about the hash.
+	match, needsRehash, err = current.CheckPasswordHash("wrong", hash)
+	if err != nil || match || needsRehash {
+		t.Errorf("wrong password: CheckPasswordHash() = %v, %v, %v, want false, false, nil", match, needsRehash, err)
+	}
+
+	rehashed, _ := current.HashPassword("04234")
+	if _, needsRehash, _ := current.CheckPasswordHash("04234", rehashed); needsRehash {
+		t.Error("a fresh hash needs rehashing")
+	}
+}
+
+func TestPasswordParamsValidate(t *testing.T) {
+	if err := DefaultPasswordParams.Validate(); err != nil {
+		t.Errorf("DefaultPasswordParams.Validate() = %v", err)
+	}
+	for _, params := range []PasswordParams{
+		{Memory: 64, Iterations: 0, Parallelism: 1},
+		{Memory: 64, Iterations: 1, Parallelism: 0},
+		{Memory: 16, Iterations: 1, Parallelism: 4},
+	} {
+		if err := params.Validate(); err == nil {
+			t.Errorf("%+v.Validate() = nil", params)
+		}
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+	// Each iteration costs 10ms, but noise makes the estimate measure short.
+	var measured []uint32
+	measure := func(p PasswordParams) (time.Duration, error) {
+		measured = append(measured, p.Iterations)
+		if len(measured) == 2 {
+			return time.Duration(p.Iterations-1) * 10 * time.Millisecond, nil
+		}
+		return time.Duration(p.Iterations) * 10 * time.Millisecond, nil
+	}
+
+	params, elapsed, err := calibrate(45*time.Millisecond, PasswordParams{Memory: 1024, Parallelism: 2}, measure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PasswordParams{Memory: 1024, Iterations: 6, Parallelism: 2}
+	if params != want || elapsed != 60*time.Millisecond {
+		t.Errorf("calibrate() = %+v, %v, want %+v, 60ms", params, elapsed, want)
+	}
+	// One measurement to estimate from, one short, one on target.
+	if len(measured) != 3 || measured[0] != 1 || measured[1] != 5 {
+		t.Errorf("measured iterations %v, want [1 5 6]", measured)
+	}
+
+	params, _, _ = calibrate(time.Millisecond, PasswordParams{Memory: 1024, Parallelism: 2}, measure)
+	if params.Iterations != 1 {
+		t.Errorf("fast target: Iterations = %d, want 1", params.Iterations)
+	}
+	if _, _, err := calibrate(time.Second, PasswordParams{Memory: 1, Parallelism: 1}, measure); err == nil {
+		t.Error("calibrate() accepted invalid params")
+	}
+}
+
+func TestCalibratePasswordParams(t *testing.T) {
+	params, elapsed, err := CalibratePasswordParams(5*time.Millisecond, 1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Memory != 1024 || params.Parallelism != 1 || params.Iterations < 1 || elapsed <= 0 {
+		t.Errorf("CalibratePasswordParams() = %+v, %v", params, elapsed)
+	}
+}
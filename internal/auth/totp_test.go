@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 seed from RFC 6238 appendix B,
+// "12345678901234567890", in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestHOTPMatchesRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	key, err := decodeTOTPSecret(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		at := time.Unix(tt.unix, 0)
+		if got := hotp(key, TOTPStep(at), 8); got != tt.want {
+			t.Errorf("hotp(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+		// Six digits are the low digits of the same truncated value.
+		if got, _ := TOTPCode(rfc6238Secret, at); got != tt.want[2:] {
+			t.Errorf("TOTPCode(%d) = %s, want %s", tt.unix, got, tt.want[2:])
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, err := TOTPCode(rfc6238Secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step, ok := ValidateTOTP(rfc6238Secret, code, now)
+	if !ok || step != TOTPStep(now) {
+		t.Errorf("ValidateTOTP() = %d, %v, want %d, true", step, ok, TOTPStep(now))
+	}
+	if _, ok := ValidateTOTP(rfc6238Secret, code, now.Add(totpPeriod)); !ok {
+		t.Error("ValidateTOTP() rejected a code one step old")
+	}
+	if _, ok := ValidateTOTP(rfc6238Secret, code, now.Add(-totpPeriod)); !ok {
+		t.Error("ValidateTOTP() rejected a code one step early")
+	}
+	if _, ok := ValidateTOTP(rfc6238Secret, code, now.Add(3*totpPeriod)); ok {
+		t.Error("ValidateTOTP() accepted a stale code")
+	}
+
+	for _, bad := range []string{"", "12345", "1234567", "abcdef", code + "0"} {
+		if _, ok := ValidateTOTP(rfc6238Secret, bad, now); ok {
+			t.Errorf("ValidateTOTP(%q) = true", bad)
+		}
+	}
+	if _, ok := ValidateTOTP("not base32!", code, now); ok {
+		t.Error("ValidateTOTP() accepted an invalid secret")
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := decodeTOTPSecret(secret)
+	if err != nil || len(key) != 20 {
+		t.Errorf("secret %q decodes to %d bytes, %v", secret, len(key), err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	code, _ := TOTPCode(secret, now)
+	if _, ok := ValidateTOTP(strings.ToLower(secret), code, now); !ok {
+		t.Error("ValidateTOTP() is case sensitive about the secret")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(TOTPURI("Chirpy", "walt@example.com", rfc6238Secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Chirpy:walt@example.com" {
+		t.Errorf("TOTPURI() = %s", uri)
+	}
+	q := uri.Query()
+	if q.Get("secret") != rfc6238Secret || q.Get("issuer") != "Chirpy" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("TOTPURI() query = %v", q)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 19 || strings.Count(code, "-") != 3 {
+			t.Errorf("code %q is not xxxx-xxxx-xxxx-xxxx", code)
+		}
+		seen[code] = true
+	}
+	if len(seen) != len(codes) {
+		t.Error("GenerateRecoveryCodes() repeated a code")
+	}
+
+	if got := NormalizeRecoveryCode(" ABCD-efgh ijkl-MNOP"); got != "abcdefghijklmnop" {
+		t.Errorf("NormalizeRecoveryCode() = %q", got)
+	}
+}
@@ -0,0 +1,1427 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	errUniqueViolation     = errors.New("duplicate key value violates unique constraint")
+	errForeignKeyViolation = errors.New("insert or update violates foreign key constraint")
+	errCheckViolation      = errors.New("new row violates check constraint")
+)
+
+// MemoryStore is a thread-safe, in-process Store. It mirrors the behaviour of
+// the Postgres queries closely enough for handlers to be tested against it:
+// single-row lookups return sql.ErrNoRows, :exec queries succeed even when no
+// row matches, and ON DELETE CASCADE is honoured.
+type MemoryStore struct {
+	mu            sync.RWMutex
+	now           func() time.Time
+	users         map[uuid.UUID]User
+	chirps        map[uuid.UUID]Chirp
+	revisions     map[uuid.UUID]ChirpRevision
+	follows       map[followKey]Follow
+	likes         map[likeKey]Like
+	bannedWords   map[string]BannedWord
+	chirpFlags    map[uuid.UUID]ChirpFlag
+	loginAttempts map[string]LoginAttempt
+	refreshTokens map[uuid.UUID]RefreshToken
+	resetTokens   map[string]PasswordResetToken // keyed by TokenHash
+	totp          map[uuid.UUID]TotpCredential
+	recoveryCodes map[uuid.UUID]RecoveryCode
+}
+
+type followKey struct {
+	followerID uuid.UUID
+	followeeID uuid.UUID
+}
+
+type likeKey struct {
+	userID  uuid.UUID
+	chirpID uuid.UUID
+}
+
+func NewMemoryStore() *MemoryStore {
+	return NewMemoryStoreWithClock(time.Now)
+}
+
+// NewMemoryStoreWithClock returns a MemoryStore that reads NOW() from now.
+func NewMemoryStoreWithClock(now func() time.Time) *MemoryStore {
+	return &MemoryStore{
+		now:           now,
+		users:         map[uuid.UUID]User{},
+		chirps:        map[uuid.UUID]Chirp{},
+		revisions:     map[uuid.UUID]ChirpRevision{},
+		follows:       map[followKey]Follow{},
+		likes:         map[likeKey]Like{},
+		bannedWords:   map[string]BannedWord{},
+		chirpFlags:    map[uuid.UUID]ChirpFlag{},
+		loginAttempts: map[string]LoginAttempt{},
+		refreshTokens: map[uuid.UUID]RefreshToken{},
+		resetTokens:   map[string]PasswordResetToken{},
+		totp:          map[uuid.UUID]TotpCredential{},
+		recoveryCodes: map[uuid.UUID]RecoveryCode{},
+	}
+}
+
+// timestamp matches a Postgres `timestamp` column: UTC, microsecond precision.
+func (m *MemoryStore) timestamp() time.Time {
+	return m.now().UTC().Truncate(time.Microsecond)
+}
+
+func compareChirps(a, b Chirp) int {
+	return cmp.Or(
+		a.CreatedAt.Time.Compare(b.CreatedAt.Time),
+		slices.Compare(a.ID[:], b.ID[:]),
+	)
+}
+
+func compareToCursor(c Chirp, createdAt time.Time, id uuid.UUID) int {
+	return cmp.Or(
+		c.CreatedAt.Time.Compare(createdAt),
+		slices.Compare(c.ID[:], id[:]),
+	)
+}
+
+// sortedChirps returns the chirps accepted by keep, ordered by (created_at, id).
+func (m *MemoryStore) sortedChirps(keep func(Chirp) bool) []Chirp {
+	var items []Chirp
+	for _, c := range m.chirps {
+		if keep(c) {
+			items = append(items, c)
+		}
+	}
+	slices.SortFunc(items, compareChirps)
+	return items
+}
+
+func (m *MemoryStore) ChirpHasReplies(ctx context.Context, inReplyTo uuid.NullUUID) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return inReplyTo.Valid && m.hasReplies(inReplyTo.UUID), nil
+}
+
+// hasReplies reports whether any chirp replies to id. Callers must hold the
+// lock.
+func (m *MemoryStore) hasReplies(id uuid.UUID) bool {
+	for _, c := range m.chirps {
+		if c.InReplyTo.Valid && c.InReplyTo.UUID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryStore) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if arg.UserID.Valid {
+		if _, ok := m.users[arg.UserID.UUID]; !ok {
+			return Chirp{}, errForeignKeyViolation
+		}
+	}
+	if arg.InReplyTo.Valid {
+		if _, ok := m.chirps[arg.InReplyTo.UUID]; !ok {
+			return Chirp{}, errForeignKeyViolation
+		}
+	}
+
+	now := sql.NullTime{Time: m.timestamp(), Valid: true}
+	chirp := Chirp{
+		ID:        uuid.New(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		Body:      arg.Body,
+		UserID:    arg.UserID,
+		InReplyTo: arg.InReplyTo,
+	}
+	m.chirps[chirp.ID] = chirp
+	return chirp, nil
+}
+
+func (m *MemoryStore) DeleteChirp(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deleteChirp(id)
+	return nil
+}
+
+// deleteChirp removes a chirp, applying ON DELETE SET NULL to its replies.
+func (m *MemoryStore) deleteChirp(id uuid.UUID) {
+	delete(m.chirps, id)
+	delete(m.chirpFlags, id)
+	m.deleteChirpRevisions(id)
+	for key := range m.likes {
+		if key.chirpID == id {
+			delete(m.likes, key)
+		}
+	}
+	for replyID, c := range m.chirps {
+		if c.InReplyTo.Valid && c.InReplyTo.UUID == id {
+			c.InReplyTo = uuid.NullUUID{}
+			m.chirps[replyID] = c
+		}
+	}
+}
+
+func (m *MemoryStore) GetAllChirps(ctx context.Context) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sortedChirps(func(c Chirp) bool { return !c.DeletedAt.Valid }), nil
+}
+
+func (m *MemoryStore) GetAuthorChirps(ctx context.Context, userID uuid.NullUUID) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sortedChirps(func(c Chirp) bool {
+		return !c.DeletedAt.Valid && userID.Valid && c.UserID.Valid && c.UserID.UUID == userID.UUID
+	}), nil
+}
+
+func (m *MemoryStore) CountAuthorChirps(ctx context.Context, userID uuid.NullUUID) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var count int64
+	for _, c := range m.chirps {
+		if !c.DeletedAt.Valid && userID.Valid && c.UserID == userID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryStore) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	chirp, ok := m.chirps[id]
+	if !ok {
+		return Chirp{}, sql.ErrNoRows
+	}
+	return chirp, nil
+}
+
+func (m *MemoryStore) GetChirpAncestors(ctx context.Context, id uuid.UUID) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []Chirp
+	chirp, ok := m.chirps[id]
+	for ok && chirp.InReplyTo.Valid {
+		chirp, ok = m.chirps[chirp.InReplyTo.UUID]
+		if ok {
+			items = append(items, chirp)
+		}
+	}
+	// Root first, matching ORDER BY depth DESC.
+	slices.Reverse(items)
+	return items, nil
+}
+
+func (m *MemoryStore) GetReplyCounts(ctx context.Context, chirpIds []uuid.UUID) ([]GetReplyCountsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := map[uuid.UUID]int64{}
+	for _, c := range m.chirps {
+		if c.DeletedAt.Valid || !c.InReplyTo.Valid || !slices.Contains(chirpIds, c.InReplyTo.UUID) {
+			continue
+		}
+		counts[c.InReplyTo.UUID]++
+	}
+
+	var items []GetReplyCountsRow
+	for id, count := range counts {
+		items = append(items, GetReplyCountsRow{
+			InReplyTo:  uuid.NullUUID{UUID: id, Valid: true},
+			ReplyCount: count,
+		})
+	}
+	return items, nil
+}
+
+func (m *MemoryStore) ListChirpDescendants(ctx context.Context, arg ListChirpDescendantsParams) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	descendants := map[uuid.UUID]bool{}
+	for changed := true; changed; {
+		changed = false
+		for _, c := range m.chirps {
+			if descendants[c.ID] || !c.InReplyTo.Valid {
+				continue
+			}
+			parent := c.InReplyTo.UUID
+			if (arg.RootID.Valid && parent == arg.RootID.UUID) || descendants[parent] {
+				descendants[c.ID] = true
+				changed = true
+			}
+		}
+	}
+
+	return m.listChirps(func(c Chirp) bool {
+		return descendants[c.ID]
+	}, arg.CursorCreatedAt, arg.CursorID, arg.PageSize, false), nil
+}
+
+func (m *MemoryStore) listChirps(keep func(Chirp) bool, cursorCreatedAt sql.NullTime, cursorID uuid.NullUUID, pageSize int32, desc bool) []Chirp {
+	items := m.sortedChirps(func(c Chirp) bool {
+		if !keep(c) {
+			return false
+		}
+		if !cursorCreatedAt.Valid {
+			return true
+		}
+		order := compareToCursor(c, cursorCreatedAt.Time, cursorID.UUID)
+		if desc {
+			return order < 0
+		}
+		return order > 0
+	})
+	if desc {
+		slices.Reverse(items)
+	}
+	if pageSize >= 0 && len(items) > int(pageSize) {
+		items = items[:pageSize]
+	}
+	return items
+}
+
+func authoredBy(authorID uuid.NullUUID) func(Chirp) bool {
+	return func(c Chirp) bool {
+		if c.DeletedAt.Valid {
+			return false
+		}
+		return !authorID.Valid || (c.UserID.Valid && c.UserID.UUID == authorID.UUID)
+	}
+}
+
+func (m *MemoryStore) ListChirpsAsc(ctx context.Context, arg ListChirpsAscParams) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.listChirps(authoredBy(arg.AuthorID), arg.CursorCreatedAt, arg.CursorID, arg.PageSize, false), nil
+}
+
+func (m *MemoryStore) ListChirpsDesc(ctx context.Context, arg ListChirpsDescParams) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.listChirps(authoredBy(arg.AuthorID), arg.CursorCreatedAt, arg.CursorID, arg.PageSize, true), nil
+}
+
+func (m *MemoryStore) DeleteChirpAndTombstones(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chirp, ok := m.chirps[id]
+	if !ok {
+		return nil
+	}
+	m.deleteChirp(id)
+
+	parent := chirp.InReplyTo
+	for parent.Valid {
+		tombstone, ok := m.chirps[parent.UUID]
+		if !ok || !tombstone.DeletedAt.Valid || m.hasReplies(parent.UUID) {
+			break
+		}
+		m.deleteChirp(parent.UUID)
+		parent = tombstone.InReplyTo
+	}
+	return nil
+}
+
+func (m *MemoryStore) TombstoneChirp(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chirp, ok := m.chirps[id]
+	if !ok {
+		return nil
+	}
+	now := sql.NullTime{Time: m.timestamp(), Valid: true}
+	chirp.Body = sql.NullString{}
+	chirp.DeletedAt = now
+	chirp.UpdatedAt = now
+	m.chirps[id] = chirp
+	m.deleteChirpRevisions(id)
+	return nil
+}
+
+func (m *MemoryStore) deleteChirpRevisions(chirpID uuid.UUID) {
+	for id, rev := range m.revisions {
+		if rev.ChirpID == chirpID {
+			delete(m.revisions, id)
+		}
+	}
+}
+
+func (m *MemoryStore) EditChirp(ctx context.Context, arg EditChirpParams) (Chirp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chirp, ok := m.chirps[arg.ID]
+	if !ok || chirp.DeletedAt.Valid {
+		return Chirp{}, sql.ErrNoRows
+	}
+
+	now := m.timestamp()
+	revision := ChirpRevision{
+		ID:        uuid.New(),
+		ChirpID:   chirp.ID,
+		Body:      chirp.Body.String,
+		CreatedAt: now,
+	}
+	m.revisions[revision.ID] = revision
+
+	chirp.Body = arg.Body
+	chirp.EditedAt = sql.NullTime{Time: now, Valid: true}
+	chirp.UpdatedAt = sql.NullTime{Time: now, Valid: true}
+	m.chirps[chirp.ID] = chirp
+	return chirp, nil
+}
+
+func (m *MemoryStore) ListChirpRevisions(ctx context.Context, chirpID uuid.UUID) ([]ChirpRevision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []ChirpRevision
+	for _, rev := range m.revisions {
+		if rev.ChirpID == chirpID {
+			items = append(items, rev)
+		}
+	}
+	slices.SortFunc(items, func(a, b ChirpRevision) int {
+		return cmp.Or(
+			b.CreatedAt.Compare(a.CreatedAt),
+			slices.Compare(b.ID[:], a.ID[:]),
+		)
+	})
+	return items, nil
+}
+
+func (m *MemoryStore) FollowUser(ctx context.Context, arg FollowUserParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if arg.FollowerID == arg.FolloweeID {
+		return errCheckViolation
+	}
+	if _, ok := m.users[arg.FollowerID]; !ok {
+		return errForeignKeyViolation
+	}
+	if _, ok := m.users[arg.FolloweeID]; !ok {
+		return errForeignKeyViolation
+	}
+
+	key := followKey{followerID: arg.FollowerID, followeeID: arg.FolloweeID}
+	if _, ok := m.follows[key]; ok {
+		return nil
+	}
+	m.follows[key] = Follow{FollowerID: arg.FollowerID, FolloweeID: arg.FolloweeID, CreatedAt: m.timestamp()}
+	return nil
+}
+
+// listFollows pages through follows newest first. other picks the column
+// that both orders ties and is compared against the cursor id.
+func (m *MemoryStore) listFollows(keep func(Follow) bool, other func(Follow) uuid.UUID, cursorCreatedAt sql.NullTime, cursorID uuid.NullUUID, pageSize int32) []Follow {
+	var items []Follow
+	for _, f := range m.follows {
+		if !keep(f) {
+			continue
+		}
+		if cursorCreatedAt.Valid {
+			id := other(f)
+			order := cmp.Or(
+				f.CreatedAt.Compare(cursorCreatedAt.Time),
+				slices.Compare(id[:], cursorID.UUID[:]),
+			)
+			if order >= 0 {
+				continue
+			}
+		}
+		items = append(items, f)
+	}
+
+	slices.SortFunc(items, func(a, b Follow) int {
+		aID, bID := other(a), other(b)
+		return cmp.Or(
+			b.CreatedAt.Compare(a.CreatedAt),
+			slices.Compare(bID[:], aID[:]),
+		)
+	})
+	if pageSize >= 0 && len(items) > int(pageSize) {
+		items = items[:pageSize]
+	}
+	return items
+}
+
+func (m *MemoryStore) ListFollowers(ctx context.Context, arg ListFollowersParams) ([]Follow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.listFollows(
+		func(f Follow) bool { return f.FolloweeID == arg.UserID },
+		func(f Follow) uuid.UUID { return f.FollowerID },
+		arg.CursorCreatedAt, arg.CursorID, arg.PageSize,
+	), nil
+}
+
+func (m *MemoryStore) ListFollowing(ctx context.Context, arg ListFollowingParams) ([]Follow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.listFollows(
+		func(f Follow) bool { return f.FollowerID == arg.UserID },
+		func(f Follow) uuid.UUID { return f.FolloweeID },
+		arg.CursorCreatedAt, arg.CursorID, arg.PageSize,
+	), nil
+}
+
+func (m *MemoryStore) ListTimeline(ctx context.Context, arg ListTimelineParams) ([]Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.listChirps(func(c Chirp) bool {
+		if c.DeletedAt.Valid || !c.UserID.Valid || !arg.UserID.Valid {
+			return false
+		}
+		if c.UserID.UUID == arg.UserID.UUID {
+			return true
+		}
+		_, follows := m.follows[followKey{followerID: arg.UserID.UUID, followeeID: c.UserID.UUID}]
+		return follows
+	}, arg.CursorCreatedAt, arg.CursorID, arg.PageSize, true), nil
+}
+
+func (m *MemoryStore) UnfollowUser(ctx context.Context, arg UnfollowUserParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.follows, followKey{followerID: arg.FollowerID, followeeID: arg.FolloweeID})
+	return nil
+}
+
+func (m *MemoryStore) GetLikeCounts(ctx context.Context, chirpIds []uuid.UUID) ([]GetLikeCountsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := map[uuid.UUID]int64{}
+	for key := range m.likes {
+		if slices.Contains(chirpIds, key.chirpID) {
+			counts[key.chirpID]++
+		}
+	}
+
+	var items []GetLikeCountsRow
+	for id, count := range counts {
+		items = append(items, GetLikeCountsRow{ChirpID: id, LikeCount: count})
+	}
+	return items, nil
+}
+
+func (m *MemoryStore) GetLikedChirpIDs(ctx context.Context, arg GetLikedChirpIDsParams) ([]uuid.UUID, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []uuid.UUID
+	for key := range m.likes {
+		if key.userID == arg.UserID && slices.Contains(arg.ChirpIds, key.chirpID) {
+			items = append(items, key.chirpID)
+		}
+	}
+	return items, nil
+}
+
+func (m *MemoryStore) LikeChirp(ctx context.Context, arg LikeChirpParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[arg.UserID]; !ok {
+		return errForeignKeyViolation
+	}
+	if _, ok := m.chirps[arg.ChirpID]; !ok {
+		return errForeignKeyViolation
+	}
+
+	key := likeKey{userID: arg.UserID, chirpID: arg.ChirpID}
+	if _, ok := m.likes[key]; ok {
+		return nil
+	}
+	m.likes[key] = Like{UserID: arg.UserID, ChirpID: arg.ChirpID, CreatedAt: m.timestamp()}
+	return nil
+}
+
+func (m *MemoryStore) ListUserLikedChirps(ctx context.Context, arg ListUserLikedChirpsParams) ([]ListUserLikedChirpsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []ListUserLikedChirpsRow
+	for key, like := range m.likes {
+		chirp, ok := m.chirps[key.chirpID]
+		if key.userID != arg.UserID || !ok || chirp.DeletedAt.Valid {
+			continue
+		}
+		if arg.CursorLikedAt.Valid {
+			order := cmp.Or(
+				like.CreatedAt.Compare(arg.CursorLikedAt.Time),
+				slices.Compare(like.ChirpID[:], arg.CursorChirpID.UUID[:]),
+			)
+			if order >= 0 {
+				continue
+			}
+		}
+		items = append(items, ListUserLikedChirpsRow{Chirp: chirp, LikedAt: like.CreatedAt})
+	}
+
+	slices.SortFunc(items, func(a, b ListUserLikedChirpsRow) int {
+		return cmp.Or(
+			b.LikedAt.Compare(a.LikedAt),
+			slices.Compare(b.Chirp.ID[:], a.Chirp.ID[:]),
+		)
+	})
+	if arg.PageSize >= 0 && len(items) > int(arg.PageSize) {
+		items = items[:arg.PageSize]
+	}
+	return items, nil
+}
+
+func (m *MemoryStore) UnlikeChirp(ctx context.Context, arg UnlikeChirpParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.likes, likeKey{userID: arg.UserID, chirpID: arg.ChirpID})
+	return nil
+}
+
+func (m *MemoryStore) FlagChirp(ctx context.Context, arg FlagChirpParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.chirps[arg.ChirpID]; !ok {
+		return errForeignKeyViolation
+	}
+	m.chirpFlags[arg.ChirpID] = ChirpFlag{ChirpID: arg.ChirpID, Matches: arg.Matches, CreatedAt: m.timestamp()}
+	return nil
+}
+
+func (m *MemoryStore) ListBannedWords(ctx context.Context) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []string
+	for phrase := range m.bannedWords {
+		items = append(items, phrase)
+	}
+	slices.Sort(items)
+	return items, nil
+}
+
+func (m *MemoryStore) ClearLoginAttempts(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.loginAttempts, key)
+	return nil
+}
+
+func (m *MemoryStore) GetLoginAttempts(ctx context.Context, key string) (LoginAttempt, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	attempt, ok := m.loginAttempts[key]
+	if !ok {
+		return LoginAttempt{}, sql.ErrNoRows
+	}
+	return attempt, nil
+}
+
+func (m *MemoryStore) RecordLoginFailure(ctx context.Context, arg RecordLoginFailureParams) (LoginAttempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempt, ok := m.loginAttempts[arg.Key]
+	if !ok || attempt.LastFailureAt.Before(arg.Since) {
+		attempt = LoginAttempt{Key: arg.Key}
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = arg.FailedAt.UTC().Truncate(time.Microsecond)
+	m.loginAttempts[arg.Key] = attempt
+	return attempt, nil
+}
+
+func (m *MemoryStore) ReleaseLoginFailure(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempt, ok := m.loginAttempts[key]
+	if ok && attempt.Failures > 0 {
+		attempt.Failures--
+		m.loginAttempts[key] = attempt
+	}
+	return nil
+}
+
+func (m *MemoryStore) ConsumePasswordResetToken(ctx context.Context, tokenHash string) (PasswordResetToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prt, ok := m.resetTokens[tokenHash]
+	if !ok || prt.UsedAt.Valid || m.timestamp().After(prt.ExpiresAt) {
+		return PasswordResetToken{}, sql.ErrNoRows
+	}
+	prt.UsedAt = sql.NullTime{Time: m.timestamp(), Valid: true}
+	m.resetTokens[tokenHash] = prt
+	return prt, nil
+}
+
+func (m *MemoryStore) GetPasswordResetToken(ctx context.Context, tokenHash string) (PasswordResetToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	prt, ok := m.resetTokens[tokenHash]
+	if !ok || prt.UsedAt.Valid || m.timestamp().After(prt.ExpiresAt) {
+		return PasswordResetToken{}, sql.ErrNoRows
+	}
+	return prt, nil
+}
+
+func (m *MemoryStore) CreatePasswordResetToken(ctx context.Context, arg CreatePasswordResetTokenParams) (PasswordResetToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[arg.UserID]; !ok {
+		return PasswordResetToken{}, errForeignKeyViolation
+	}
+	if _, ok := m.resetTokens[arg.TokenHash]; ok {
+		return PasswordResetToken{}, errUniqueViolation
+	}
+
+	prt := PasswordResetToken{
+		ID:        uuid.New(),
+		TokenHash: arg.TokenHash,
+		UserID:    arg.UserID,
+		CreatedAt: m.timestamp(),
+		ExpiresAt: arg.ExpiresAt,
+	}
+	m.resetTokens[arg.TokenHash] = prt
+	return prt, nil
+}
+
+func (m *MemoryStore) DeletePasswordResetTokens(ctx context.Context, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for token, prt := range m.resetTokens {
+		if prt.UserID == userID {
+			delete(m.resetTokens, token)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) CreateRecoveryCode(ctx context.Context, arg CreateRecoveryCodeParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[arg.UserID]; !ok {
+		return errForeignKeyViolation
+	}
+	for _, rc := range m.recoveryCodes {
+		if rc.UserID == arg.UserID && rc.CodeHash == arg.CodeHash {
+			return errUniqueViolation
+		}
+	}
+
+	id := uuid.New()
+	m.recoveryCodes[id] = RecoveryCode{
+		ID:        id,
+		UserID:    arg.UserID,
+		CodeHash:  arg.CodeHash,
+		CreatedAt: m.timestamp(),
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeleteRecoveryCodes(ctx context.Context, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, rc := range m.recoveryCodes {
+		if rc.UserID == userID {
+			delete(m.recoveryCodes, id)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) UseRecoveryCode(ctx context.Context, arg UseRecoveryCodeParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, rc := range m.recoveryCodes {
+		if rc.UserID == arg.UserID && rc.CodeHash == arg.CodeHash && !rc.UsedAt.Valid {
+			rc.UsedAt = sql.NullTime{Time: m.timestamp(), Valid: true}
+			m.recoveryCodes[id] = rc
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.refreshTokens[arg.ID]; ok {
+		return RefreshToken{}, errUniqueViolation
+	}
+	if arg.UserID.Valid {
+		if _, ok := m.users[arg.UserID.UUID]; !ok {
+			return RefreshToken{}, errForeignKeyViolation
+		}
+	}
+
+	now := sql.NullTime{Time: m.timestamp(), Valid: true}
+	token := RefreshToken{
+		ID:        arg.ID,
+		TokenHash: arg.TokenHash,
+		CreatedAt: now,
+		UpdatedAt: now,
+		UserID:    arg.UserID,
+		ExpiresAt: arg.ExpiresAt,
+		FamilyID:  arg.FamilyID,
+		UserAgent: arg.UserAgent,
+		IpAddress: arg.IpAddress,
+	}
+	m.refreshTokens[token.ID] = token
+	return token, nil
+}
+
+func (m *MemoryStore) GetRefreshToken(ctx context.Context, id uuid.UUID) (RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	refreshToken, ok := m.refreshTokens[id]
+	if !ok {
+		return RefreshToken{}, sql.ErrNoRows
+	}
+	return refreshToken, nil
+}
+
+// usable mirrors `revoked_at IS NULL AND NOW() <= expires_at`. Without an
+// expiry the comparison is NULL, and therefore false.
+func (m *MemoryStore) usable(rt RefreshToken) bool {
+	return !rt.RevokedAt.Valid && rt.ExpiresAt.Valid && !m.timestamp().After(rt.ExpiresAt.Time)
+}
+
+func (m *MemoryStore) GetUserFromRefreshToken(ctx context.Context, id uuid.UUID) (RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	refreshToken, ok := m.refreshTokens[id]
+	if !ok || !m.usable(refreshToken) {
+		return RefreshToken{}, sql.ErrNoRows
+	}
+	return refreshToken, nil
+}
+
+func (m *MemoryStore) RevokeRefreshToken(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	refreshToken, ok := m.refreshTokens[id]
+	if !ok {
+		return nil
+	}
+	refreshToken.RevokedAt = sql.NullTime{Time: m.timestamp(), Valid: true}
+	m.refreshTokens[id] = refreshToken
+	return nil
+}
+
+func (m *MemoryStore) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revokeWhere(func(rt RefreshToken) bool { return rt.FamilyID == familyID })
+	return nil
+}
+
+func (m *MemoryStore) ListActiveSessions(ctx context.Context, userID uuid.NullUUID) ([]ListActiveSessionsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	started := map[uuid.UUID]time.Time{}
+	for _, rt := range m.refreshTokens {
+		first, ok := started[rt.FamilyID]
+		if !ok || rt.CreatedAt.Time.Before(first) {
+			started[rt.FamilyID] = rt.CreatedAt.Time
+		}
+	}
+
+	items := []ListActiveSessionsRow{}
+	for _, rt := range m.refreshTokens {
+		if !userID.Valid || rt.UserID != userID || !m.usable(rt) {
+			continue
+		}
+		items = append(items, ListActiveSessionsRow{
+			FamilyID:   rt.FamilyID,
+			StartedAt:  started[rt.FamilyID],
+			LastUsedAt: rt.CreatedAt,
+			ExpiresAt:  rt.ExpiresAt,
+			UserAgent:  rt.UserAgent,
+			IpAddress:  rt.IpAddress,
+		})
+	}
+	slices.SortFunc(items, func(a, b ListActiveSessionsRow) int {
+		return b.LastUsedAt.Time.Compare(a.LastUsedAt.Time)
+	})
+	return items, nil
+}
+
+func (m *MemoryStore) ListUserRefreshTokens(ctx context.Context, userID uuid.NullUUID) ([]RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	items := []RefreshToken{}
+	for _, rt := range m.refreshTokens {
+		if userID.Valid && rt.UserID == userID {
+			items = append(items, rt)
+		}
+	}
+	slices.SortFunc(items, func(a, b RefreshToken) int {
+		return a.CreatedAt.Time.Compare(b.CreatedAt.Time)
+	})
+	return items, nil
+}
+
+func (m *MemoryStore) RevokeUserSession(ctx context.Context, arg RevokeUserSessionParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.revokeWhere(func(rt RefreshToken) bool {
+		return arg.UserID.Valid && rt.UserID == arg.UserID && rt.FamilyID == arg.FamilyID
+	}), nil
+}
+
+func (m *MemoryStore) RevokeOtherUserSessions(ctx context.Context, arg RevokeOtherUserSessionsParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revokeWhere(func(rt RefreshToken) bool {
+		return arg.UserID.Valid && rt.UserID == arg.UserID && rt.FamilyID != arg.FamilyID
+	})
+	return nil
+}
+
+func (m *MemoryStore) RevokeAllUserRefreshTokens(ctx context.Context, userID uuid.NullUUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revokeWhere(func(rt RefreshToken) bool {
+		return userID.Valid && rt.UserID == userID
+	})
+	return nil
+}
+
+// revokeWhere revokes every unrevoked token matching keep and reports how
+// many it touched. Callers must hold the write lock.
+func (m *MemoryStore) revokeWhere(keep func(RefreshToken) bool) int64 {
+	now := sql.NullTime{Time: m.timestamp(), Valid: true}
+	var revoked int64
+	for token, rt := range m.refreshTokens {
+		if rt.RevokedAt.Valid || !keep(rt) {
+			continue
+		}
+		rt.RevokedAt = now
+		rt.UpdatedAt = now
+		m.refreshTokens[token] = rt
+		revoked++
+	}
+	return revoked
+}
+
+func (m *MemoryStore) RotateRefreshToken(ctx context.Context, arg RotateRefreshTokenParams) (RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	refreshToken, ok := m.refreshTokens[arg.ID]
+	if !ok || !m.usable(refreshToken) {
+		return RefreshToken{}, sql.ErrNoRows
+	}
+	if _, ok := m.refreshTokens[arg.NewID]; ok {
+		return RefreshToken{}, errUniqueViolation
+	}
+	now := sql.NullTime{Time: m.timestamp(), Valid: true}
+	refreshToken.RevokedAt = now
+	refreshToken.UpdatedAt = now
+	m.refreshTokens[arg.ID] = refreshToken
+
+	next := RefreshToken{
+		ID:        arg.NewID,
+		TokenHash: arg.NewTokenHash,
+		CreatedAt: now,
+		UpdatedAt: now,
+		UserID:    refreshToken.UserID,
+		ExpiresAt: arg.ExpiresAt,
+		FamilyID:  refreshToken.FamilyID,
+		UserAgent: arg.UserAgent,
+		IpAddress: arg.IpAddress,
+	}
+	m.refreshTokens[next.ID] = next
+	return next, nil
+}
+
+func (m *MemoryStore) DeleteTOTPCredential(ctx context.Context, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.totp, userID)
+	return nil
+}
+
+func (m *MemoryStore) EnableTOTPCredential(ctx context.Context, arg EnableTOTPCredentialParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cred, ok := m.totp[arg.UserID]
+	if !ok || cred.EnabledAt.Valid {
+		return 0, nil
+	}
+	cred.EnabledAt = sql.NullTime{Time: m.timestamp(), Valid: true}
+	cred.LastUsedStep = arg.LastUsedStep
+	m.totp[arg.UserID] = cred
+	return 1, nil
+}
+
+func (m *MemoryStore) GetTOTPCredential(ctx context.Context, userID uuid.UUID) (TotpCredential, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cred, ok := m.totp[userID]
+	if !ok {
+		return TotpCredential{}, sql.ErrNoRows
+	}
+	return cred, nil
+}
+
+func (m *MemoryStore) StartTOTPEnrollment(ctx context.Context, arg StartTOTPEnrollmentParams) (TotpCredential, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[arg.UserID]; !ok {
+		return TotpCredential{}, errForeignKeyViolation
+	}
+	if cred, ok := m.totp[arg.UserID]; ok && cred.EnabledAt.Valid {
+		return TotpCredential{}, sql.ErrNoRows
+	}
+
+	cred := TotpCredential{
+		UserID:    arg.UserID,
+		Secret:    arg.Secret,
+		CreatedAt: m.timestamp(),
+	}
+	m.totp[arg.UserID] = cred
+	return cred, nil
+}
+
+func (m *MemoryStore) UseTOTPStep(ctx context.Context, arg UseTOTPStepParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cred, ok := m.totp[arg.UserID]
+	if !ok || !cred.EnabledAt.Valid || cred.LastUsedStep >= arg.LastUsedStep {
+		return 0, nil
+	}
+	cred.LastUsedStep = arg.LastUsedStep
+	m.totp[arg.UserID] = cred
+	return 1, nil
+}
+
+func (m *MemoryStore) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.emailTaken(arg.Email, uuid.Nil) || m.handleTaken(arg.Handle, uuid.Nil) {
+		return User{}, errUniqueViolation
+	}
+	now := sql.NullTime{Time: m.timestamp(), Valid: true}
+	user := User{
+		ID:             uuid.New(),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		Email:          arg.Email,
+		HashedPassword: arg.HashedPassword,
+		IsChirpyRed:    sql.NullBool{Bool: false, Valid: true},
+		Handle:         arg.Handle,
+	}
+	m.users[user.ID] = user
+	return user, nil
+}
+
+func (m *MemoryStore) DeleteAllUsers(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clear(m.users)
+	// chirps, tokens, totp_credentials and recovery_codes reference users
+	// ON DELETE CASCADE.
+	for id, c := range m.chirps {
+		if c.UserID.Valid {
+			m.deleteChirp(id)
+		}
+	}
+	clear(m.follows)
+	clear(m.likes)
+	for token, rt := range m.refreshTokens {
+		if rt.UserID.Valid {
+			delete(m.refreshTokens, token)
+		}
+	}
+	clear(m.resetTokens)
+	clear(m.totp)
+	clear(m.recoveryCodes)
+	return nil
+}
+
+func (m *MemoryStore) CancelUserDeletion(ctx context.Context, id uuid.UUID) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok || !user.DeleteAfter.Valid {
+		return 0, nil
+	}
+	user.DeleteAfter = sql.NullTime{}
+	user.UpdatedAt = sql.NullTime{Time: m.timestamp(), Valid: true}
+	m.users[id] = user
+	return 1, nil
+}
+
+func (m *MemoryStore) ListUsersDueForDeletion(ctx context.Context, deleteAfter sql.NullTime) ([]uuid.UUID, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var due []User
+	for _, u := range m.users {
+		if m.dueForDeletion(u, deleteAfter) {
+			due = append(due, u)
+		}
+	}
+	slices.SortFunc(due, func(a, b User) int {
+		return a.DeleteAfter.Time.Compare(b.DeleteAfter.Time)
+	})
+
+	ids := make([]uuid.UUID, len(due))
+	for i, u := range due {
+		ids[i] = u.ID
+	}
+	return ids, nil
+}
+
+func (m *MemoryStore) dueForDeletion(user User, dueBy sql.NullTime) bool {
+	return user.DeleteAfter.Valid && dueBy.Valid && !user.DeleteAfter.Time.After(dueBy.Time)
+}
+
+func (m *MemoryStore) PurgeUser(ctx context.Context, arg PurgeUserParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[arg.ID]
+	if !ok || !m.dueForDeletion(user, arg.DueBy) {
+		return 0, nil
+	}
+	m.deleteUser(arg.ID)
+	return 1, nil
+}
+
+func (m *MemoryStore) PurgeUserKeepChirps(ctx context.Context, arg PurgeUserKeepChirpsParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[arg.ID]
+	if !ok || !m.dueForDeletion(user, arg.DueBy) {
+		return 0, nil
+	}
+	for id, c := range m.chirps {
+		if c.UserID.Valid && c.UserID.UUID == arg.ID {
+			c.UserID = uuid.NullUUID{}
+			m.chirps[id] = c
+		}
+	}
+	m.deleteUser(arg.ID)
+	return 1, nil
+}
+
+// deleteUser removes a user and, like ON DELETE CASCADE, everything that
+// references them. Callers must hold the write lock.
+func (m *MemoryStore) deleteUser(id uuid.UUID) {
+	delete(m.users, id)
+	for chirpID, c := range m.chirps {
+		if c.UserID.Valid && c.UserID.UUID == id {
+			m.deleteChirp(chirpID)
+		}
+	}
+	for key := range m.follows {
+		if key.followerID == id || key.followeeID == id {
+			delete(m.follows, key)
+		}
+	}
+	for key := range m.likes {
+		if key.userID == id {
+			delete(m.likes, key)
+		}
+	}
+	for token, rt := range m.refreshTokens {
+		if rt.UserID.Valid && rt.UserID.UUID == id {
+			delete(m.refreshTokens, token)
+		}
+	}
+	for token, prt := range m.resetTokens {
+		if prt.UserID == id {
+			delete(m.resetTokens, token)
+		}
+	}
+	delete(m.totp, id)
+	for codeID, rc := range m.recoveryCodes {
+		if rc.UserID == id {
+			delete(m.recoveryCodes, codeID)
+		}
+	}
+}
+
+func (m *MemoryStore) ScheduleUserDeletion(ctx context.Context, arg ScheduleUserDeletionParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[arg.ID]
+	if !ok {
+		return nil
+	}
+	user.DeleteAfter = arg.DeleteAfter
+	user.UpdatedAt = sql.NullTime{Time: m.timestamp(), Valid: true}
+	m.users[arg.ID] = user
+	return nil
+}
+
+func (m *MemoryStore) GetUser(ctx context.Context, email sql.NullString) (User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !email.Valid {
+		return User{}, sql.ErrNoRows
+	}
+	for _, u := range m.sortedUsers() {
+		if u.Email.Valid && strings.EqualFold(u.Email.String, email.String) {
+			return u, nil
+		}
+	}
+	return User{}, sql.ErrNoRows
+}
+
+func (m *MemoryStore) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return User{}, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (m *MemoryStore) GetUserByHandle(ctx context.Context, handle string) (User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if user.Handle.Valid && strings.EqualFold(user.Handle.String, handle) {
+			return user, nil
+		}
+	}
+	return User{}, sql.ErrNoRows
+}
+
+func (m *MemoryStore) GetUsersByIDs(ctx context.Context, ids []uuid.UUID) ([]User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	items := []User{}
+	for _, id := range ids {
+		if user, ok := m.users[id]; ok {
+			items = append(items, user)
+		}
+	}
+	return items, nil
+}
+
+func (m *MemoryStore) SetUserPassword(ctx context.Context, arg SetUserPasswordParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[arg.ID]
+	if !ok {
+		return nil
+	}
+	user.HashedPassword = arg.HashedPassword
+	user.UpdatedAt = sql.NullTime{Time: m.timestamp(), Valid: true}
+	m.users[arg.ID] = user
+	return nil
+}
+
+func (m *MemoryStore) RehashUserPassword(ctx context.Context, arg RehashUserPasswordParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[arg.ID]
+	if !ok || user.HashedPassword != arg.OldHashedPassword {
+		return 0, nil
+	}
+	user.HashedPassword = arg.HashedPassword
+	user.UpdatedAt = sql.NullTime{Time: m.timestamp(), Valid: true}
+	m.users[arg.ID] = user
+	return 1, nil
+}
+
+func (m *MemoryStore) RequestEmailChange(ctx context.Context, arg RequestEmailChangeParams) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[arg.ID]
+	if !ok {
+		return User{}, sql.ErrNoRows
+	}
+	user.PendingEmail = arg.PendingEmail
+	user.UpdatedAt = sql.NullTime{Time: m.timestamp(), Valid: true}
+	m.users[arg.ID] = user
+	return user, nil
+}
+
+func (m *MemoryStore) ConfirmEmailChange(ctx context.Context, arg ConfirmEmailChangeParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[arg.ID]
+	if !ok || !arg.PendingEmail.Valid || user.PendingEmail != arg.PendingEmail {
+		return 0, nil
+	}
+	if m.emailTaken(user.PendingEmail, user.ID) {
+		return 0, errUniqueViolation
+	}
+	now := sql.NullTime{Time: m.timestamp(), Valid: true}
+	user.Email = user.PendingEmail
+	user.PendingEmail = sql.NullString{}
+	user.EmailVerifiedAt = now
+	user.UpdatedAt = now
+	m.users[arg.ID] = user
+	return 1, nil
+}
+
+// emailTaken mirrors the unique index on LOWER(email), ignoring the user
+// being updated. The caller must hold m.mu.
+func (m *MemoryStore) emailTaken(email sql.NullString, except uuid.UUID) bool {
+	if !email.Valid {
+		return false
+	}
+	for id, user := range m.users {
+		if id != except && user.Email.Valid && strings.EqualFold(user.Email.String, email.String) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryStore) UpdateUserProfile(ctx context.Context, arg UpdateUserProfileParams) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[arg.ID]
+	if !ok {
+		return User{}, sql.ErrNoRows
+	}
+	if m.handleTaken(arg.Handle, arg.ID) {
+		return User{}, errUniqueViolation
+	}
+	user.Handle = arg.Handle
+	user.DisplayName = arg.DisplayName
+	user.Bio = arg.Bio
+	user.AvatarUrl = arg.AvatarUrl
+	user.UpdatedAt = sql.NullTime{Time: m.timestamp(), Valid: true}
+	m.users[arg.ID] = user
+	return user, nil
+}
+
+// handleTaken mirrors the unique index on LOWER(handle), ignoring the user
+// being updated. The caller must hold m.mu.
+func (m *MemoryStore) handleTaken(handle sql.NullString, except uuid.UUID) bool {
+	if !handle.Valid {
+		return false
+	}
+	for id, user := range m.users {
+		if id != except && user.Handle.Valid && strings.EqualFold(user.Handle.String, handle.String) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryStore) UpgradeToChirpyRed(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return nil
+	}
+	user.IsChirpyRed = sql.NullBool{Bool: true, Valid: true}
+	m.users[id] = user
+	return nil
+}
+
+func (m *MemoryStore) VerifyUserEmail(ctx context.Context, arg VerifyUserEmailParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[arg.ID]
+	if !ok || !arg.Email.Valid || user.Email != arg.Email {
+		return 0, nil
+	}
+	now := sql.NullTime{Time: m.timestamp(), Valid: true}
+	if !user.EmailVerifiedAt.Valid {
+		user.EmailVerifiedAt = now
+	}
+	user.UpdatedAt = now
+	m.users[arg.ID] = user
+	return 1, nil
+}
+
+// sortedUsers gives LIMIT 1 lookups a deterministic answer.
+func (m *MemoryStore) sortedUsers() []User {
+	users := make([]User, 0, len(m.users))
+	for _, u := range m.users {
+		users = append(users, u)
+	}
+	slices.SortFunc(users, func(a, b User) int {
+		return cmp.Or(
+			a.CreatedAt.Time.Compare(b.CreatedAt.Time),
+			slices.Compare(a.ID[:], b.ID[:]),
+		)
+	})
+	return users
+}
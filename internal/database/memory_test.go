@@ -0,0 +1,315 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestStore(t *testing.T) (*MemoryStore, *fakeClock) {
+	t.Helper()
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	return NewMemoryStoreWithClock(clock.Now), clock
+}
+
+func mustCreateUser(t *testing.T, store *MemoryStore, email string) User {
+	t.Helper()
+	user, err := store.CreateUser(context.Background(), CreateUserParams{
+		Email:          sql.NullString{String: email, Valid: true},
+		HashedPassword: "hash",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestMemoryStoreNoRows(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+
+	if _, err := store.GetChirp(ctx, uuid.New()); err != sql.ErrNoRows {
+		t.Errorf("GetChirp() error = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := store.GetUser(ctx, sql.NullString{String: "nobody@example.com", Valid: true}); err != sql.ErrNoRows {
+		t.Errorf("GetUser() error = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := store.GetUserFromRefreshToken(ctx, uuid.New()); err != sql.ErrNoRows {
+		t.Errorf("GetUserFromRefreshToken() error = %v, want sql.ErrNoRows", err)
+	}
+	if err := store.UpgradeToChirpyRed(ctx, uuid.New()); err != nil {
+		t.Errorf("UpgradeToChirpyRed() error = %v, want nil for :exec", err)
+	}
+}
+
+func TestMemoryStoreRehashUserPassword(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+	user := mustCreateUser(t, store, "mike@example.com")
+
+	// A password change got in first; the stale rehash must not undo it.
+	if err := store.SetUserPassword(ctx, SetUserPasswordParams{ID: user.ID, HashedPassword: "changed"}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := store.RehashUserPassword(ctx, RehashUserPasswordParams{ID: user.ID, HashedPassword: "rehashed", OldHashedPassword: user.HashedPassword})
+	if err != nil || n != 0 {
+		t.Errorf("stale RehashUserPassword() = %d, %v, want 0, nil", n, err)
+	}
+	n, err = store.RehashUserPassword(ctx, RehashUserPasswordParams{ID: user.ID, HashedPassword: "rehashed", OldHashedPassword: "changed"})
+	if err != nil || n != 1 {
+		t.Errorf("RehashUserPassword() = %d, %v, want 1, nil", n, err)
+	}
+	if got, _ := store.GetUserByID(ctx, user.ID); got.HashedPassword != "rehashed" {
+		t.Errorf("hash = %q, want rehashed", got.HashedPassword)
+	}
+}
+
+func TestMemoryStoreRefreshTokens(t *testing.T) {
+	store, clock := newTestStore(t)
+	ctx := context.Background()
+	user := mustCreateUser(t, store, "a@example.com")
+
+	id := uuid.New()
+	_, err := store.CreateRefreshToken(ctx, CreateRefreshTokenParams{
+		ID:        id,
+		TokenHash: "token",
+		UserID:    uuid.NullUUID{UUID: user.ID, Valid: true},
+		ExpiresAt: sql.NullTime{Time: clock.Now().Add(time.Hour), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.GetUserFromRefreshToken(ctx, id); err != nil {
+		t.Fatalf("GetUserFromRefreshToken() error = %v", err)
+	}
+
+	clock.Advance(2 * time.Hour)
+	if _, err := store.GetUserFromRefreshToken(ctx, id); err != sql.ErrNoRows {
+		t.Errorf("expired token: error = %v, want sql.ErrNoRows", err)
+	}
+
+	clock.Advance(-2 * time.Hour)
+	if err := store.RevokeRefreshToken(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetUserFromRefreshToken(ctx, id); err != sql.ErrNoRows {
+		t.Errorf("revoked token: error = %v, want sql.ErrNoRows", err)
+	}
+
+	_, err = store.CreateRefreshToken(ctx, CreateRefreshTokenParams{ID: id, TokenHash: "other", FamilyID: uuid.New()})
+	if err == nil {
+		t.Error("CreateRefreshToken() with duplicate id should fail")
+	}
+}
+
+func TestMemoryStoreRotateRefreshToken(t *testing.T) {
+	store, clock := newTestStore(t)
+	ctx := context.Background()
+	user := mustCreateUser(t, store, "a@example.com")
+	expires := sql.NullTime{Time: clock.Now().Add(time.Hour), Valid: true}
+
+	first, err := store.CreateRefreshToken(ctx, CreateRefreshTokenParams{
+		ID:        uuid.New(),
+		TokenHash: "first",
+		UserID:    uuid.NullUUID{UUID: user.ID, Valid: true},
+		ExpiresAt: expires,
+		FamilyID:  uuid.New(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := store.RotateRefreshToken(ctx, RotateRefreshTokenParams{ID: first.ID, NewID: uuid.New(), NewTokenHash: "second", ExpiresAt: expires})
+	if err != nil {
+		t.Fatalf("RotateRefreshToken() error = %v", err)
+	}
+	if next.TokenHash != "second" || next.FamilyID != first.FamilyID || next.UserID != first.UserID {
+		t.Errorf("successor = %+v, want token second in family %v", next, first.FamilyID)
+	}
+	if _, err := store.GetUserFromRefreshToken(ctx, first.ID); err != sql.ErrNoRows {
+		t.Errorf("rotated token: error = %v, want sql.ErrNoRows", err)
+	}
+
+	// The same token cannot be rotated twice, and nothing is issued then.
+	third := uuid.New()
+	if _, err := store.RotateRefreshToken(ctx, RotateRefreshTokenParams{ID: first.ID, NewID: third, NewTokenHash: "third", ExpiresAt: expires}); err != sql.ErrNoRows {
+		t.Errorf("second rotation: error = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := store.GetRefreshToken(ctx, third); err != sql.ErrNoRows {
+		t.Errorf("failed rotation issued a token: error = %v", err)
+	}
+}
+
+func TestMemoryStoreListChirps(t *testing.T) {
+	store, clock := newTestStore(t)
+	ctx := context.Background()
+	user := mustCreateUser(t, store, "a@example.com")
+	other := mustCreateUser(t, store, "b@example.com")
+
+	var ids []uuid.UUID
+	for i := 0; i < 5; i++ {
+		author := user.ID
+		if i%2 == 1 {
+			author = other.ID
+		}
+		chirp, err := store.CreateChirp(ctx, CreateChirpParams{
+			Body:   sql.NullString{String: "chirp", Valid: true},
+			UserID: uuid.NullUUID{UUID: author, Valid: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, chirp.ID)
+		clock.Advance(time.Second)
+	}
+
+	page, err := store.ListChirpsAsc(ctx, ListChirpsAscParams{PageSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 2 || page[0].ID != ids[0] || page[1].ID != ids[1] {
+		t.Fatalf("ListChirpsAsc() first page = %v", page)
+	}
+
+	last := page[len(page)-1]
+	page, _ = store.ListChirpsAsc(ctx, ListChirpsAscParams{
+		CursorCreatedAt: last.CreatedAt,
+		CursorID:        uuid.NullUUID{UUID: last.ID, Valid: true},
+		PageSize:        10,
+	})
+	if len(page) != 3 || page[0].ID != ids[2] {
+		t.Fatalf("ListChirpsAsc() second page = %v", page)
+	}
+
+	page, _ = store.ListChirpsDesc(ctx, ListChirpsDescParams{
+		AuthorID: uuid.NullUUID{UUID: user.ID, Valid: true},
+		PageSize: 10,
+	})
+	if len(page) != 3 || page[0].ID != ids[4] || page[2].ID != ids[0] {
+		t.Fatalf("ListChirpsDesc() by author = %v", page)
+	}
+}
+
+func TestMemoryStoreDeleteAllUsersCascades(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+	user := mustCreateUser(t, store, "a@example.com")
+
+	chirp, err := store.CreateChirp(ctx, CreateChirpParams{
+		Body:   sql.NullString{String: "chirp", Valid: true},
+		UserID: uuid.NullUUID{UUID: user.ID, Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.DeleteAllUsers(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetChirp(ctx, chirp.ID); err != sql.ErrNoRows {
+		t.Errorf("GetChirp() after DeleteAllUsers error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestMemoryStorePurgeUser(t *testing.T) {
+	store, clock := newTestStore(t)
+	ctx := context.Background()
+	leaving := mustCreateUser(t, store, "a@example.com")
+	staying := mustCreateUser(t, store, "b@example.com")
+
+	chirp, err := store.CreateChirp(ctx, CreateChirpParams{
+		Body:   sql.NullString{String: "chirp", Valid: true},
+		UserID: uuid.NullUUID{UUID: leaving.ID, Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.FollowUser(ctx, FollowUserParams{FollowerID: staying.ID, FolloweeID: leaving.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := sql.NullTime{Time: clock.Now().Add(time.Hour), Valid: true}
+	if err := store.ScheduleUserDeletion(ctx, ScheduleUserDeletionParams{ID: leaving.ID, DeleteAfter: deadline}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := sql.NullTime{Time: clock.Now(), Valid: true}
+	if due, _ := store.ListUsersDueForDeletion(ctx, now); len(due) != 0 {
+		t.Errorf("due before the deadline: %v", due)
+	}
+	if n, _ := store.PurgeUserKeepChirps(ctx, PurgeUserKeepChirpsParams{ID: leaving.ID, DueBy: now}); n != 0 {
+		t.Error("PurgeUserKeepChirps() purged a user before the deadline")
+	}
+
+	clock.Advance(2 * time.Hour)
+	now = sql.NullTime{Time: clock.Now(), Valid: true}
+	if due, _ := store.ListUsersDueForDeletion(ctx, now); len(due) != 1 || due[0] != leaving.ID {
+		t.Errorf("ListUsersDueForDeletion() = %v, want [%v]", due, leaving.ID)
+	}
+	if n, _ := store.PurgeUserKeepChirps(ctx, PurgeUserKeepChirpsParams{ID: leaving.ID, DueBy: now}); n != 1 {
+		t.Fatal("PurgeUserKeepChirps() did not purge a due user")
+	}
+
+	if _, err := store.GetUserByID(ctx, leaving.ID); err != sql.ErrNoRows {
+		t.Errorf("GetUserByID() after purge error = %v, want sql.ErrNoRows", err)
+	}
+	kept, err := store.GetChirp(ctx, chirp.ID)
+	if err != nil || kept.UserID.Valid {
+		t.Errorf("GetChirp() after purge = %+v, %v, want a chirp without author", kept, err)
+	}
+	following, _ := store.ListFollowing(ctx, ListFollowingParams{UserID: staying.ID, PageSize: 10})
+	if len(following) != 0 {
+		t.Errorf("follows survived the purge: %v", following)
+	}
+
+	// A cancelled deletion is not carried out.
+	if err := store.ScheduleUserDeletion(ctx, ScheduleUserDeletionParams{ID: staying.ID, DeleteAfter: now}); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := store.CancelUserDeletion(ctx, staying.ID); n != 1 {
+		t.Error("CancelUserDeletion() cancelled nothing")
+	}
+	if n, _ := store.PurgeUser(ctx, PurgeUserParams{ID: staying.ID, DueBy: now}); n != 0 {
+		t.Error("PurgeUser() purged a user whose deletion was cancelled")
+	}
+}
+
+func TestMemoryStoreHandles(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+	walt := mustCreateUser(t, store, "walt@example.com")
+	jesse := mustCreateUser(t, store, "jesse@example.com")
+
+	_, err := store.UpdateUserProfile(ctx, UpdateUserProfileParams{ID: walt.ID, Handle: sql.NullString{String: "Heisenberg", Valid: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found, err := store.GetUserByHandle(ctx, "HEISENBERG"); err != nil || found.ID != walt.ID {
+		t.Errorf("GetUserByHandle() = %v, %v; want walt", found.ID, err)
+	}
+
+	_, err = store.UpdateUserProfile(ctx, UpdateUserProfileParams{ID: jesse.ID, Handle: sql.NullString{String: "heisenberg", Valid: true}})
+	if err == nil {
+		t.Error("UpdateUserProfile() with a handle differing only in case should fail")
+	}
+	_, err = store.UpdateUserProfile(ctx, UpdateUserProfileParams{ID: walt.ID, Handle: sql.NullString{String: "heisenberg", Valid: true}, Bio: "chemist"})
+	if err != nil {
+		t.Errorf("UpdateUserProfile() keeping own handle error = %v", err)
+	}
+}
@@ -6,32 +6,108 @@ package database
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+type BannedWord struct {
+	Phrase    string
+	CreatedAt time.Time
+}
+
 type Chirp struct {
 	ID        uuid.UUID
 	CreatedAt sql.NullTime
 	UpdatedAt sql.NullTime
 	Body      sql.NullString
 	UserID    uuid.NullUUID
+	InReplyTo uuid.NullUUID
+	DeletedAt sql.NullTime
+	EditedAt  sql.NullTime
+}
+
+type ChirpFlag struct {
+	ChirpID   uuid.UUID
+	Matches   string
+	CreatedAt time.Time
+}
+
+type ChirpRevision struct {
+	ID        uuid.UUID
+	ChirpID   uuid.UUID
+	Body      string
+	CreatedAt time.Time
+}
+
+type Follow struct {
+	FollowerID uuid.UUID
+	FolloweeID uuid.UUID
+	CreatedAt  time.Time
+}
+
+type Like struct {
+	UserID    uuid.UUID
+	ChirpID   uuid.UUID
+	CreatedAt time.Time
+}
+
+type LoginAttempt struct {
+	Key           string
+	Failures      int32
+	LastFailureAt time.Time
+}
+
+type PasswordResetToken struct {
+	ID        uuid.UUID
+	TokenHash string
+	UserID    uuid.UUID
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type RecoveryCode struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	CodeHash  string
+	CreatedAt time.Time
+	UsedAt    sql.NullTime
 }
 
 type RefreshToken struct {
-	Token     string
 	CreatedAt sql.NullTime
 	UpdatedAt sql.NullTime
 	UserID    uuid.NullUUID
 	ExpiresAt sql.NullTime
 	RevokedAt sql.NullTime
+	FamilyID  uuid.UUID
+	UserAgent sql.NullString
+	IpAddress sql.NullString
+	ID        uuid.UUID
+	TokenHash string
+}
+
+type TotpCredential struct {
+	UserID       uuid.UUID
+	Secret       string
+	CreatedAt    time.Time
+	EnabledAt    sql.NullTime
+	LastUsedStep int64
 }
 
 type User struct {
-	ID             uuid.UUID
-	CreatedAt      sql.NullTime
-	UpdatedAt      sql.NullTime
-	Email          sql.NullString
-	HashedPassword string
-	IsChirpyRed    sql.NullBool
+	ID              uuid.UUID
+	CreatedAt       sql.NullTime
+	UpdatedAt       sql.NullTime
+	Email           sql.NullString
+	HashedPassword  string
+	IsChirpyRed     sql.NullBool
+	EmailVerifiedAt sql.NullTime
+	DeleteAfter     sql.NullTime
+	Handle          sql.NullString
+	DisplayName     string
+	Bio             string
+	AvatarUrl       string
+	PendingEmail    sql.NullString
 }
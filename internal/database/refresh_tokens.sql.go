@@ -8,65 +8,312 @@ package database
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 const createRefreshToken = `-- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens(token, created_at, updated_at, user_id, expires_at, revoked_at)
+INSERT INTO refresh_tokens(id, token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, user_agent, ip_address)
 VALUES(
-  $1, NOW(), NOW(), $2, $3, NULL
+  $1, $2, NOW(), NOW(), $3, $4, NULL, $5, $6, $7
 )
-RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at
+RETURNING created_at, updated_at, user_id, expires_at, revoked_at, family_id, user_agent, ip_address, id, token_hash
 `
 
 type CreateRefreshTokenParams struct {
-	Token     string
+	ID        uuid.UUID
+	TokenHash string
 	UserID    uuid.NullUUID
 	ExpiresAt sql.NullTime
+	FamilyID  uuid.UUID
+	UserAgent sql.NullString
+	IpAddress sql.NullString
 }
 
 func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, createRefreshToken, arg.Token, arg.UserID, arg.ExpiresAt)
+	row := q.db.QueryRowContext(ctx, createRefreshToken,
+		arg.ID,
+		arg.TokenHash,
+		arg.UserID,
+		arg.ExpiresAt,
+		arg.FamilyID,
+		arg.UserAgent,
+		arg.IpAddress,
+	)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.ID,
+		&i.TokenHash,
+	)
+	return i, err
+}
+
+const getRefreshToken = `-- name: GetRefreshToken :one
+SELECT created_at, updated_at, user_id, expires_at, revoked_at, family_id, user_agent, ip_address, id, token_hash FROM refresh_tokens
+WHERE id = $1
+`
+
+func (q *Queries) GetRefreshToken(ctx context.Context, id uuid.UUID) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getRefreshToken, id)
+	var i RefreshToken
+	err := row.Scan(
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.FamilyID,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.ID,
+		&i.TokenHash,
 	)
 	return i, err
 }
 
 const getUserFromRefreshToken = `-- name: GetUserFromRefreshToken :one
-SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+SELECT created_at, updated_at, user_id, expires_at, revoked_at, family_id, user_agent, ip_address, id, token_hash
 FROM refresh_tokens
-WHERE revoked_at IS NULL AND NOW() <= expires_at AND token = $1 LIMIT 1
+WHERE revoked_at IS NULL AND NOW() <= expires_at AND id = $1
 `
 
-func (q *Queries) GetUserFromRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, getUserFromRefreshToken, token)
+func (q *Queries) GetUserFromRefreshToken(ctx context.Context, id uuid.UUID) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getUserFromRefreshToken, id)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.ID,
+		&i.TokenHash,
 	)
 	return i, err
 }
 
+const listActiveSessions = `-- name: ListActiveSessions :many
+SELECT
+  active.family_id,
+  (SELECT MIN(started.created_at) FROM refresh_tokens started WHERE started.family_id = active.family_id)::timestamp AS started_at,
+  active.created_at AS last_used_at,
+  active.expires_at,
+  active.user_agent,
+  active.ip_address
+FROM refresh_tokens active
+WHERE active.user_id = $1 AND active.revoked_at IS NULL AND NOW() <= active.expires_at
+ORDER BY active.created_at DESC
+`
+
+type ListActiveSessionsRow struct {
+	FamilyID   uuid.UUID
+	StartedAt  time.Time
+	LastUsedAt sql.NullTime
+	ExpiresAt  sql.NullTime
+	UserAgent  sql.NullString
+	IpAddress  sql.NullString
+}
+
+// A session is a token family. Only its newest token is active, so that
+// token's created_at is when the session was last used.
+func (q *Queries) ListActiveSessions(ctx context.Context, userID uuid.NullUUID) ([]ListActiveSessionsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listActiveSessions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListActiveSessionsRow
+	for rows.Next() {
+		var i ListActiveSessionsRow
+		if err := rows.Scan(
+			&i.FamilyID,
+			&i.StartedAt,
+			&i.LastUsedAt,
+			&i.ExpiresAt,
+			&i.UserAgent,
+			&i.IpAddress,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserRefreshTokens = `-- name: ListUserRefreshTokens :many
+SELECT created_at, updated_at, user_id, expires_at, revoked_at, family_id, user_agent, ip_address, id, token_hash FROM refresh_tokens
+WHERE user_id = $1
+ORDER BY created_at ASC
+`
+
+// Every token the user was ever issued, revoked and expired ones included.
+func (q *Queries) ListUserRefreshTokens(ctx context.Context, userID uuid.NullUUID) ([]RefreshToken, error) {
+	rows, err := q.db.QueryContext(ctx, listUserRefreshTokens, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RefreshToken
+	for rows.Next() {
+		var i RefreshToken
+		if err := rows.Scan(
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+			&i.FamilyID,
+			&i.UserAgent,
+			&i.IpAddress,
+			&i.ID,
+			&i.TokenHash,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const revokeAllUserRefreshTokens = `-- name: RevokeAllUserRefreshTokens :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeAllUserRefreshTokens(ctx context.Context, userID uuid.NullUUID) error {
+	_, err := q.db.ExecContext(ctx, revokeAllUserRefreshTokens, userID)
+	return err
+}
+
+const revokeOtherUserSessions = `-- name: RevokeOtherUserSessions :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL
+`
+
+type RevokeOtherUserSessionsParams struct {
+	UserID   uuid.NullUUID
+	FamilyID uuid.UUID
+}
+
+func (q *Queries) RevokeOtherUserSessions(ctx context.Context, arg RevokeOtherUserSessionsParams) error {
+	_, err := q.db.ExecContext(ctx, revokeOtherUserSessions, arg.UserID, arg.FamilyID)
+	return err
+}
+
 const revokeRefreshToken = `-- name: RevokeRefreshToken :exec
 UPDATE refresh_tokens
 SET revoked_at = NOW()
-WHERE token = $1
+WHERE id = $1
 `
 
-func (q *Queries) RevokeRefreshToken(ctx context.Context, token string) error {
-	_, err := q.db.ExecContext(ctx, revokeRefreshToken, token)
+func (q *Queries) RevokeRefreshToken(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeRefreshToken, id)
 	return err
 }
+
+const revokeRefreshTokenFamily = `-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeRefreshTokenFamily, familyID)
+	return err
+}
+
+const revokeUserSession = `-- name: RevokeUserSession :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND family_id = $2 AND revoked_at IS NULL
+`
+
+type RevokeUserSessionParams struct {
+	UserID   uuid.NullUUID
+	FamilyID uuid.UUID
+}
+
+func (q *Queries) RevokeUserSession(ctx context.Context, arg RevokeUserSessionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeUserSession, arg.UserID, arg.FamilyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const rotateRefreshToken = `-- name: RotateRefreshToken :one
+WITH rotated AS (
+  UPDATE refresh_tokens
+  SET revoked_at = NOW(), updated_at = NOW()
+  WHERE id = $1 AND revoked_at IS NULL AND NOW() <= expires_at
+  RETURNING user_id, family_id
+)
+INSERT INTO refresh_tokens(id, token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, user_agent, ip_address)
+SELECT $2, $3, NOW(), NOW(), rotated.user_id, $4, NULL, rotated.family_id, $5, $6
+FROM rotated
+RETURNING created_at, updated_at, user_id, expires_at, revoked_at, family_id, user_agent, ip_address, id, token_hash
+`
+
+type RotateRefreshTokenParams struct {
+	ID           uuid.UUID
+	NewID        uuid.UUID
+	NewTokenHash string
+	ExpiresAt    sql.NullTime
+	UserAgent    sql.NullString
+	IpAddress    sql.NullString
+}
+
+// Revokes the token, if it is still usable, and inserts its successor in the
+// same family in one statement, returning the successor. Two requests racing
+// with the same token cannot both succeed, and a failure leaves the session
+// with either the old token or the new one.
+func (q *Queries) RotateRefreshToken(ctx context.Context, arg RotateRefreshTokenParams) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, rotateRefreshToken,
+		arg.ID,
+		arg.NewID,
+		arg.NewTokenHash,
+		arg.ExpiresAt,
+		arg.UserAgent,
+		arg.IpAddress,
+	)
+	var i RefreshToken
+	err := row.Scan(
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.FamilyID,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.ID,
+		&i.TokenHash,
+	)
+	return i, err
+}
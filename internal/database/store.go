@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Store is the set of queries the server depends on. *Queries satisfies it
+// against Postgres and *MemoryStore satisfies it in-process.
+type Store interface {
+	ChirpHasReplies(ctx context.Context, inReplyTo uuid.NullUUID) (bool, error)
+	CountAuthorChirps(ctx context.Context, userID uuid.NullUUID) (int64, error)
+	CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error)
+	DeleteChirp(ctx context.Context, id uuid.UUID) error
+	DeleteChirpAndTombstones(ctx context.Context, id uuid.UUID) error
+	GetAllChirps(ctx context.Context) ([]Chirp, error)
+	GetAuthorChirps(ctx context.Context, userID uuid.NullUUID) ([]Chirp, error)
+	GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error)
+	GetChirpAncestors(ctx context.Context, id uuid.UUID) ([]Chirp, error)
+	GetReplyCounts(ctx context.Context, chirpIds []uuid.UUID) ([]GetReplyCountsRow, error)
+	ListChirpDescendants(ctx context.Context, arg ListChirpDescendantsParams) ([]Chirp, error)
+	ListChirpsAsc(ctx context.Context, arg ListChirpsAscParams) ([]Chirp, error)
+	ListChirpsDesc(ctx context.Context, arg ListChirpsDescParams) ([]Chirp, error)
+	TombstoneChirp(ctx context.Context, id uuid.UUID) error
+
+	EditChirp(ctx context.Context, arg EditChirpParams) (Chirp, error)
+	ListChirpRevisions(ctx context.Context, chirpID uuid.UUID) ([]ChirpRevision, error)
+
+	FollowUser(ctx context.Context, arg FollowUserParams) error
+	ListFollowers(ctx context.Context, arg ListFollowersParams) ([]Follow, error)
+	ListFollowing(ctx context.Context, arg ListFollowingParams) ([]Follow, error)
+	ListTimeline(ctx context.Context, arg ListTimelineParams) ([]Chirp, error)
+	UnfollowUser(ctx context.Context, arg UnfollowUserParams) error
+
+	GetLikeCounts(ctx context.Context, chirpIds []uuid.UUID) ([]GetLikeCountsRow, error)
+	GetLikedChirpIDs(ctx context.Context, arg GetLikedChirpIDsParams) ([]uuid.UUID, error)
+	LikeChirp(ctx context.Context, arg LikeChirpParams) error
+	ListUserLikedChirps(ctx context.Context, arg ListUserLikedChirpsParams) ([]ListUserLikedChirpsRow, error)
+	UnlikeChirp(ctx context.Context, arg UnlikeChirpParams) error
+
+	FlagChirp(ctx context.Context, arg FlagChirpParams) error
+	ListBannedWords(ctx context.Context) ([]string, error)
+
+	ClearLoginAttempts(ctx context.Context, key string) error
+	GetLoginAttempts(ctx context.Context, key string) (LoginAttempt, error)
+	RecordLoginFailure(ctx context.Context, arg RecordLoginFailureParams) (LoginAttempt, error)
+	ReleaseLoginFailure(ctx context.Context, key string) error
+
+	ConsumePasswordResetToken(ctx context.Context, tokenHash string) (PasswordResetToken, error)
+	CreatePasswordResetToken(ctx context.Context, arg CreatePasswordResetTokenParams) (PasswordResetToken, error)
+	DeletePasswordResetTokens(ctx context.Context, userID uuid.UUID) error
+	GetPasswordResetToken(ctx context.Context, tokenHash string) (PasswordResetToken, error)
+
+	CreateRecoveryCode(ctx context.Context, arg CreateRecoveryCodeParams) error
+	DeleteRecoveryCodes(ctx context.Context, userID uuid.UUID) error
+	UseRecoveryCode(ctx context.Context, arg UseRecoveryCodeParams) (int64, error)
+
+	CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error)
+	GetRefreshToken(ctx context.Context, id uuid.UUID) (RefreshToken, error)
+	GetUserFromRefreshToken(ctx context.Context, id uuid.UUID) (RefreshToken, error)
+	ListActiveSessions(ctx context.Context, userID uuid.NullUUID) ([]ListActiveSessionsRow, error)
+	ListUserRefreshTokens(ctx context.Context, userID uuid.NullUUID) ([]RefreshToken, error)
+	RevokeAllUserRefreshTokens(ctx context.Context, userID uuid.NullUUID) error
+	RevokeOtherUserSessions(ctx context.Context, arg RevokeOtherUserSessionsParams) error
+	RevokeRefreshToken(ctx context.Context, id uuid.UUID) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error
+	RevokeUserSession(ctx context.Context, arg RevokeUserSessionParams) (int64, error)
+	RotateRefreshToken(ctx context.Context, arg RotateRefreshTokenParams) (RefreshToken, error)
+
+	DeleteTOTPCredential(ctx context.Context, userID uuid.UUID) error
+	EnableTOTPCredential(ctx context.Context, arg EnableTOTPCredentialParams) (int64, error)
+	GetTOTPCredential(ctx context.Context, userID uuid.UUID) (TotpCredential, error)
+	StartTOTPEnrollment(ctx context.Context, arg StartTOTPEnrollmentParams) (TotpCredential, error)
+	UseTOTPStep(ctx context.Context, arg UseTOTPStepParams) (int64, error)
+
+	CancelUserDeletion(ctx context.Context, id uuid.UUID) (int64, error)
+	ConfirmEmailChange(ctx context.Context, arg ConfirmEmailChangeParams) (int64, error)
+	CreateUser(ctx context.Context, arg CreateUserParams) (User, error)
+	DeleteAllUsers(ctx context.Context) error
+	GetUser(ctx context.Context, email sql.NullString) (User, error)
+	GetUserByHandle(ctx context.Context, handle string) (User, error)
+	GetUserByID(ctx context.Context, id uuid.UUID) (User, error)
+	GetUsersByIDs(ctx context.Context, ids []uuid.UUID) ([]User, error)
+	ListUsersDueForDeletion(ctx context.Context, deleteAfter sql.NullTime) ([]uuid.UUID, error)
+	PurgeUser(ctx context.Context, arg PurgeUserParams) (int64, error)
+	PurgeUserKeepChirps(ctx context.Context, arg PurgeUserKeepChirpsParams) (int64, error)
+	RehashUserPassword(ctx context.Context, arg RehashUserPasswordParams) (int64, error)
+	RequestEmailChange(ctx context.Context, arg RequestEmailChangeParams) (User, error)
+	ScheduleUserDeletion(ctx context.Context, arg ScheduleUserDeletionParams) error
+	SetUserPassword(ctx context.Context, arg SetUserPasswordParams) error
+	UpdateUserProfile(ctx context.Context, arg UpdateUserProfileParams) (User, error)
+	UpgradeToChirpyRed(ctx context.Context, id uuid.UUID) error
+	VerifyUserEmail(ctx context.Context, arg VerifyUserEmailParams) (int64, error)
+}
+
+var (
+	_ Store = (*Queries)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+// IsUniqueViolation reports whether err comes from a unique constraint, in
+// Postgres or in a MemoryStore.
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	return errors.Is(err, errUniqueViolation)
+}
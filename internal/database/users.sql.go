@@ -10,23 +10,60 @@ import (
 	"database/sql"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+const cancelUserDeletion = `-- name: CancelUserDeletion :execrows
+UPDATE users
+SET delete_after = NULL, updated_at = NOW()
+WHERE id = $1 AND delete_after IS NOT NULL
+`
+
+func (q *Queries) CancelUserDeletion(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, cancelUserDeletion, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const confirmEmailChange = `-- name: ConfirmEmailChange :execrows
+UPDATE users
+SET email = pending_email, pending_email = NULL, email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND pending_email = $2
+`
+
+type ConfirmEmailChangeParams struct {
+	ID           uuid.UUID
+	PendingEmail sql.NullString
+}
+
+// Only swaps in the address the token was issued for, so a link to an
+// address that has since been replaced by another request does nothing.
+func (q *Queries) ConfirmEmailChange(ctx context.Context, arg ConfirmEmailChangeParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, confirmEmailChange, arg.ID, arg.PendingEmail)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, handle)
 VALUES (
-    gen_random_uuid(), NOW(), NOW(), $1, $2
+    gen_random_uuid(), NOW(), NOW(), $1, $2, $3
 )
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, delete_after, handle, display_name, bio, avatar_url, pending_email
 `
 
 type CreateUserParams struct {
 	Email          sql.NullString
 	HashedPassword string
+	Handle         sql.NullString
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.HashedPassword)
+	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.HashedPassword, arg.Handle)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -35,6 +72,13 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.DeleteAfter,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.PendingEmail,
 	)
 	return i, err
 }
@@ -49,11 +93,12 @@ func (q *Queries) DeleteAllUsers(ctx context.Context) error {
 }
 
 const getUser = `-- name: GetUser :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, delete_after, handle, display_name, bio, avatar_url, pending_email
 FROM users
-WHERE email = $1 LIMIT 1
+WHERE LOWER(email) = LOWER($1) LIMIT 1
 `
 
+// Addresses are compared case-insensitively, the way they are kept unique.
 func (q *Queries) GetUser(ctx context.Context, email sql.NullString) (User, error) {
 	row := q.db.QueryRowContext(ctx, getUser, email)
 	var i User
@@ -64,26 +109,318 @@ func (q *Queries) GetUser(ctx context.Context, email sql.NullString) (User, erro
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.DeleteAfter,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.PendingEmail,
+	)
+	return i, err
+}
+
+const getUserByHandle = `-- name: GetUserByHandle :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, delete_after, handle, display_name, bio, avatar_url, pending_email FROM users
+WHERE LOWER(handle) = LOWER($1::text)
+`
+
+func (q *Queries) GetUserByHandle(ctx context.Context, handle string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByHandle, handle)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.DeleteAfter,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.PendingEmail,
+	)
+	return i, err
+}
+
+const getUserByID = `-- name: GetUserByID :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, delete_after, handle, display_name, bio, avatar_url, pending_email FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.DeleteAfter,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.PendingEmail,
+	)
+	return i, err
+}
+
+const getUsersByIDs = `-- name: GetUsersByIDs :many
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, delete_after, handle, display_name, bio, avatar_url, pending_email FROM users
+WHERE id = ANY($1::uuid[])
+`
+
+func (q *Queries) GetUsersByIDs(ctx context.Context, ids []uuid.UUID) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, getUsersByIDs, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Email,
+			&i.HashedPassword,
+			&i.IsChirpyRed,
+			&i.EmailVerifiedAt,
+			&i.DeleteAfter,
+			&i.Handle,
+			&i.DisplayName,
+			&i.Bio,
+			&i.AvatarUrl,
+			&i.PendingEmail,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsersDueForDeletion = `-- name: ListUsersDueForDeletion :many
+SELECT id FROM users
+WHERE delete_after <= $1
+ORDER BY delete_after
+`
+
+func (q *Queries) ListUsersDueForDeletion(ctx context.Context, deleteAfter sql.NullTime) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, listUsersDueForDeletion, deleteAfter)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const purgeUser = `-- name: PurgeUser :execrows
+DELETE FROM users
+WHERE id = $1 AND delete_after <= $2
+`
+
+type PurgeUserParams struct {
+	ID    uuid.UUID
+	DueBy sql.NullTime
+}
+
+// Everything the user owns goes with them through ON DELETE CASCADE. The
+// deadline is checked again in case a login cancelled the deletion.
+func (q *Queries) PurgeUser(ctx context.Context, arg PurgeUserParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeUser, arg.ID, arg.DueBy)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const purgeUserKeepChirps = `-- name: PurgeUserKeepChirps :execrows
+WITH detached AS (
+  UPDATE chirps
+  SET user_id = NULL
+  WHERE chirps.user_id = $1 AND EXISTS (
+    SELECT 1 FROM users WHERE users.id = $1 AND users.delete_after <= $2
+  )
+)
+DELETE FROM users
+WHERE id = $1 AND delete_after <= $2
+`
+
+type PurgeUserKeepChirpsParams struct {
+	ID    uuid.UUID
+	DueBy sql.NullTime
+}
+
+// Like PurgeUser, but the user's chirps stay, without an author. Both
+// statements see the same snapshot, so they agree on whether the user is
+// due.
+func (q *Queries) PurgeUserKeepChirps(ctx context.Context, arg PurgeUserKeepChirpsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeUserKeepChirps, arg.ID, arg.DueBy)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const rehashUserPassword = `-- name: RehashUserPassword :execrows
+UPDATE users
+SET hashed_password = $2, updated_at = NOW()
+WHERE id = $1 AND hashed_password = $3
+`
+
+type RehashUserPasswordParams struct {
+	ID                uuid.UUID
+	HashedPassword    string
+	OldHashedPassword string
+}
+
+// Replaces the hash only if it is still old_hashed_password, so a rehash
+// racing a password change cannot undo it.
+func (q *Queries) RehashUserPassword(ctx context.Context, arg RehashUserPasswordParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, rehashUserPassword, arg.ID, arg.HashedPassword, arg.OldHashedPassword)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const requestEmailChange = `-- name: RequestEmailChange :one
+UPDATE users
+SET pending_email = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, delete_after, handle, display_name, bio, avatar_url, pending_email
+`
+
+type RequestEmailChangeParams struct {
+	ID           uuid.UUID
+	PendingEmail sql.NullString
+}
+
+// The new address only replaces the old one once ConfirmEmailChange runs.
+func (q *Queries) RequestEmailChange(ctx context.Context, arg RequestEmailChangeParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, requestEmailChange, arg.ID, arg.PendingEmail)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.DeleteAfter,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.PendingEmail,
 	)
 	return i, err
 }
 
-const updateUserPassword = `-- name: UpdateUserPassword :exec
+const scheduleUserDeletion = `-- name: ScheduleUserDeletion :exec
 UPDATE users
-SET email = $1, hashed_password = $2, updated_at = NOW()
-WHERE email = $1
+SET delete_after = $2, updated_at = NOW()
+WHERE id = $1
 `
 
-type UpdateUserPasswordParams struct {
-	Email          sql.NullString
+type ScheduleUserDeletionParams struct {
+	ID          uuid.UUID
+	DeleteAfter sql.NullTime
+}
+
+func (q *Queries) ScheduleUserDeletion(ctx context.Context, arg ScheduleUserDeletionParams) error {
+	_, err := q.db.ExecContext(ctx, scheduleUserDeletion, arg.ID, arg.DeleteAfter)
+	return err
+}
+
+const setUserPassword = `-- name: SetUserPassword :exec
+UPDATE users
+SET hashed_password = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type SetUserPasswordParams struct {
+	ID             uuid.UUID
 	HashedPassword string
 }
 
-func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
-	_, err := q.db.ExecContext(ctx, updateUserPassword, arg.Email, arg.HashedPassword)
+func (q *Queries) SetUserPassword(ctx context.Context, arg SetUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, setUserPassword, arg.ID, arg.HashedPassword)
 	return err
 }
 
+const updateUserProfile = `-- name: UpdateUserProfile :one
+UPDATE users
+SET handle = $2, display_name = $3, bio = $4, avatar_url = $5, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, delete_after, handle, display_name, bio, avatar_url, pending_email
+`
+
+type UpdateUserProfileParams struct {
+	ID          uuid.UUID
+	Handle      sql.NullString
+	DisplayName string
+	Bio         string
+	AvatarUrl   string
+}
+
+func (q *Queries) UpdateUserProfile(ctx context.Context, arg UpdateUserProfileParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserProfile,
+		arg.ID,
+		arg.Handle,
+		arg.DisplayName,
+		arg.Bio,
+		arg.AvatarUrl,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.DeleteAfter,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.PendingEmail,
+	)
+	return i, err
+}
+
 const upgradeToChirpyRed = `-- name: UpgradeToChirpyRed :exec
 UPDATE users
 SET is_chirpy_red = TRUE
@@ -94,3 +431,24 @@ func (q *Queries) UpgradeToChirpyRed(ctx context.Context, id uuid.UUID) error {
 	_, err := q.db.ExecContext(ctx, upgradeToChirpyRed, id)
 	return err
 }
+
+const verifyUserEmail = `-- name: VerifyUserEmail :execrows
+UPDATE users
+SET email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+WHERE id = $1 AND email = $2
+`
+
+type VerifyUserEmailParams struct {
+	ID    uuid.UUID
+	Email sql.NullString
+}
+
+// Only verifies the address the token was issued for, so a token sent before
+// an email change is useless afterwards.
+func (q *Queries) VerifyUserEmail(ctx context.Context, arg VerifyUserEmailParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, verifyUserEmail, arg.ID, arg.Email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
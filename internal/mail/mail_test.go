@@ -0,0 +1,68 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jcuello/chirpy/internal/mail/mailtest"
+)
+
+func TestSMTPMailer(t *testing.T) {
+	server := mailtest.NewServer()
+	defer server.Close()
+
+	m := &SMTPMailer{Addr: server.Addr, From: "chirpy@example.com"}
+	err := m.Send(context.Background(), Message{
+		To:      "walt@example.com",
+		Subject: "Welcome to Chirpy ✓",
+		Body:    "Line one\n.leading dot\nLine three",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := server.Messages()
+	if len(got) != 1 {
+		t.Fatalf("server received %d messages, want 1", len(got))
+	}
+	msg := got[0]
+	if msg.From != "chirpy@example.com" || len(msg.To) != 1 || msg.To[0] != "walt@example.com" {
+		t.Errorf("envelope = %v -> %v", msg.From, msg.To)
+	}
+	if msg.Subject != "Welcome to Chirpy ✓" {
+		t.Errorf("Subject = %q", msg.Subject)
+	}
+	// SMTP always ends the data with a line break.
+	if msg.Body != "Line one\n.leading dot\nLine three\n" {
+		t.Errorf("Body = %q", msg.Body)
+	}
+}
+
+func TestRejectsHeaderInjection(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewLogMailer(&buf, "chirpy@example.com")
+
+	err := m.Send(context.Background(), Message{To: "a@example.com\r\nBcc: everyone@example.com", Subject: "hi"})
+	if err == nil {
+		t.Fatal("Send() accepted a recipient with a line break")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("LogMailer wrote %q", buf.String())
+	}
+}
+
+func TestLogMailer(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewLogMailer(&buf, "chirpy@example.com")
+
+	if err := m.Send(context.Background(), Message{To: "a@example.com", Subject: "hi", Body: "token: abc"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"To: a@example.com\r\n", "Subject: hi\r\n", "\r\n\r\ntoken: abc"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,332 @@
+// Package migrate applies goose-annotated SQL migrations. It records applied
+// versions in goose's own goose_db_version table, so databases that were
+// migrated with the goose CLI are picked up where they left off.
+package migrate
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const versionTable = "goose_db_version"
+
+// lockID names the Postgres advisory lock held while migrating, so instances
+// started together apply each migration once. The value is arbitrary.
+const lockID int64 = 7310582047216693817
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Load reads every *.sql file at the root of fsys. File names must start with
+// a numeric version followed by an underscore, e.g. 4_refresh_tokens.sql.
+func Load(fsys fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := []Migration{}
+	seen := map[int64]string{}
+	for _, name := range names {
+		versionStr, _, found := strings.Cut(name, "_")
+		if !found {
+			return nil, fmt.Errorf("migration %s: name must start with <version>_", name)
+		}
+		version, err := strconv.ParseInt(versionStr, 10, 64)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s: invalid version %q", name, versionStr)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, name, version)
+		}
+		seen[version] = name
+
+		contents, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		up, down, err := Parse(string(contents))
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", name, err)
+		}
+
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    strings.TrimSuffix(path.Base(name), ".sql"),
+			Up:      up,
+			Down:    down,
+		})
+	}
+
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return migrations, nil
+}
+
+// Parse splits a migration into its `-- +goose Up` and `-- +goose Down`
+// sections. StatementBegin/StatementEnd markers are accepted and dropped
+// since each section is executed as a single batch.
+func Parse(contents string) (up, down string, err error) {
+	var upLines, downLines []string
+	var current *[]string
+
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if annotation, ok := strings.CutPrefix(trimmed, "-- +goose "); ok {
+			switch strings.TrimSpace(annotation) {
+			case "Up":
+				if upLines != nil {
+					return "", "", errors.New("duplicate +goose Up")
+				}
+				upLines = []string{}
+				current = &upLines
+			case "Down":
+				if downLines != nil {
+					return "", "", errors.New("duplicate +goose Down")
+				}
+				downLines = []string{}
+				current = &downLines
+			case "StatementBegin", "StatementEnd":
+			default:
+				return "", "", fmt.Errorf("unsupported annotation %q", trimmed)
+			}
+			continue
+		}
+
+		if current == nil {
+			if trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+				return "", "", errors.New("statement before +goose Up")
+			}
+			continue
+		}
+		*current = append(*current, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+
+	if upLines == nil {
+		return "", "", errors.New("missing +goose Up")
+	}
+	return strings.TrimSpace(strings.Join(upLines, "\n")), strings.TrimSpace(strings.Join(downLines, "\n")), nil
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func New(db *sql.DB, migrations []Migration) *Migrator {
+	return &Migrator{db: db, migrations: migrations}
+}
+
+func (m *Migrator) versionTableExists(ctx context.Context) (bool, error) {
+	var exists bool
+	err := m.db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, versionTable).Scan(&exists)
+	return exists, err
+}
+
+func (m *Migrator) ensureVersionTable(ctx context.Context) error {
+	exists, err := m.versionTableExists(ctx)
+	if err != nil || exists {
+		return err
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `CREATE TABLE `+versionTable+` (
+  id serial PRIMARY KEY,
+  version_id bigint NOT NULL,
+  is_applied boolean NOT NULL,
+  tstamp timestamp NULL DEFAULT now()
+)`)
+	if err != nil {
+		return err
+	}
+	// goose seeds the table with version 0.
+	_, err = tx.ExecContext(ctx, `INSERT INTO `+versionTable+` (version_id, is_applied) VALUES (0, TRUE)`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// withLock runs f while holding the migration advisory lock, waiting for any
+// other migrator to finish first.
+func (m *Migrator) withLock(ctx context.Context, f func() error) error {
+	// Advisory locks belong to a session, so lock and unlock on one
+	// connection.
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, lockID)
+
+	return f()
+}
+
+// applied returns the application time of every applied version. The most
+// recent row for a version wins, matching goose. Without a version table,
+// nothing is applied; it is only created by Up.
+func (m *Migrator) applied(ctx context.Context) (map[int64]time.Time, error) {
+	exists, err := m.versionTableExists(ctx)
+	if err != nil || !exists {
+		return map[int64]time.Time{}, err
+	}
+
+	rows, err := m.db.QueryContext(ctx, `SELECT version_id, is_applied, tstamp FROM `+versionTable+` ORDER BY id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var isApplied bool
+		var tstamp sql.NullTime
+		if err := rows.Scan(&version, &isApplied, &tstamp); err != nil {
+			return nil, err
+		}
+		if isApplied {
+			applied[version] = tstamp.Time
+		} else {
+			delete(applied, version)
+		}
+	}
+	return applied, rows.Err()
+}
+
+// Status reports every migration and whether it is applied. Like Pending, it
+// only reads the database.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []Status{}
+	for _, migration := range m.migrations {
+		appliedAt, ok := applied[migration.Version]
+		statuses = append(statuses, Status{Migration: migration, Applied: ok, AppliedAt: appliedAt})
+	}
+	return statuses, nil
+}
+
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []Migration{}
+	for _, s := range statuses {
+		if !s.Applied {
+			pending = append(pending, s.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration in version order, each in its own
+// transaction, and returns the ones it applied. It holds the advisory lock
+// throughout, so a concurrent Up waits and then finds nothing pending.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	done := []Migration{}
+	err := m.withLock(ctx, func() error {
+		if err := m.ensureVersionTable(ctx); err != nil {
+			return err
+		}
+		pending, err := m.Pending(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range pending {
+			err := m.run(ctx, migration.Up, `INSERT INTO `+versionTable+` (version_id, is_applied) VALUES ($1, TRUE)`, migration.Version)
+			if err != nil {
+				return fmt.Errorf("applying %s: %w", migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down rolls back the most recently applied migration under the advisory
+// lock. It returns false when nothing is applied.
+func (m *Migrator) Down(ctx context.Context) (migration Migration, ok bool, err error) {
+	err = m.withLock(ctx, func() error {
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		for i := len(statuses) - 1; i >= 0; i-- {
+			if !statuses[i].Applied {
+				continue
+			}
+			migration = statuses[i].Migration
+			err := m.run(ctx, migration.Down, `DELETE FROM `+versionTable+` WHERE version_id = $1`, migration.Version)
+			if err != nil {
+				return fmt.Errorf("rolling back %s: %w", migration.Name, err)
+			}
+			ok = true
+			return nil
+		}
+		return nil
+	})
+	return migration, ok, err
+}
+
+func (m *Migrator) run(ctx context.Context, statements, record string, version int64) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if statements != "" {
+		if _, err := tx.ExecContext(ctx, statements); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, record, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
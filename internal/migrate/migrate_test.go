@@ -0,0 +1,85 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		wantUp   string
+		wantDown string
+		wantErr  bool
+	}{
+		{
+			name:     "Up and Down",
+			contents: "-- +goose Up\nCREATE TABLE a(id int);\n\n-- +goose Down\nDROP TABLE a;",
+			wantUp:   "CREATE TABLE a(id int);",
+			wantDown: "DROP TABLE a;",
+		},
+		{
+			name:     "Up only",
+			contents: "-- +goose Up\nCREATE TABLE a(id int);\n",
+			wantUp:   "CREATE TABLE a(id int);",
+		},
+		{
+			name:     "Statement markers",
+			contents: "-- +goose Up\n-- +goose StatementBegin\nSELECT 1;\n-- +goose StatementEnd\n-- +goose Down\nSELECT 2;",
+			wantUp:   "SELECT 1;",
+			wantDown: "SELECT 2;",
+		},
+		{
+			name:     "Missing Up",
+			contents: "-- +goose Down\nDROP TABLE a;",
+			wantErr:  true,
+		},
+		{
+			name:     "Statement before Up",
+			contents: "DROP TABLE a;\n-- +goose Up\nSELECT 1;",
+			wantErr:  true,
+		},
+		{
+			name:     "Unknown annotation",
+			contents: "-- +goose Up\n-- +goose NO TRANSACTION\nSELECT 1;",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up, down, err := Parse(tt.contents)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if up != tt.wantUp || down != tt.wantDown {
+				t.Errorf("Parse() = %q, %q, want %q, %q", up, down, tt.wantUp, tt.wantDown)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"10_chirps.sql": {Data: []byte("-- +goose Up\nSELECT 10;")},
+		"2_users.sql":   {Data: []byte("-- +goose Up\nSELECT 2;")},
+		"README.md":     {Data: []byte("not a migration")},
+	}
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].Version != 2 || migrations[1].Version != 10 {
+		t.Fatalf("Load() = %+v, want versions [2 10]", migrations)
+	}
+	if migrations[1].Name != "10_chirps" {
+		t.Errorf("Load() name = %q, want 10_chirps", migrations[1].Name)
+	}
+
+	fsys["2_duplicate.sql"] = &fstest.MapFile{Data: []byte("-- +goose Up\nSELECT 2;")}
+	if _, err := Load(fsys); err == nil {
+		t.Error("Load() with duplicate versions should fail")
+	}
+}
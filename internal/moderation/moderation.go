@@ -0,0 +1,324 @@
+// Package moderation screens chirp bodies against configurable word and
+// phrase lists.
+package moderation
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+type Mode string
+
+const (
+	// ModeMask replaces every match with asterisks.
+	ModeMask Mode = "mask"
+	// ModeReject refuses text that contains any match.
+	ModeReject Mode = "reject"
+	// ModeFlag keeps the text unchanged but marks it for review.
+	ModeFlag Mode = "flag"
+)
+
+const mask = "****"
+
+// DefaultWords is the list Chirpy has always filtered.
+var DefaultWords = StaticSource{"kerfuffle", "sharbert", "fornax"}
+
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case "":
+		return ModeMask, nil
+	case ModeMask, ModeReject, ModeFlag:
+		return Mode(s), nil
+	}
+	return "", fmt.Errorf("unknown moderation mode %q", s)
+}
+
+// A Source supplies the entries to filter. Entries with whitespace are
+// matched as phrases, word by word.
+type Source interface {
+	Load(ctx context.Context) ([]string, error)
+}
+
+type StaticSource []string
+
+func (s StaticSource) Load(ctx context.Context) ([]string, error) {
+	return s, nil
+}
+
+// FileSource reads one entry per line. Blank lines and lines starting with #
+// are skipped.
+type FileSource string
+
+func (path FileSource) Load(ctx context.Context) ([]string, error) {
+	f, err := os.Open(string(path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	return entries, scanner.Err()
+}
+
+// SourceFunc adapts a function, such as a database query, to a Source.
+type SourceFunc func(ctx context.Context) ([]string, error)
+
+func (f SourceFunc) Load(ctx context.Context) ([]string, error) {
+	return f(ctx)
+}
+
+// Decision is the outcome of running text through a Filter.
+type Decision struct {
+	// Text is what should be stored: masked in ModeMask, unchanged otherwise.
+	Text     string
+	Matches  []string
+	Rejected bool
+	Flagged  bool
+}
+
+// Filter is safe for concurrent use. Reload swaps the lists atomically, so
+// in-flight checks see either the old lists or the new ones.
+type Filter struct {
+	mode   Mode
+	source Source
+
+	mu      sync.RWMutex
+	entries [][]string
+}
+
+// New builds a Filter and performs the initial load from source.
+func New(ctx context.Context, mode Mode, source Source) (*Filter, error) {
+	f := &Filter{mode: mode, source: source}
+	if err := f.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// NewDefault masks DefaultWords.
+func NewDefault() *Filter {
+	f := &Filter{mode: ModeMask, source: DefaultWords}
+	// A StaticSource cannot fail to load.
+	f.Reload(context.Background())
+	return f
+}
+
+func (f *Filter) Mode() Mode {
+	return f.mode
+}
+
+// Reload re-reads the source. On failure the previous lists stay active.
+func (f *Filter) Reload(ctx context.Context) error {
+	raw, err := f.source.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	entries := [][]string{}
+	for _, entry := range raw {
+		words := strings.Fields(strings.ToLower(entry))
+		if len(words) > 0 {
+			entries = append(entries, words)
+		}
+	}
+
+	f.mu.Lock()
+	f.entries = entries
+	f.mu.Unlock()
+	return nil
+}
+
+// Apply checks text and decides what to do with it according to the mode.
+func (f *Filter) Apply(text string) Decision {
+	f.mu.RLock()
+	entries := f.entries
+	f.mu.RUnlock()
+
+	tokens := tokenize(text)
+	spans := []span{}
+	matches := []string{}
+	for i := range tokens {
+		for _, entry := range entries {
+			matched, ok := matchAt(tokens, i, entry)
+			if ok {
+				spans = append(spans, matched...)
+				matches = append(matches, strings.Join(entry, " "))
+			}
+		}
+	}
+
+	decision := Decision{Text: text, Matches: matches}
+	if len(matches) == 0 {
+		return decision
+	}
+
+	switch f.mode {
+	case ModeReject:
+		decision.Rejected = true
+	case ModeFlag:
+		decision.Flagged = true
+	default:
+		decision.Text = applyMask(text, spans)
+	}
+	return decision
+}
+
+// span is a byte range of the input.
+type span struct {
+	start, end int
+}
+
+// token is a run of word runes. core is the same run with leading and
+// trailing leetspeak symbols trimmed, so "kerfuffle!" still has the core
+// "kerfuffle" while "$harbert" is matched as a whole.
+type token struct {
+	full span
+	core span
+	text string
+}
+
+// leet lists the letters each substitute can stand for.
+var leet = map[rune]string{
+	'0': "o",
+	'1': "il",
+	'3': "e",
+	'4': "a",
+	'5': "s",
+	'7': "t",
+	'8': "b",
+	'9': "g",
+	'@': "a",
+	'$': "s",
+	'!': "i",
+	'|': "il",
+	'+': "t",
+}
+
+func isLeetSymbol(r rune) bool {
+	_, ok := leet[r]
+	return ok && !unicode.IsDigit(r)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || isLeetSymbol(r)
+}
+
+func tokenize(text string) []token {
+	tokens := []token{}
+	start := -1
+	for i, r := range text {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, newToken(text, start, i))
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, newToken(text, start, len(text)))
+	}
+	return tokens
+}
+
+func newToken(text string, start, end int) token {
+	core := span{start, end}
+	for core.start < core.end {
+		r, size := utf8.DecodeRuneInString(text[core.start:])
+		if !isLeetSymbol(r) {
+			break
+		}
+		core.start += size
+	}
+	for core.end > core.start {
+		r, size := utf8.DecodeLastRuneInString(text[:core.end])
+		if !isLeetSymbol(r) {
+			break
+		}
+		core.end -= size
+	}
+	return token{full: span{start, end}, core: core, text: text}
+}
+
+// matchAt reports whether entry matches the tokens starting at i, returning
+// the spans to mask.
+func matchAt(tokens []token, i int, entry []string) ([]span, bool) {
+	if i+len(entry) > len(tokens) {
+		return nil, false
+	}
+
+	spans := make([]span, 0, len(entry))
+	for j, word := range entry {
+		t := tokens[i+j]
+		switch {
+		case matchWord(t.text[t.full.start:t.full.end], word):
+			spans = append(spans, t.full)
+		case t.core != t.full && t.core.start < t.core.end && matchWord(t.text[t.core.start:t.core.end], word):
+			spans = append(spans, t.core)
+		default:
+			return nil, false
+		}
+	}
+	return spans, true
+}
+
+func matchWord(candidate, word string) bool {
+	if utf8.RuneCountInString(candidate) != utf8.RuneCountInString(word) {
+		return false
+	}
+
+	wordRunes := []rune(word)
+	i := 0
+	for _, r := range candidate {
+		w := wordRunes[i]
+		i++
+		if unicode.ToLower(r) == w {
+			continue
+		}
+		if subs, ok := leet[r]; ok && strings.ContainsRune(subs, w) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+func applyMask(text string, spans []span) string {
+	masked := make([]bool, len(text))
+	for _, s := range spans {
+		for i := s.start; i < s.end; i++ {
+			masked[i] = true
+		}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		if !masked[i] {
+			b.WriteByte(text[i])
+			i++
+			continue
+		}
+		b.WriteString(mask)
+		for i < len(text) && masked[i] {
+			i++
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,102 @@
+package moderation
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newFilter(t *testing.T, mode Mode, entries ...string) *Filter {
+	t.Helper()
+	f, err := New(context.Background(), mode, StaticSource(entries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestApplyMask(t *testing.T) {
+	f := newFilter(t, ModeMask, "kerfuffle", "sharbert", "fornax", "bad apple")
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "Clean", text: "I had something interesting for breakfast", want: "I had something interesting for breakfast"},
+		{name: "Word", text: "I hear Mastodon is better than Chirpy. sharbert I need to migrate", want: "I hear Mastodon is better than Chirpy. **** I need to migrate"},
+		{name: "Case insensitive", text: "This is a KerFuffle opinion", want: "This is a **** opinion"},
+		{name: "Trailing punctuation", text: "What a kerfuffle!", want: "What a ****!"},
+		{name: "Quoted", text: `"Fornax," she said`, want: `"****," she said`},
+		{name: "Leetspeak", text: "k3rfuffl3 and $h@rb3rt", want: "**** and ****"},
+		{name: "Word boundary", text: "kerfuffles are not kerfuffle", want: "kerfuffles are not ****"},
+		{name: "Unicode boundary", text: "über-fornax—fornaxé", want: "über-****—fornaxé"},
+		{name: "Phrase", text: "one Bad, apple spoils", want: "one ****, **** spoils"},
+		{name: "Partial phrase", text: "a bad day", want: "a bad day"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := f.Apply(tt.text)
+			if got.Text != tt.want {
+				t.Errorf("Apply(%q).Text = %q, want %q", tt.text, got.Text, tt.want)
+			}
+			if got.Rejected || got.Flagged {
+				t.Errorf("Apply(%q) rejected or flagged in mask mode", tt.text)
+			}
+		})
+	}
+}
+
+func TestApplyModes(t *testing.T) {
+	text := "what a kerfuffle"
+
+	reject := newFilter(t, ModeReject, "kerfuffle").Apply(text)
+	if !reject.Rejected || reject.Text != text || len(reject.Matches) != 1 {
+		t.Errorf("reject mode = %+v", reject)
+	}
+
+	flag := newFilter(t, ModeFlag, "kerfuffle").Apply(text)
+	if !flag.Flagged || flag.Rejected || flag.Text != text {
+		t.Errorf("flag mode = %+v", flag)
+	}
+
+	clean := newFilter(t, ModeReject, "kerfuffle").Apply("all good")
+	if clean.Rejected || len(clean.Matches) != 0 {
+		t.Errorf("clean text = %+v", clean)
+	}
+}
+
+func TestReloadFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(path, []byte("# banned\nkerfuffle\n\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := New(context.Background(), ModeMask, FileSource(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Apply("sharbert kerfuffle").Text; got != "sharbert ****" {
+		t.Fatalf("before reload = %q", got)
+	}
+
+	if err := os.WriteFile(path, []byte("sharbert\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Apply("sharbert kerfuffle").Text; got != "**** kerfuffle" {
+		t.Errorf("after reload = %q", got)
+	}
+
+	os.Remove(path)
+	if err := f.Reload(context.Background()); err == nil {
+		t.Fatal("Reload() of a missing file should fail")
+	}
+	if got := f.Apply("sharbert").Text; got != "****" {
+		t.Errorf("failed reload should keep old lists, got %q", got)
+	}
+}
@@ -0,0 +1,59 @@
+package password
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BreachList knows passwords that have leaked. Implementations must be safe
+// for concurrent use.
+type BreachList interface {
+	Contains(ctx context.Context, password string) (bool, error)
+}
+
+// RangeDir is a directory of SHA-1 range files laid out like the Pwned
+// Passwords API: one file per five hex digit prefix, named like 21BD1 or
+// 21BD1.txt, whose lines are the other 35 digits, a colon and a count. Only
+// the prefix's file is read, so the full corpus can stay on disk.
+type RangeDir string
+
+func (dir RangeDir) Contains(ctx context.Context, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := digest[:5], digest[5:]
+
+	f, err := os.Open(filepath.Join(string(dir), prefix))
+	if errors.Is(err, fs.ErrNotExist) {
+		f, err = os.Open(filepath.Join(string(dir), prefix+".txt"))
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		hash, count, hasCount := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !strings.EqualFold(hash, suffix) {
+			continue
+		}
+		if !hasCount {
+			return true, nil
+		}
+		// Padded responses list made-up suffixes with a count of zero.
+		n, err := strconv.Atoi(strings.TrimSpace(count))
+		return err != nil || n > 0, nil
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,153 @@
+package password
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// leet undoes the substitutions people make to dress up a word.
+var leet = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't',
+	'@': 'a', '$': 's', '!': 'i',
+}
+
+// userInputBits is what guessing a word taken from the user's own details
+// costs: there are only a handful of them.
+const userInputBits = 2
+
+// Entropy estimates, in bits, how hard password is to guess. Characters
+// start at the cost of a guess from their character classes. Repeats and
+// runs like "abc" or "321" cost one bit each, and a common word or a part of
+// one of userInputs costs about as much as picking it from its list, plus a
+// bit each for capitals and leet substitutions. The estimate is the cheapest
+// way to spell out the whole password from those pieces.
+func Entropy(password string, userInputs ...string) float64 {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return 0
+	}
+
+	lower := make([]rune, len(runes))
+	normal := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+		normal[i] = lower[i]
+		if plain, ok := leet[lower[i]]; ok {
+			normal[i] = plain
+		}
+	}
+
+	type token struct {
+		runes []rune
+		bits  float64
+	}
+	var tokens []token
+	wordBits := math.Log2(float64(len(commonWords)))
+	for _, word := range commonWords {
+		tokens = append(tokens, token{[]rune(word), wordBits})
+	}
+	for _, word := range userTokens(userInputs) {
+		tokens = append(tokens, token{[]rune(word), userInputBits})
+	}
+
+	charBits := math.Log2(float64(poolSize(runes)))
+	best := make([]float64, len(runes)+1)
+	for i := range best[1:] {
+		best[i+1] = math.Inf(1)
+	}
+	for i := range runes {
+		bits := charBits
+		if i > 0 {
+			step := lower[i] - lower[i-1]
+			if step >= -1 && step <= 1 {
+				bits = 1
+			}
+		}
+		best[i+1] = min(best[i+1], best[i]+bits)
+
+		for _, t := range tokens {
+			end := i + len(t.runes)
+			if end > len(runes) || string(normal[i:end]) != string(t.runes) {
+				continue
+			}
+			best[end] = min(best[end], best[i]+t.bits+variationBits(runes[i:end], lower[i:end]))
+		}
+	}
+	return best[len(runes)]
+}
+
+// variationBits charges for capitals and leet substitutions in a word.
+func variationBits(original, lower []rune) float64 {
+	var bits float64
+	if string(original) != string(lower) {
+		bits++
+	}
+	for _, r := range lower {
+		if _, ok := leet[r]; ok {
+			bits++
+			break
+		}
+	}
+	return bits
+}
+
+// poolSize is how many characters a guesser would try per position, given
+// the classes present.
+func poolSize(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r <= unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	size := 0
+	for _, class := range []struct {
+		present bool
+		size    int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.present {
+			size += class.size
+		}
+	}
+	return size
+}
+
+// userTokens splits inputs such as "walter.white@example.com" into the
+// pieces a guesser would try: "walter.white", "walter", "white" and
+// "example".
+func userTokens(inputs []string) []string {
+	var tokens []string
+	add := func(s string) {
+		if len([]rune(s)) >= 3 {
+			tokens = append(tokens, s)
+		}
+	}
+	for _, input := range inputs {
+		input = strings.ToLower(input)
+		local, domain, _ := strings.Cut(input, "@")
+		add(local)
+		for _, part := range strings.FieldsFunc(local, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if part != local {
+				add(part)
+			}
+		}
+		labels := strings.Split(domain, ".")
+		for _, label := range labels[:max(len(labels)-1, 0)] {
+			add(label)
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,97 @@
+// Package password decides whether a new password is good enough: long
+// enough, hard enough to guess, and not known from a breach.
+package password
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Policy is what a new password has to satisfy. Zero fields are not
+// checked, so the zero Policy accepts anything.
+type Policy struct {
+	MinLength int // in characters
+	// MaxLength bounds the work a single request can cause by hashing.
+	MaxLength int
+	// MinEntropy is the least Entropy, in bits, a password may have.
+	MinEntropy float64
+	Breached   BreachList
+}
+
+// DefaultPolicy follows NIST SP 800-63B: eight characters at least, no
+// composition rules, and no guessable or breached passwords. Set Breached
+// to enable the breach check.
+var DefaultPolicy = Policy{
+	MinLength:  8,
+	MaxLength:  256,
+	MinEntropy: 30,
+}
+
+// Violation is one reason a password was refused. Code is stable; Message is
+// meant for people.
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists everything wrong with a password at once, so a user
+// does not have to fix one problem to discover the next.
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return "password rejected: " + strings.Join(messages, "; ")
+}
+
+// Check returns a *ValidationError if password breaks the policy. userInputs
+// are strings an attacker would try first, such as the email address.
+// Other errors come from the breach list.
+func (p *Policy) Check(ctx context.Context, password string, userInputs ...string) error {
+	var violations []Violation
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, Violation{
+			Code:    "too_short",
+			Message: fmt.Sprintf("Use at least %d characters", p.MinLength),
+		})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		// Scoring or looking up an oversized password is the very work
+		// MaxLength is there to bound, so stop here.
+		return &ValidationError{Violations: []Violation{{
+			Code:    "too_long",
+			Message: fmt.Sprintf("Use at most %d characters", p.MaxLength),
+		}}}
+	}
+	if p.MinEntropy > 0 && Entropy(password, userInputs...) < p.MinEntropy {
+		violations = append(violations, Violation{
+			Code:    "too_guessable",
+			Message: "Avoid common words, repeated characters, sequences and parts of your email address",
+		})
+	}
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(ctx, password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			violations = append(violations, Violation{
+				Code:    "breached",
+				Message: "This password has appeared in a data breach",
+			})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package password
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEntropy(t *testing.T) {
+	const email = "walter.white@example.com"
+	weak := []string{
+		"", "password1", "P@ssw0rd!", "04234", "aaaaaaaaaaaa", "abcdefgh12",
+		"qwertyuiop", "sunshine2024", "walterwhite99", "Example123",
+	}
+	strong := []string{"kx8vq2mz", "Sunshine!2024", "Tr0ub4dor&3", "vfr4-bgt5-nhy6"}
+
+	for _, password := range weak {
+		if bits := Entropy(password, email); bits >= DefaultPolicy.MinEntropy {
+			t.Errorf("Entropy(%q) = %.1f, want < %v", password, bits, DefaultPolicy.MinEntropy)
+		}
+	}
+	for _, password := range strong {
+		if bits := Entropy(password, email); bits < DefaultPolicy.MinEntropy {
+			t.Errorf("Entropy(%q) = %.1f, want >= %v", password, bits, DefaultPolicy.MinEntropy)
+		}
+	}
+
+	if with, without := Entropy("heisenberg77", "heisenberg@example.com"), Entropy("heisenberg77"); with >= without {
+		t.Errorf("the email made no difference: %.1f with, %.1f without", with, without)
+	}
+}
+
+func TestUserTokens(t *testing.T) {
+	got := userTokens([]string{"Walter.White@mail.example.com"})
+	want := []string{"walter.white", "walter", "white", "mail", "example"}
+	if !slices.Equal(got, want) {
+		t.Errorf("userTokens() = %q, want %q", got, want)
+	}
+}
+
+// writeRangeFile stores the SHA-1 suffixes of passwords in dir the way the
+// Pwned Passwords API would serve them, with counts.
+func writeRangeFile(t *testing.T, dir, name string, lines ...string) {
+	t.Helper()
+	err := os.WriteFile(filepath.Join(dir, name), []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestRangeDir(t *testing.T) {
+	dir := t.TempDir()
+	leaked := sha1Hex("hunter2hunter2")
+	padded := sha1Hex("padding only")
+	lowerCase := sha1Hex("stored in lower case")
+	writeRangeFile(t, dir, leaked[:5], "0000000000000000000000000000000000A:3", leaked[5:]+":1234")
+	writeRangeFile(t, dir, padded[:5]+".txt", padded[5:]+":0")
+	writeRangeFile(t, dir, lowerCase[:5], strings.ToLower(lowerCase[5:])+":2")
+
+	tests := map[string]bool{
+		"hunter2hunter2":       true,
+		"stored in lower case": true,
+		"padding only":         false,
+		"never leaked":         false,
+	}
+	for password, want := range tests {
+		got, err := RangeDir(dir).Contains(context.Background(), password)
+		if err != nil || got != want {
+			t.Errorf("Contains(%q) = %v, %v, want %v", password, got, err, want)
+		}
+	}
+}
+
+type unreachableBreachList struct{}
+
+func (unreachableBreachList) Contains(ctx context.Context, password string) (bool, error) {
+	return false, errors.New("breach list unreachable")
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	leaked := sha1Hex("Sunshine!2024")
+	writeRangeFile(t, dir, leaked[:5], leaked[5:]+":52")
+
+	policy := DefaultPolicy
+	policy.Breached = RangeDir(dir)
+	ctx := context.Background()
+
+	if err := policy.Check(ctx, "vfr4-bgt5-nhy6", "walt@example.com"); err != nil {
+		t.Errorf("Check(strong) = %v", err)
+	}
+
+	tests := []struct {
+		password string
+		want     []string
+	}{
+		{"walt1", []string{"too_short", "too_guessable"}},
+		{"Sunshine!2024", []string{"breached"}},
+		{strings.Repeat("vfr4-bgt5-nhy6", 20), []string{"too_long"}},
+		// Nothing else is checked once a password is too long.
+		{strings.Repeat("a", 300), []string{"too_long"}},
+	}
+	for _, tt := range tests {
+		err := policy.Check(ctx, tt.password, "walt@example.com")
+		var invalid *ValidationError
+		if !errors.As(err, &invalid) {
+			t.Errorf("Check(%q) = %v, want a ValidationError", tt.password, err)
+			continue
+		}
+		var codes []string
+		for _, v := range invalid.Violations {
+			codes = append(codes, v.Code)
+		}
+		if !slices.Equal(codes, tt.want) {
+			t.Errorf("Check(%q) codes = %q, want %q", tt.password, codes, tt.want)
+		}
+	}
+
+	offline := DefaultPolicy
+	offline.Breached = unreachableBreachList{}
+	var invalid *ValidationError
+	if err := offline.Check(ctx, strings.Repeat("vfr4-bgt5-nhy6", 20)); !errors.As(err, &invalid) {
+		t.Errorf("Check(too long) = %v, want a ValidationError without a breach lookup", err)
+	}
+
+	if err := (&Policy{}).Check(ctx, ""); err != nil {
+		t.Errorf("zero Policy rejected a password: %v", err)
+	}
+}
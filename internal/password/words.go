@@ -0,0 +1,39 @@
+package password
+
+// commonWords are the passwords and password stems guessed first: the top of
+// leaked password lists, keyboard walks, and words people reach for. Entries
+// are lower case, without leet substitutions.
+var commonWords = []string{
+	"password", "passwort", "qwerty", "qwertz", "azerty", "asdf", "zxcv",
+	"qazwsx", "wsxedc", "abcd", "letmein", "welcome", "login", "admin", "root",
+	"master", "secret", "default", "changeme", "access", "trustno", "iloveyou",
+	"love", "lover", "loveme", "sunshine", "princess", "dragon", "monkey",
+	"shadow", "football", "baseball", "soccer", "hockey", "basketball", "batman",
+	"superman", "spiderman", "starwars", "pokemon", "naruto", "michael",
+	"jennifer", "jordan", "hunter", "ranger", "buster", "tigger", "charlie",
+	"thomas", "robert", "daniel", "andrew", "joshua", "matthew", "ashley",
+	"jessica", "nicole", "michelle", "daniela", "maria", "george", "harley",
+	"ginger", "pepper", "cookie", "cheese", "chocolate", "summer", "winter",
+	"spring", "autumn", "flower", "purple", "orange", "yellow", "silver",
+	"golden", "freedom", "whatever", "nothing", "computer", "internet",
+	"google", "facebook", "twitter", "chirpy", "chirp", "killer", "fuckyou",
+	"fuck", "shit", "sexy", "hello", "hallo", "angel", "heaven", "jesus",
+	"blessed", "family", "forever", "friend", "friends", "happy", "smile",
+	"lucky", "magic", "mustang", "ferrari", "corvette", "mercedes", "yankees",
+	"liverpool", "chelsea", "arsenal", "barcelona", "madrid", "london", "paris",
+	"berlin", "america", "canada", "mexico", "china", "india", "monday",
+	"friday", "sunday", "january", "june", "july", "december", "baby",
+	"babygirl", "mother", "father", "sister", "brother", "secure", "private",
+	"system", "server", "database", "test", "testing", "guest", "user",
+	"username", "service", "office", "company", "business", "money", "dollar",
+	"bitcoin", "crypto", "matrix", "ninja", "samurai", "wizard", "warrior",
+	"phoenix", "tiger", "eagle", "falcon", "wolf", "bear", "lion", "horse",
+	"dog", "cat", "fish", "bird", "snoopy", "scooby", "mickey", "minnie",
+	"donald", "garfield", "pikachu", "mario", "zelda", "minecraft", "fortnite",
+	"gamer", "player", "music", "guitar", "piano", "rock", "metal", "jazz",
+	"dance", "party", "beer", "vodka", "coffee", "pizza", "banana", "apple",
+	"cherry", "lemon", "peanut", "butter", "sugar", "honey", "sweet",
+	"sweetie", "cutie", "beauty", "pretty", "diamond", "crystal", "rainbow",
+	"star", "moon", "sun", "sky", "ocean", "river", "mountain", "forest",
+	"correct", "battery", "staple",
+}
@@ -0,0 +1,69 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how many failures MemoryStore records between sweeps of
+// forgotten keys, so addresses that never come back do not pile up.
+const sweepEvery = 1024
+
+// MemoryStore is a Store local to one process.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+	writes  int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: map[string]Record{}}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records[key], nil
+}
+
+func (s *MemoryStore) Fail(ctx context.Context, key string, at, since time.Time) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.writes++
+	if s.writes%sweepEvery == 0 {
+		for k, r := range s.records {
+			if r.LastFailure.Before(since) {
+				delete(s.records, k)
+			}
+		}
+	}
+
+	record := s.records[key]
+	if record.LastFailure.Before(since) {
+		record.Failures = 0
+	}
+	record.Failures++
+	record.LastFailure = at
+	s.records[key] = record
+	return record, nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.records[key]; ok && record.Failures > 0 {
+		record.Failures--
+		s.records[key] = record
+	}
+	return nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
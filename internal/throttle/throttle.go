@@ -0,0 +1,162 @@
+// Package throttle slows down repeated failures, such as wrong passwords, per
+// key. Each failure past a free allowance doubles the wait before the next
+// attempt, and enough failures lock the key out entirely for a while.
+package throttle
+
+import (
+	"context"
+	"time"
+)
+
+// Record is what a Store keeps per key.
+type Record struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// Store persists failure counts. Fail has to be atomic: concurrent failures
+// on one key must all be counted.
+type Store interface {
+	// Get returns the zero Record for unknown keys.
+	Get(ctx context.Context, key string) (Record, error)
+	// Fail counts a failure at `at`. Failures before `since` are forgotten
+	// first, so the count restarts at one.
+	Fail(ctx context.Context, key string, at, since time.Time) (Record, error)
+	// Release takes back one failure, leaving LastFailure as it is.
+	Release(ctx context.Context, key string) error
+	Reset(ctx context.Context, key string) error
+}
+
+// Policy describes how quickly a key is slowed down.
+type Policy struct {
+	// FreeAttempts failures are allowed back to back.
+	FreeAttempts int
+	// BaseDelay is the wait after the first failure past FreeAttempts. It
+	// doubles with every further failure up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutThreshold failures lock the key for LockoutDuration. Zero
+	// disables lockout.
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// Window is how long a failure is remembered.
+	Window time.Duration
+}
+
+var (
+	// DefaultAccountPolicy protects a single account: a few typos are free,
+	// then backoff, and ten failures lock the account for fifteen minutes.
+	DefaultAccountPolicy = Policy{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         time.Minute,
+		LockoutThreshold: 10,
+		LockoutDuration:  15 * time.Minute,
+		Window:           time.Hour,
+	}
+
+	// DefaultIPPolicy protects against one client spraying many accounts. It
+	// never locks out, since many users can share an address.
+	DefaultIPPolicy = Policy{
+		FreeAttempts: 20,
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Minute,
+		Window:       time.Hour,
+	}
+)
+
+// Limiter applies a Policy to keys kept in a Store.
+type Limiter struct {
+	store  Store
+	policy Policy
+}
+
+func New(store Store, policy Policy) *Limiter {
+	return &Limiter{store: store, policy: policy}
+}
+
+// Check returns how long the caller has to wait before trying key again. Zero
+// means go ahead.
+func (l *Limiter) Check(ctx context.Context, key string, now time.Time) (time.Duration, error) {
+	record, err := l.store.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return l.wait(record, now), nil
+}
+
+// Attempt is Check and Fail in one step: it counts an attempt on key as a
+// failure before it is made, and returns how long the caller has to wait
+// first. Zero means go ahead. Counting first means concurrent attempts cannot
+// all get past Check before any of them fails; the caller Releases or Resets
+// key when the attempt succeeds.
+//
+// An attempt that has to wait is not counted.
+func (l *Limiter) Attempt(ctx context.Context, key string, now time.Time) (time.Duration, error) {
+	before, err := l.store.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if wait := l.wait(before, now); wait > 0 {
+		return wait, nil
+	}
+
+	after, err := l.store.Fail(ctx, key, now, now.Add(-l.policy.Window))
+	if err != nil {
+		return 0, err
+	}
+	// Unless this was the only failure counted since Get, the one before it
+	// belongs to a concurrent attempt, which has to be waited out too.
+	previous := Record{Failures: after.Failures - 1, LastFailure: after.LastFailure}
+	if after.Failures == before.Failures+1 {
+		previous.LastFailure = before.LastFailure
+	}
+	wait := l.wait(previous, now)
+	if wait > 0 {
+		if err := l.store.Release(ctx, key); err != nil {
+			return 0, err
+		}
+	}
+	return wait, nil
+}
+
+// Release takes back an attempt counted by Attempt that did not fail.
+func (l *Limiter) Release(ctx context.Context, key string) error {
+	return l.store.Release(ctx, key)
+}
+
+// Fail records a failed attempt on key.
+func (l *Limiter) Fail(ctx context.Context, key string, now time.Time) error {
+	_, err := l.store.Fail(ctx, key, now, now.Add(-l.policy.Window))
+	return err
+}
+
+// Reset forgets the failures on key, typically after a success.
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	return l.store.Reset(ctx, key)
+}
+
+func (l *Limiter) locked(record Record, now time.Time) bool {
+	return l.policy.LockoutThreshold > 0 &&
+		record.Failures >= l.policy.LockoutThreshold &&
+		now.Before(record.LastFailure.Add(l.policy.LockoutDuration))
+}
+
+func (l *Limiter) wait(record Record, now time.Time) time.Duration {
+	if record.Failures == 0 || !now.Before(record.LastFailure.Add(l.policy.Window)) {
+		return 0
+	}
+
+	var delay time.Duration
+	switch {
+	case l.locked(record, now):
+		delay = l.policy.LockoutDuration
+	case record.Failures > l.policy.FreeAttempts:
+		delay = l.policy.BaseDelay
+		for i := l.policy.FreeAttempts + 1; i < record.Failures && delay < l.policy.MaxDelay; i++ {
+			delay *= 2
+		}
+		delay = min(delay, l.policy.MaxDelay)
+	}
+	return max(record.LastFailure.Add(delay).Sub(now), 0)
+}
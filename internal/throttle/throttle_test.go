@@ -0,0 +1,127 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	ctx := context.Background()
+	policy := Policy{FreeAttempts: 2, BaseDelay: time.Second, MaxDelay: 4 * time.Second, Window: time.Hour}
+	l := New(NewMemoryStore(), policy)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Waits after each failure, measured from the failure itself.
+	want := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second}
+	for i, w := range want {
+		if err := l.Fail(ctx, "k", now); err != nil {
+			t.Fatal(err)
+		}
+		got, err := l.Check(ctx, "k", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != w {
+			t.Errorf("after %d failures wait = %v, want %v", i+1, got, w)
+		}
+		now = now.Add(got)
+	}
+
+	if got, _ := l.Check(ctx, "other", now); got != 0 {
+		t.Errorf("unrelated key wait = %v, want 0", got)
+	}
+
+	l.Reset(ctx, "k")
+	if got, _ := l.Check(ctx, "k", now); got != 0 {
+		t.Errorf("wait after Reset = %v, want 0", got)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	ctx := context.Background()
+	policy := Policy{FreeAttempts: 10, LockoutThreshold: 3, LockoutDuration: 15 * time.Minute, Window: time.Hour}
+	l := New(NewMemoryStore(), policy)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for range 3 {
+		l.Fail(ctx, "k", now)
+	}
+	if got, _ := l.Check(ctx, "k", now); got != 15*time.Minute {
+		t.Fatalf("wait at the threshold = %v, want 15m", got)
+	}
+	if got, _ := l.Check(ctx, "k", now.Add(5*time.Minute)); got != 10*time.Minute {
+		t.Errorf("wait during lockout = %v, want 10m", got)
+	}
+
+	later := now.Add(15 * time.Minute)
+	if got, _ := l.Check(ctx, "k", later); got != 0 {
+		t.Errorf("wait after lockout = %v, want 0", got)
+	}
+}
+
+func TestAttempt(t *testing.T) {
+	ctx := context.Background()
+	l := New(NewMemoryStore(), Policy{FreeAttempts: 2, BaseDelay: time.Minute, MaxDelay: time.Minute, Window: time.Hour})
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Attempts made at once are counted before any of them can fail, so only
+	// the free ones go ahead.
+	waits := make(chan time.Duration, 10)
+	var wg sync.WaitGroup
+	for range cap(waits) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait, err := l.Attempt(ctx, "k", now)
+			if err != nil {
+				t.Error(err)
+			}
+			waits <- wait
+		}()
+	}
+	wg.Wait()
+	close(waits)
+	allowed := 0
+	for wait := range waits {
+		if wait == 0 {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Fatalf("allowed %d concurrent attempts, want 3", allowed)
+	}
+
+	// Turned away attempts are not counted; released ones are taken back.
+	if got, _ := l.Check(ctx, "k", now); got != time.Minute {
+		t.Errorf("wait after three failures = %v, want 1m", got)
+	}
+	l.Release(ctx, "k")
+	if got, _ := l.Check(ctx, "k", now); got != 0 {
+		t.Errorf("wait after Release = %v, want 0", got)
+	}
+}
+
+func TestWindowForgetsFailures(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	l := New(store, Policy{FreeAttempts: 1, BaseDelay: time.Minute, MaxDelay: time.Minute, Window: time.Hour})
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	l.Fail(ctx, "k", now)
+	l.Fail(ctx, "k", now)
+	if got, _ := l.Check(ctx, "k", now); got != time.Minute {
+		t.Fatalf("wait = %v, want 1m", got)
+	}
+
+	now = now.Add(2 * time.Hour)
+	l.Fail(ctx, "k", now)
+	record, _ := store.Get(ctx, "k")
+	if record.Failures != 1 {
+		t.Errorf("failures after the window = %d, want 1", record.Failures)
+	}
+	if got, _ := l.Check(ctx, "k", now); got != 0 {
+		t.Errorf("wait = %v, want 0", got)
+	}
+}
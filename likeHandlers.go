@@ -0,0 +1,120 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/jcuello/chirpy/internal/auth"
+	"github.com/jcuello/chirpy/internal/database"
+)
+
+// likeTarget resolves the chirp in the path for the authenticated caller. It
+// writes the error response itself and reports whether the caller may go on.
+func (cfg *apiConfig) likeTarget(w http.ResponseWriter, r *http.Request) (uuid.UUID, database.Chirp, bool) {
+	userId, _ := auth.UserIDFromContext(r.Context())
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid chirpID")
+		return uuid.Nil, database.Chirp{}, false
+	}
+
+	chirp, err := cfg.db.GetChirp(r.Context(), chirpID)
+	if err != nil || chirp.DeletedAt.Valid {
+		if err == nil || err == sql.ErrNoRows {
+			respondWithError(w, 404, "Chirp Not Found")
+		} else {
+			respondWithInternalServerError(w)
+		}
+		return uuid.Nil, database.Chirp{}, false
+	}
+
+	return userId, chirp, true
+}
+
+func (cfg *apiConfig) handlePostLike(w http.ResponseWriter, r *http.Request) {
+	userId, chirp, ok := cfg.likeTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.LikeChirp(r.Context(), database.LikeChirpParams{
+		UserID:  userId,
+		ChirpID: chirp.ID,
+	})
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	respondWithJson(w, 204, struct{}{})
+}
+
+func (cfg *apiConfig) handleDeleteLike(w http.ResponseWriter, r *http.Request) {
+	userId, chirp, ok := cfg.likeTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.UnlikeChirp(r.Context(), database.UnlikeChirpParams{
+		UserID:  userId,
+		ChirpID: chirp.ID,
+	})
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	respondWithJson(w, 204, struct{}{})
+}
+
+// handleGetUserLikes lists the chirps a user has liked, most recent like first.
+func (cfg *apiConfig) handleGetUserLikes(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid userID")
+		return
+	}
+
+	limit, cursor, err := pageParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	params := database.ListUserLikedChirpsParams{
+		UserID:   userID,
+		PageSize: int32(limit + 1),
+	}
+	if cursor != nil {
+		params.CursorLikedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		params.CursorChirpID = uuid.NullUUID{UUID: cursor.ID, Valid: true}
+	}
+
+	rows, err := cfg.db.ListUserLikedChirps(r.Context(), params)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	page := chirpPage{}
+	if len(rows) > limit {
+		rows = rows[:limit]
+		last := rows[len(rows)-1]
+		page.NextCursor = chirpCursor{CreatedAt: last.LikedAt, ID: last.Chirp.ID}.encode()
+	}
+
+	chirps := make([]database.Chirp, 0, len(rows))
+	for _, row := range rows {
+		chirps = append(chirps, row.Chirp)
+	}
+
+	page.Chirps, err = cfg.chirpResponses(r.Context(), chirps, cfg.viewerID(r), expandAuthor(r))
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	respondWithJson(w, 200, page)
+}
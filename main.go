@@ -1,65 +1,364 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
 
+	"github.com/jcuello/chirpy/internal/auth"
 	"github.com/jcuello/chirpy/internal/database"
+	"github.com/jcuello/chirpy/internal/mail"
+	"github.com/jcuello/chirpy/internal/moderation"
+	"github.com/jcuello/chirpy/internal/password"
+	"github.com/jcuello/chirpy/internal/throttle"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
-var cfg apiConfig = apiConfig{}
-
 func main() {
 	godotenv.Load()
-	dbURL := os.Getenv("DB_URL")
-	db, err := sql.Open("postgres", dbURL)
 
-	if err != nil {
-		fmt.Printf("Unable to connect to database %v\n", err)
-		os.Exit(1)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		db := openDB()
+		if err := runMigrateCommand(db, os.Args[2:]); err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "calibrate-password" {
+		if err := runCalibrateCommand(os.Args[2:]); err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	dbQueries := database.New(db)
+	var store database.Store
+	switch os.Getenv("STORE") {
+	case "memory":
+		store = database.NewMemoryStore()
+	case "", "postgres":
+		db := openDB()
+		if err := checkMigrations(db); err != nil {
+			fmt.Printf("Refusing to start: %v\n", err)
+			os.Exit(1)
+		}
+		store = database.New(db)
+	default:
+		fmt.Printf("Unknown STORE %q, expected \"postgres\" or \"memory\"\n", os.Getenv("STORE"))
+		os.Exit(1)
+	}
 
-	serveMux := http.ServeMux{}
-	server := http.Server{}
-	cfg.db = dbQueries
-	cfg.jwtSecret = os.Getenv("JWT_SECRET")
-	cfg.polkaApiKey = os.Getenv("POLKA_KEY")
-	appUrlPrefix := "/app/"
-	appFileServerHandler := http.StripPrefix(appUrlPrefix, http.FileServer(http.Dir(".")))
-
-	serveMux.Handle(appUrlPrefix, cfg.middlewareMetricsInc(appFileServerHandler))
-	serveMux.HandleFunc("GET /api/healthz", func(resp http.ResponseWriter, request *http.Request) {
-		resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		resp.WriteHeader(http.StatusOK)
-		resp.Write([]byte("OK\n"))
+	keys, err := newKeySet()
+	if err != nil {
+		fmt.Printf("Unable to load JWT keys: %v\n", err)
+		os.Exit(1)
+	}
 
+	cfg := newAPIConfig(store, keys, os.Getenv("POLKA_KEY"), os.Getenv("PLATFORM"))
+	if cfg.passwords, err = newPasswordHasher(); err != nil {
+		fmt.Printf("Unable to configure password hashing: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.passwordPolicy, err = newPasswordPolicy(); err != nil {
+		fmt.Printf("Unable to configure password policy: %v\n", err)
+		os.Exit(1)
+	}
+	cfg.adminEmails = strings.FieldsFunc(os.Getenv("ADMIN_EMAILS"), func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
 	})
-	serveMux.HandleFunc("POST /api/chirps", handlePostChirp)
-	serveMux.HandleFunc("GET /api/chirps", handleGetChirps)
-	serveMux.HandleFunc("GET /api/chirps/{chirpID}", handleGetSingleChirp)
-	serveMux.HandleFunc("DELETE /api/chirps/{chirpID}", handleDeleteChirps)
 
-	serveMux.HandleFunc("POST /api/users", handlePostUser)
-	serveMux.HandleFunc("PUT /api/users", handlePutChirp)
+	cfg.requireVerifiedEmail = os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true"
+	if baseURL := os.Getenv("BASE_URL"); baseURL != "" {
+		cfg.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+	if cfg.mailer, err = newMailer(); err != nil {
+		fmt.Printf("Unable to configure mail: %v\n", err)
+		os.Exit(1)
+	}
 
-	serveMux.HandleFunc("POST /api/polka/webhooks", handlePolkaWebhook)
+	if err = configureAccountDeletion(cfg); err != nil {
+		fmt.Printf("Unable to configure account deletion: %v\n", err)
+		os.Exit(1)
+	}
+	go cfg.purgeAccountsEvery(context.Background(), time.Hour)
 
-	serveMux.HandleFunc("POST /api/login", handleLogin)
-	serveMux.HandleFunc("POST /api/refresh", handleRefresh)
-	serveMux.HandleFunc("POST /api/revoke", handleRevoke)
+	if dir := os.Getenv("EXPORT_DIR"); dir != "" {
+		cfg.exports = newExportJobs(dir)
+	}
+	inlineLimit, err := envUint("EXPORT_INLINE_CHIRPS", 63, defaultExportInlineLimit)
+	if err != nil {
+		fmt.Printf("Unable to configure exports: %v\n", err)
+		os.Exit(1)
+	}
+	cfg.exportInlineLimit = int64(inlineLimit)
 
-	serveMux.HandleFunc("GET /admin/metrics", cfg.viewMetrics())
-	serveMux.HandleFunc("POST /admin/reset", cfg.resetMetrics())
+	switch os.Getenv("THROTTLE_STORE") {
+	case "", "memory":
+	case "database":
+		cfg.accountThrottle = throttle.New(dbThrottleStore{store}, throttle.DefaultAccountPolicy)
+		cfg.ipThrottle = throttle.New(dbThrottleStore{store}, throttle.DefaultIPPolicy)
+		cfg.passwordResetEmailThrottle = throttle.New(dbThrottleStore{store}, passwordResetEmailPolicy)
+		cfg.passwordResetIPThrottle = throttle.New(dbThrottleStore{store}, passwordResetIPPolicy)
+		cfg.verificationUserThrottle = throttle.New(dbThrottleStore{store}, verificationUserPolicy)
+		cfg.verificationEmailThrottle = throttle.New(dbThrottleStore{store}, verificationEmailPolicy)
+	default:
+		fmt.Printf("Unknown THROTTLE_STORE %q, expected \"memory\" or \"database\"\n", os.Getenv("THROTTLE_STORE"))
+		os.Exit(1)
+	}
 
-	server.Handler = &serveMux
+	filter, err := newModerationFilter(store)
+	if err != nil {
+		fmt.Printf("Unable to load moderation lists: %v\n", err)
+		os.Exit(1)
+	}
+	cfg.moderation = filter
+	reloadOnSignal(filter)
+
+	server := http.Server{}
+	server.Handler = cfg.handler()
 	server.Addr = ":8080"
 
 	server.ListenAndServe()
 
 }
+
+// newKeySet loads the JWT signing keys from JWT_KEY_DIR, or from the PEM in
+// JWT_PRIVATE_KEY plus any retired public keys in JWT_PUBLIC_KEYS. Without
+// either it signs HS256 with JWT_SECRET. When both are set, JWT_SECRET only
+// verifies tokens issued before the switch.
+func newKeySet() (*auth.KeySet, error) {
+	var keys *auth.KeySet
+	var err error
+	switch {
+	case os.Getenv("JWT_KEY_DIR") != "":
+		keys, err = auth.LoadKeySetDir(os.Getenv("JWT_KEY_DIR"))
+	case os.Getenv("JWT_PRIVATE_KEY") != "":
+		keys, err = auth.LoadKeySetPEM([]byte(os.Getenv("JWT_PRIVATE_KEY")), []byte(os.Getenv("JWT_PUBLIC_KEYS")))
+	default:
+		return auth.NewHMACKeySet(os.Getenv("JWT_SECRET")), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		keys.AllowHMAC(secret)
+	}
+	return keys, nil
+}
+
+// passwordParams reads ARGON2_MEMORY_KIB, ARGON2_ITERATIONS and
+// ARGON2_PARALLELISM, defaulting each to auth.DefaultPasswordParams.
+func passwordParams() (auth.PasswordParams, error) {
+	params := auth.DefaultPasswordParams
+	memory, err := envUint("ARGON2_MEMORY_KIB", 32, uint64(params.Memory))
+	if err != nil {
+		return params, err
+	}
+	iterations, err := envUint("ARGON2_ITERATIONS", 32, uint64(params.Iterations))
+	if err != nil {
+		return params, err
+	}
+	parallelism, err := envUint("ARGON2_PARALLELISM", 8, uint64(params.Parallelism))
+	if err != nil {
+		return params, err
+	}
+
+	params = auth.PasswordParams{
+		Memory:      uint32(memory),
+		Iterations:  uint32(iterations),
+		Parallelism: uint8(parallelism),
+	}
+	return params, params.Validate()
+}
+
+// envUint parses the environment variable name as an unsigned integer of the
+// given size, or returns def when it is unset.
+func envUint(name string, bits int, def uint64) (uint64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseUint(value, 10, bits)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return v, nil
+}
+
+// newPasswordHasher hashes new passwords with the configured parameters.
+// Existing hashes are upgraded as their owners log in.
+func newPasswordHasher() (*auth.PasswordHasher, error) {
+	params, err := passwordParams()
+	if err != nil {
+		return nil, err
+	}
+	return auth.NewPasswordHasher(params), nil
+}
+
+// newPasswordPolicy starts from password.DefaultPolicy. PASSWORD_MIN_LENGTH
+// and PASSWORD_MIN_ENTROPY (bits) override its limits, and
+// BREACHED_PASSWORDS_DIR points at a directory of Pwned Passwords range
+// files to check new passwords against.
+func newPasswordPolicy() (password.Policy, error) {
+	policy := password.DefaultPolicy
+
+	minLength, err := envUint("PASSWORD_MIN_LENGTH", 16, uint64(policy.MinLength))
+	if err != nil {
+		return policy, err
+	}
+	policy.MinLength = int(minLength)
+
+	if value := os.Getenv("PASSWORD_MIN_ENTROPY"); value != "" {
+		policy.MinEntropy, err = strconv.ParseFloat(value, 64)
+		if err != nil || policy.MinEntropy < 0 {
+			return policy, fmt.Errorf("invalid PASSWORD_MIN_ENTROPY %q", value)
+		}
+	}
+
+	if dir := os.Getenv("BREACHED_PASSWORDS_DIR"); dir != "" {
+		// A missing range file means "not breached", so a wrong path
+		// would quietly let everything through.
+		info, err := os.Stat(dir)
+		if err != nil {
+			return policy, err
+		}
+		if !info.IsDir() {
+			return policy, fmt.Errorf("BREACHED_PASSWORDS_DIR %q is not a directory", dir)
+		}
+		policy.Breached = password.RangeDir(dir)
+	}
+	return policy, nil
+}
+
+// runCalibrateCommand prints the ARGON2_* settings that make one hash take
+// the target time given as the first argument, 500ms by default, at the
+// configured memory and parallelism.
+func runCalibrateCommand(args []string) error {
+	target := 500 * time.Millisecond
+	if len(args) > 0 {
+		var err error
+		if target, err = time.ParseDuration(args[0]); err != nil {
+			return fmt.Errorf("usage: chirpy calibrate-password [target duration]: %w", err)
+		}
+	}
+
+	base, err := passwordParams()
+	if err != nil {
+		return err
+	}
+	params, elapsed, err := auth.CalibratePasswordParams(target, base.Memory, base.Parallelism)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("ARGON2_MEMORY_KIB=%d\n", params.Memory)
+	fmt.Printf("ARGON2_ITERATIONS=%d\n", params.Iterations)
+	fmt.Printf("ARGON2_PARALLELISM=%d\n", params.Parallelism)
+	fmt.Printf("# one hash took %v on this machine\n", elapsed.Round(time.Millisecond))
+	return nil
+}
+
+// configureAccountDeletion reads ACCOUNT_DELETION_MODE ("delete", the
+// default, or "anonymize") and ACCOUNT_DELETION_GRACE, a duration such as
+// "336h". A grace of zero deletes accounts as soon as they ask.
+func configureAccountDeletion(cfg *apiConfig) error {
+	switch mode := accountDeletionMode(os.Getenv("ACCOUNT_DELETION_MODE")); mode {
+	case "":
+	case deleteAccounts, anonymizeAccounts:
+		cfg.deletionMode = mode
+	default:
+		return fmt.Errorf("unknown ACCOUNT_DELETION_MODE %q, expected \"delete\" or \"anonymize\"", mode)
+	}
+
+	if value := os.Getenv("ACCOUNT_DELETION_GRACE"); value != "" {
+		grace, err := time.ParseDuration(value)
+		if err != nil || grace < 0 {
+			return fmt.Errorf("invalid ACCOUNT_DELETION_GRACE %q", value)
+		}
+		cfg.deletionGrace = grace
+	}
+	return nil
+}
+
+// newMailer reads MAILER. "smtp" relays through SMTP_ADDR, authenticating
+// with SMTP_USERNAME and SMTP_PASSWORD when set. "log", the default, prints
+// mail to stdout.
+func newMailer() (mail.Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "chirpy@localhost"
+	}
+
+	switch os.Getenv("MAILER") {
+	case "", "log":
+		return mail.NewLogMailer(os.Stdout, from), nil
+	case "smtp":
+		return &mail.SMTPMailer{
+			Addr:     os.Getenv("SMTP_ADDR"),
+			From:     from,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q, expected \"log\" or \"smtp\"", os.Getenv("MAILER"))
+	}
+}
+
+// newModerationFilter reads MODERATION_MODE (mask, reject or flag) and
+// MODERATION_WORDLIST, which is a file path or "database".
+func newModerationFilter(store database.Store) (*moderation.Filter, error) {
+	mode, err := moderation.ParseMode(os.Getenv("MODERATION_MODE"))
+	if err != nil {
+		return nil, err
+	}
+
+	var source moderation.Source
+	switch wordlist := os.Getenv("MODERATION_WORDLIST"); wordlist {
+	case "":
+		source = moderation.DefaultWords
+	case "database":
+		source = moderation.SourceFunc(store.ListBannedWords)
+	default:
+		source = moderation.FileSource(wordlist)
+	}
+
+	return moderation.New(context.Background(), mode, source)
+}
+
+// reloadOnSignal re-reads the moderation lists whenever the process gets
+// SIGHUP.
+func reloadOnSignal(filter *moderation.Filter) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			if err := filter.Reload(context.Background()); err != nil {
+				fmt.Printf("Unable to reload moderation lists: %v\n", err)
+			} else {
+				fmt.Println("Reloaded moderation lists.")
+			}
+		}
+	}()
+}
+
+func openDB() *sql.DB {
+	dbURL := os.Getenv("DB_URL")
+	db, err := sql.Open("postgres", dbURL)
+
+	if err != nil {
+		fmt.Printf("Unable to connect to database %v\n", err)
+		os.Exit(1)
+	}
+	return db
+}
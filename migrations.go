@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/jcuello/chirpy/internal/migrate"
+)
+
+//go:embed sql/schema/*.sql
+var embeddedSchema embed.FS
+
+func newMigrator(db *sql.DB) (*migrate.Migrator, error) {
+	schema, err := fs.Sub(embeddedSchema, "sql/schema")
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := migrate.Load(schema)
+	if err != nil {
+		return nil, err
+	}
+	return migrate.New(db, migrations), nil
+}
+
+// runMigrateCommand implements `chirpy migrate up|down|status`.
+func runMigrateCommand(db *sql.DB, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: chirpy migrate up|down|status")
+	}
+
+	migrator, err := newMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("OK   %s\n", m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("No pending migrations.")
+		}
+	case "down":
+		m, ok, err := migrator.Down(ctx)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Println("No migrations to roll back.")
+		} else {
+			fmt.Printf("OK   rolled back %s\n", m.Name)
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%-24s %s\n", "Applied At", "Migration")
+		for _, s := range statuses {
+			appliedAt := "Pending"
+			if s.Applied {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%-24s %s\n", appliedAt, s.Name)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down or status", args[0])
+	}
+	return nil
+}
+
+// checkMigrations refuses to continue when REQUIRE_MIGRATIONS is set and the
+// database is behind the embedded schema.
+func checkMigrations(db *sql.DB) error {
+	if os.Getenv("REQUIRE_MIGRATIONS") != "true" {
+		return nil
+	}
+
+	migrator, err := newMigrator(db)
+	if err != nil {
+		return err
+	}
+	pending, err := migrator.Pending(context.Background())
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d pending migration(s), starting with %s; run `chirpy migrate up`", len(pending), pending[0].Name)
+	}
+	return nil
+}
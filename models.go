@@ -5,26 +5,170 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jcuello/chirpy/internal/auth"
 	"github.com/jcuello/chirpy/internal/database"
+	"github.com/jcuello/chirpy/internal/mail"
+	"github.com/jcuello/chirpy/internal/moderation"
+	"github.com/jcuello/chirpy/internal/password"
+	"github.com/jcuello/chirpy/internal/throttle"
 )
 
 type apiConfig struct {
-	fileserverHits atomic.Int32
-	db             *database.Queries
-	jwtSecret      string
-	polkaApiKey    string
+	fileserverHits  atomic.Int32
+	db              database.Store
+	keys            *auth.KeySet
+	passwords       *auth.PasswordHasher
+	passwordPolicy  password.Policy
+	adminEmails     []string
+	accountThrottle *throttle.Limiter
+	ipThrottle      *throttle.Limiter
+	mailer          mail.Mailer
+	baseURL         string
+	// Password reset requests are throttled per email and per client
+	// address, and at most cap(passwordResetSends) are sent at once.
+	passwordResetEmailThrottle *throttle.Limiter
+	passwordResetIPThrottle    *throttle.Limiter
+	passwordResetSends         chan struct{}
+	// Verification emails the user asks for are throttled per user and per
+	// address.
+	verificationUserThrottle  *throttle.Limiter
+	verificationEmailThrottle *throttle.Limiter
+	// requireVerifiedEmail stops users posting chirps until they have
+	// verified their email address.
+	requireVerifiedEmail bool
+	// Deleted accounts are purged deletionGrace after the request, the way
+	// deletionMode says.
+	deletionMode  accountDeletionMode
+	deletionGrace time.Duration
+	// Exports of accounts with more than exportInlineLimit chirps are built
+	// in the background by exports.
+	exports           *exportJobs
+	exportInlineLimit int64
+	polkaApiKey       string
+	platform          string
+	moderation        *moderation.Filter
+	now               func() time.Time
 }
 
 type chirpPost struct {
-	Body *string `json:"body"`
+	Body      *string    `json:"body"`
+	InReplyTo *uuid.UUID `json:"in_reply_to"`
 }
 
 type chirpCreated struct {
+	Id         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	Body       *string    `json:"body"`
+	UserId     string     `json:"user_id"`
+	InReplyTo  *uuid.UUID `json:"in_reply_to"`
+	ReplyCount int64      `json:"reply_count"`
+	LikeCount  int64      `json:"like_count"`
+	LikedByMe  *bool      `json:"liked_by_me,omitempty"`
+	Edited     bool       `json:"edited"`
+	Deleted    bool       `json:"deleted,omitempty"`
+	// Author is only filled in for ?expand=author.
+	Author *authorSummary `json:"author,omitempty"`
+}
+
+// authorSummary is the public face of a user, embedded wherever they are
+// shown next to their chirps.
+type authorSummary struct {
+	ID          uuid.UUID `json:"id"`
+	Handle      string    `json:"handle,omitempty"`
+	DisplayName string    `json:"display_name"`
+	AvatarURL   string    `json:"avatar_url"`
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+}
+
+// publicProfile is GET /api/users/{handleOrID}. It never includes the email.
+type publicProfile struct {
+	authorSummary
+	Bio        string    `json:"bio"`
+	JoinedAt   time.Time `json:"joined_at"`
+	ChirpCount int64     `json:"chirp_count"`
+}
+
+type chirpPage struct {
+	Chirps     []chirpCreated `json:"chirps"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+type chirpThread struct {
+	Chirp      chirpCreated   `json:"chirp"`
+	Ancestors  []chirpCreated `json:"ancestors"`
+	Replies    []chirpCreated `json:"replies"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+type followEntry struct {
+	UserID     uuid.UUID `json:"user_id"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+type followPage struct {
+	Users      []followEntry `json:"users"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+type chirpRevision struct {
 	Id        uuid.UUID `json:"id"`
+	Body      string    `json:"body"`
 	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Body      *string   `json:"body"`
-	UserId    string    `json:"user_id"`
+}
+
+type chirpRevisions struct {
+	Revisions []chirpRevision `json:"revisions"`
+}
+
+type session struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+}
+
+type sessionList struct {
+	Sessions []session `json:"sessions"`
+}
+
+type exportJobResponse struct {
+	ID          uuid.UUID    `json:"id"`
+	Status      exportStatus `json:"status"`
+	CreatedAt   time.Time    `json:"created_at"`
+	ExpiresAt   time.Time    `json:"expires_at"`
+	DownloadURL string       `json:"download_url,omitempty"`
+}
+
+type exportedProfile struct {
+	ID               uuid.UUID  `json:"id"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	Email            string     `json:"email"`
+	EmailVerified    bool       `json:"email_verified"`
+	PendingEmail     string     `json:"pending_email,omitempty"`
+	Handle           string     `json:"handle,omitempty"`
+	DisplayName      string     `json:"display_name"`
+	Bio              string     `json:"bio"`
+	AvatarURL        string     `json:"avatar_url"`
+	TwoFactorEnabled bool       `json:"two_factor_enabled"`
+	DeleteAfter      *time.Time `json:"delete_after,omitempty"`
+}
+
+type exportedSession struct {
+	SessionID  uuid.UUID  `json:"session_id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+}
+
+type exportedSubscription struct {
+	IsChirpyRed bool `json:"is_chirpy_red"`
 }
 
 type chirpError struct {
@@ -32,13 +176,45 @@ type chirpError struct {
 }
 
 type User struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	Email        string    `json:"email"`
-	IsChirpyRed  bool      `json:"is_chirpy_red"`
-	Token        string    `json:"token,omitempty"`
-	RefreshToken string    `json:"refresh_token,omitempty"`
+	ID            uuid.UUID `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	IsChirpyRed   bool      `json:"is_chirpy_red"`
+	Handle        string    `json:"handle,omitempty"`
+	Token         string    `json:"token,omitempty"`
+	RefreshToken  string    `json:"refresh_token,omitempty"`
+}
+
+type tokenPair struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// mfaChallenge answers a correct password when the account has 2FA on.
+// MFAToken is redeemed at /api/login/mfa together with a code.
+type mfaChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+type mfaLogin struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+type totpEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type totpCode struct {
+	Code string `json:"code"`
+}
+
+type recoveryCodeList struct {
+	RecoveryCodes []string `json:"recovery_codes"`
 }
 
 type UserLogin struct {
@@ -50,6 +226,42 @@ type UserLogin struct {
 type UserPost struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Handle   string `json:"handle,omitempty"`
+}
+
+// userUpdate is the body of PUT /api/users. Empty fields are left alone; the
+// profile fields are pointers so that "" can clear them.
+type userUpdate struct {
+	Email           string  `json:"email"`
+	Password        string  `json:"password"`
+	CurrentPassword string  `json:"current_password"`
+	Handle          *string `json:"handle,omitempty"`
+	DisplayName     *string `json:"display_name,omitempty"`
+	Bio             *string `json:"bio,omitempty"`
+	AvatarURL       *string `json:"avatar_url,omitempty"`
+}
+
+// passwordPolicyError is the 400 body for a password the policy refuses.
+type passwordPolicyError struct {
+	Error      string               `json:"error"`
+	Violations []password.Violation `json:"violations"`
+}
+
+type accountDeletionRequest struct {
+	Password string `json:"password"`
+}
+
+type accountDeletion struct {
+	DeleteAfter time.Time `json:"delete_after"`
+}
+
+type passwordResetRequest struct {
+	Email string `json:"email"`
+}
+
+type passwordResetConfirm struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
 }
 
 type UserUpgradedEvent string
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"net/http"
+)
+
+// handleReloadModeration re-reads the moderation lists, like SIGHUP does.
+func (cfg *apiConfig) handleReloadModeration(w http.ResponseWriter, r *http.Request) {
+	err := cfg.moderation.Reload(r.Context())
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	respondWithJson(w, 204, struct{}{})
+}
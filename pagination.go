@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jcuello/chirpy/internal/database"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// chirpCursor marks the last chirp of a page. The next page starts strictly
+// after (or before, when sorting desc) this (created_at, id) pair.
+type chirpCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+func (c chirpCursor) encode() string {
+	raw := strconv.FormatInt(c.CreatedAt.UnixMicro(), 10) + ":" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeChirpCursor(s string) (chirpCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return chirpCursor{}, errInvalidCursor
+	}
+
+	micros, idStr, found := strings.Cut(string(raw), ":")
+	if !found {
+		return chirpCursor{}, errInvalidCursor
+	}
+
+	unixMicro, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return chirpCursor{}, errInvalidCursor
+	}
+
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return chirpCursor{}, errInvalidCursor
+	}
+
+	return chirpCursor{CreatedAt: time.UnixMicro(unixMicro).UTC(), ID: id}, nil
+}
+
+// pageParams reads the `limit` and `cursor` query parameters.
+func pageParams(query url.Values) (int, *chirpCursor, error) {
+	limit := defaultPageSize
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 {
+			return 0, nil, errors.New("invalid limit")
+		}
+		limit = min(parsed, maxPageSize)
+	}
+
+	cursorStr := query.Get("cursor")
+	if cursorStr == "" {
+		return limit, nil, nil
+	}
+
+	cursor, err := decodeChirpCursor(cursorStr)
+	if err != nil {
+		return 0, nil, err
+	}
+	return limit, &cursor, nil
+}
+
+// trimPage drops the extra row fetched to detect another page and returns the
+// cursor for that page, or "" when this is the last one.
+func trimPage(chirps []database.Chirp, limit int) ([]database.Chirp, string) {
+	if len(chirps) <= limit {
+		return chirps, ""
+	}
+	chirps = chirps[:limit]
+	last := chirps[len(chirps)-1]
+	return chirps, chirpCursor{CreatedAt: last.CreatedAt.Time, ID: last.ID}.encode()
+}
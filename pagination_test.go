@@ -0,0 +1,107 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jcuello/chirpy/internal/database"
+)
+
+func TestChirpCursorRoundTrip(t *testing.T) {
+	want := chirpCursor{
+		CreatedAt: time.Date(2025, 1, 2, 3, 4, 5, 678901000, time.UTC),
+		ID:        uuid.New(),
+	}
+
+	got, err := decodeChirpCursor(want.encode())
+	if err != nil {
+		t.Fatalf("decodeChirpCursor() error = %v", err)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) || got.ID != want.ID {
+		t.Errorf("decodeChirpCursor() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeChirpCursorInvalid(t *testing.T) {
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+
+	for name, cursor := range map[string]string{
+		"not base64":    "!!!",
+		"no separator":  encode("1735787045678901"),
+		"bad timestamp": encode("yesterday:" + uuid.NewString()),
+		"bad id":        encode("1735787045678901:walt"),
+	} {
+		if _, err := decodeChirpCursor(cursor); err != errInvalidCursor {
+			t.Errorf("%s: decodeChirpCursor() error = %v, want errInvalidCursor", name, err)
+		}
+	}
+}
+
+func TestPageParams(t *testing.T) {
+	cursor := chirpCursor{CreatedAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), ID: uuid.New()}
+
+	tests := []struct {
+		query     string
+		wantLimit int
+		wantErr   bool
+	}{
+		{"", defaultPageSize, false},
+		{"limit=10", 10, false},
+		{"limit=1000", maxPageSize, false},
+		{"limit=0", 0, true},
+		{"limit=-1", 0, true},
+		{"limit=ten", 0, true},
+		{"cursor=garbage!", 0, true},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		limit, got, err := pageParams(query)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("pageParams(%q) error = %v, want error %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if limit != tt.wantLimit || got != nil {
+			t.Errorf("pageParams(%q) = %d, %+v, want %d, nil", tt.query, limit, got, tt.wantLimit)
+		}
+	}
+
+	limit, got, err := pageParams(url.Values{"limit": {"5"}, "cursor": {cursor.encode()}})
+	if err != nil || limit != 5 || got == nil || *got != cursor {
+		t.Errorf("pageParams() with cursor = %d, %+v, %v, want 5, %+v", limit, got, err, cursor)
+	}
+}
+
+func TestTrimPage(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	chirps := make([]database.Chirp, 3)
+	for i := range chirps {
+		chirps[i] = database.Chirp{
+			ID:        uuid.New(),
+			CreatedAt: sql.NullTime{Time: start.Add(time.Duration(i) * time.Minute), Valid: true},
+		}
+	}
+
+	page, next := trimPage(chirps, 3)
+	if len(page) != 3 || next != "" {
+		t.Errorf("trimPage() of a last page = %d chirps, cursor %q, want 3 and none", len(page), next)
+	}
+
+	// The page was fetched with one extra row, so there is another page.
+	page, next = trimPage(chirps, 2)
+	if len(page) != 2 {
+		t.Fatalf("trimPage() kept %d chirps, want 2", len(page))
+	}
+	cursor, err := decodeChirpCursor(next)
+	if err != nil {
+		t.Fatalf("trimPage() cursor %q: %v", next, err)
+	}
+	if cursor.ID != chirps[1].ID || !cursor.CreatedAt.Equal(chirps[1].CreatedAt.Time) {
+		t.Errorf("trimPage() cursor = %+v, want the last chirp kept", cursor)
+	}
+}
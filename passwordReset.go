@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jcuello/chirpy/internal/auth"
+	"github.com/jcuello/chirpy/internal/database"
+	"github.com/jcuello/chirpy/internal/mail"
+	"github.com/jcuello/chirpy/internal/throttle"
+)
+
+const (
+	passwordResetExpiration = 30 * time.Minute
+	// passwordResetSendTimeout bounds the background work of one request.
+	passwordResetSendTimeout = time.Minute
+	// maxPasswordResetSends bounds how many requests can be looking up and
+	// mailing at once; more are turned away until one finishes.
+	maxPasswordResetSends = 16
+)
+
+var (
+	// passwordResetEmailPolicy allows a few links a day per address, so
+	// the endpoint cannot be used to flood someone's inbox.
+	passwordResetEmailPolicy = throttle.Policy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       24 * time.Hour,
+	}
+
+	// passwordResetIPPolicy stops one client asking for links to many
+	// addresses.
+	passwordResetIPPolicy = throttle.Policy{
+		FreeAttempts: 10,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       time.Hour,
+	}
+)
+
+// handleRequestPasswordReset mails a reset link if the email belongs to an
+// account. It answers 202 either way, and does the lookup and sending after
+// responding, so neither the status nor the latency reveals whether the
+// address is registered. Throttled or overloaded requests get a 429 or 503
+// for registered and unknown addresses alike.
+func (cfg *apiConfig) handleRequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	params := passwordResetRequest{}
+	defer r.Body.Close()
+
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil || params.Email == "" {
+		respondWithError(w, 400, "Invalid body")
+		return
+	}
+	// Addresses are unique regardless of case, so they are throttled and
+	// looked up that way too.
+	email := strings.ToLower(params.Email)
+
+	if !cfg.passwordResetAllowed(w, r, email) {
+		return
+	}
+	select {
+	case cfg.passwordResetSends <- struct{}{}:
+	default:
+		respondWithError(w, 503, "Too many password reset requests, try again later")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), passwordResetSendTimeout)
+	go func() {
+		defer func() { <-cfg.passwordResetSends }()
+		defer cancel()
+		err := cfg.sendPasswordReset(ctx, email)
+		if err != nil {
+			fmt.Printf("Unable to send password reset email: %v\n", err)
+		}
+	}()
+
+	respondWithJson(w, 202, struct{}{})
+}
+
+// passwordResetAllowed writes a 429 with Retry-After once the caller's
+// address or the email asked about has had too many requests, and reports
+// whether to go on. Every request counts, whether or not the email belongs to
+// an account.
+func (cfg *apiConfig) passwordResetAllowed(w http.ResponseWriter, r *http.Request, email string) bool {
+	now := cfg.now()
+	wait, err := cfg.passwordResetIPThrottle.Attempt(r.Context(), "reset-ip:"+clientIP(r), now)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return false
+	}
+	if wait == 0 {
+		wait, err = cfg.passwordResetEmailThrottle.Attempt(r.Context(), "reset-email:"+email, now)
+		if err != nil {
+			respondWithInternalServerError(w)
+			return false
+		}
+	}
+
+	if wait == 0 {
+		return true
+	}
+	respondWithTooManyRequests(w, wait, "Too many password reset requests, try again later")
+	return false
+}
+
+// sendPasswordReset issues a reset token for the account registered under
+// email, if any, and mails it there.
+func (cfg *apiConfig) sendPasswordReset(ctx context.Context, email string) error {
+	user, err := cfg.db.GetUser(ctx, sql.NullString{String: email, Valid: true})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := auth.MakePasswordResetToken()
+	if err != nil {
+		return err
+	}
+	_, err = cfg.db.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: cfg.now().UTC().Add(passwordResetExpiration),
+	})
+	if err != nil {
+		return err
+	}
+
+	// The web client serves this page and posts the token back to
+	// /api/password-reset/confirm along with the new password.
+	link := cfg.baseURL + "/reset-password?token=" + url.QueryEscape(token)
+	return cfg.mailer.Send(ctx, mail.Message{
+		To:      user.Email.String,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your Chirpy account.\n\n"+
+			"Open this link within %v to choose a new password:\n\n%s\n\n"+
+			"If it was not you, you can ignore this email; your password has not changed.\n",
+			passwordResetExpiration, link),
+	})
+}
+
+// handleConfirmPasswordReset sets a new password using a token from a reset
+// email. The token works once, and every session of the account is signed
+// out, since whoever held them may have known the old password.
+func (cfg *apiConfig) handleConfirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	params := passwordResetConfirm{}
+	defer r.Body.Close()
+
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil || params.Token == "" || params.Password == "" {
+		respondWithError(w, 400, "Invalid body")
+		return
+	}
+
+	// Look before consuming, so a password the policy refuses does not
+	// spend the link.
+	tokenHash := auth.HashToken(params.Token)
+	pending, err := cfg.db.GetPasswordResetToken(r.Context(), tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 400, "Invalid or expired reset token")
+		return
+	}
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+	user, err := cfg.db.GetUserByID(r.Context(), pending.UserID)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+	if !cfg.passwordAcceptable(w, r, params.Password, user.Email.String) {
+		return
+	}
+
+	hash, err := cfg.passwords.HashPassword(params.Password)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	resetToken, err := cfg.db.ConsumePasswordResetToken(r.Context(), tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 400, "Invalid or expired reset token")
+		return
+	}
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	err = cfg.db.SetUserPassword(r.Context(), database.SetUserPasswordParams{
+		ID:             resetToken.UserID,
+		HashedPassword: hash,
+	})
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	err = cfg.db.RevokeAllUserRefreshTokens(r.Context(), uuid.NullUUID{UUID: resetToken.UserID, Valid: true})
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	// Any other links still in the inbox are spent too.
+	err = cfg.db.DeletePasswordResetTokens(r.Context(), resetToken.UserID)
+	if err != nil {
+		fmt.Printf("Unable to delete reset tokens of %v: %v\n", resetToken.UserID, err)
+	}
+
+	// Lift a lockout left by whoever was guessing the old password.
+	cfg.resetLoginFailures(r.Context(), newLoginKeys(r, user.Email.String))
+
+	respondWithJson(w, 204, struct{}{})
+}
@@ -8,7 +8,7 @@ import (
 	"github.com/jcuello/chirpy/internal/auth"
 )
 
-func handlePolkaWebhook(w http.ResponseWriter, r *http.Request) {
+func (cfg *apiConfig) handlePolkaWebhook(w http.ResponseWriter, r *http.Request) {
 	key, err := auth.GetAPIKey(r.Header)
 	if key != cfg.polkaApiKey {
 		respondWithError(w, 401, "unauthorized")
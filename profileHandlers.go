@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jcuello/chirpy/internal/database"
+)
+
+const (
+	minHandleLength      = 3
+	maxHandleLength      = 30
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+	maxAvatarURLLength   = 2048
+)
+
+// reservedHandles could be mistaken for the site itself or collide with
+// routes such as /api/users/me. They are compared lower-cased.
+var reservedHandles = map[string]bool{
+	"abuse":         true,
+	"admin":         true,
+	"administrator": true,
+	"api":           true,
+	"app":           true,
+	"chirpy":        true,
+	"help":          true,
+	"login":         true,
+	"logout":        true,
+	"me":            true,
+	"moderator":     true,
+	"null":          true,
+	"root":          true,
+	"security":      true,
+	"settings":      true,
+	"signup":        true,
+	"staff":         true,
+	"support":       true,
+	"system":        true,
+	"verify":        true,
+}
+
+// validHandle accepts 3 to 30 ASCII letters, digits and underscores starting
+// with a letter, so a handle can never be read as a UUID.
+func validHandle(handle string) bool {
+	if len(handle) < minHandleLength || len(handle) > maxHandleLength {
+		return false
+	}
+	for i, r := range handle {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && (r >= '0' && r <= '9' || r == '_'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// handleAvailable writes an error unless handle is valid, not reserved and
+// not taken by anyone but self, and reports whether to go on.
+func (cfg *apiConfig) handleAvailable(w http.ResponseWriter, r *http.Request, handle string, self uuid.UUID) bool {
+	if !validHandle(handle) {
+		respondWithError(w, 400, "Handles are 3 to 30 letters, digits or underscores, starting with a letter")
+		return false
+	}
+	if reservedHandles[strings.ToLower(handle)] {
+		respondWithError(w, 400, "Handle is reserved")
+		return false
+	}
+
+	existing, err := cfg.db.GetUserByHandle(r.Context(), handle)
+	if err == nil && existing.ID != self {
+		respondWithError(w, 409, "Handle already taken")
+		return false
+	}
+	if err != nil && err != sql.ErrNoRows {
+		respondWithInternalServerError(w)
+		return false
+	}
+	return true
+}
+
+// profileFieldsOK writes a 400 unless the profile fields being set are within
+// their limits, and reports whether to go on. Nil fields are not being set.
+func profileFieldsOK(w http.ResponseWriter, displayName, bio, avatarURL *string) bool {
+	if displayName != nil && utf8.RuneCountInString(*displayName) > maxDisplayNameLength {
+		respondWithError(w, 400, "Display name is too long")
+		return false
+	}
+	if bio != nil && utf8.RuneCountInString(*bio) > maxBioLength {
+		respondWithError(w, 400, "Bio is too long")
+		return false
+	}
+	if avatarURL != nil && *avatarURL != "" {
+		u, err := url.Parse(*avatarURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || len(*avatarURL) > maxAvatarURLLength {
+			respondWithError(w, 400, "Avatar URL must be an absolute http or https URL")
+			return false
+		}
+	}
+	return true
+}
+
+// handleGetUserProfile shows the public side of an account, looked up by
+// UUID or, case-insensitively, by handle.
+func (cfg *apiConfig) handleGetUserProfile(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("handleOrID")
+
+	var user database.User
+	var err error
+	if userId, parseErr := uuid.Parse(key); parseErr == nil {
+		user, err = cfg.db.GetUserByID(r.Context(), userId)
+	} else {
+		user, err = cfg.db.GetUserByHandle(r.Context(), key)
+	}
+	if err == sql.ErrNoRows {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	count, err := cfg.db.CountAuthorChirps(r.Context(), uuid.NullUUID{UUID: user.ID, Valid: true})
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	respondWithJson(w, 200, publicProfile{
+		authorSummary: newAuthorSummary(user),
+		Bio:           user.Bio,
+		JoinedAt:      user.CreatedAt.Time,
+		ChirpCount:    count,
+	})
+}
+
+func newAuthorSummary(user database.User) authorSummary {
+	return authorSummary{
+		ID:          user.ID,
+		Handle:      user.Handle.String,
+		DisplayName: user.DisplayName,
+		AvatarURL:   user.AvatarUrl,
+		IsChirpyRed: user.IsChirpyRed.Bool,
+	}
+}
+
+// expandAuthor reports whether the request asked for authors to be embedded
+// with ?expand=author.
+func expandAuthor(r *http.Request) bool {
+	for _, field := range strings.Split(r.URL.Query().Get("expand"), ",") {
+		if strings.TrimSpace(field) == "author" {
+			return true
+		}
+	}
+	return false
+}
+
+// authorSummaries loads the public summary of every author in chirps with one
+// query.
+func (cfg *apiConfig) authorSummaries(ctx context.Context, chirps []database.Chirp) (map[uuid.UUID]authorSummary, error) {
+	seen := map[uuid.UUID]bool{}
+	ids := []uuid.UUID{}
+	for _, c := range chirps {
+		if c.UserID.Valid && !c.DeletedAt.Valid && !seen[c.UserID.UUID] {
+			seen[c.UserID.UUID] = true
+			ids = append(ids, c.UserID.UUID)
+		}
+	}
+
+	authors := map[uuid.UUID]authorSummary{}
+	if len(ids) == 0 {
+		return authors, nil
+	}
+	users, err := cfg.db.GetUsersByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		authors[user.ID] = newAuthorSummary(user)
+	}
+	return authors, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/jcuello/chirpy/internal/auth"
+	"github.com/jcuello/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) handleGetSessions(w http.ResponseWriter, r *http.Request) {
+	userId, _ := auth.UserIDFromContext(r.Context())
+
+	rows, err := cfg.db.ListActiveSessions(r.Context(), uuid.NullUUID{UUID: userId, Valid: true})
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	sessions := make([]session, 0, len(rows))
+	for _, row := range rows {
+		sessions = append(sessions, session{
+			ID:         row.FamilyID,
+			CreatedAt:  row.StartedAt,
+			LastUsedAt: row.LastUsedAt.Time,
+			ExpiresAt:  row.ExpiresAt.Time,
+			UserAgent:  row.UserAgent.String,
+			IPAddress:  row.IpAddress.String,
+		})
+	}
+
+	respondWithJson(w, 200, sessionList{Sessions: sessions})
+}
+
+func (cfg *apiConfig) handleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	userId, _ := auth.UserIDFromContext(r.Context())
+
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid sessionID")
+		return
+	}
+
+	// Sessions belonging to someone else look the same as missing ones.
+	revoked, err := cfg.db.RevokeUserSession(r.Context(), database.RevokeUserSessionParams{
+		UserID:   uuid.NullUUID{UUID: userId, Valid: true},
+		FamilyID: sessionID,
+	})
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, 404, "Session not found")
+		return
+	}
+
+	respondWithJson(w, 204, struct{}{})
+}
+
+// handleDeleteSessions logs the caller out everywhere. Access tokens already
+// issued stay valid until they expire.
+func (cfg *apiConfig) handleDeleteSessions(w http.ResponseWriter, r *http.Request) {
+	userId, _ := auth.UserIDFromContext(r.Context())
+
+	err := cfg.db.RevokeAllUserRefreshTokens(r.Context(), uuid.NullUUID{UUID: userId, Valid: true})
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	respondWithJson(w, 204, struct{}{})
+}
+
+// clientIP is the address the request came from. Forwarding headers are
+// ignored because any client can set them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
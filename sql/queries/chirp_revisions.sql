@@ -0,0 +1,22 @@
+-- name: EditChirp :one
+-- Saves the current body as a revision and replaces it in one statement.
+-- FOR UPDATE makes concurrent edits queue up, so each revision holds the
+-- body the previous edit wrote.
+WITH previous AS (
+  INSERT INTO chirp_revisions (id, chirp_id, body, created_at)
+  SELECT gen_random_uuid(), current.id, COALESCE(current.body, ''), NOW()
+  FROM (
+    SELECT id, body FROM chirps
+    WHERE id = sqlc.arg('id') AND deleted_at IS NULL
+    FOR UPDATE
+  ) AS current
+)
+UPDATE chirps
+SET body = sqlc.arg('body'), edited_at = NOW(), updated_at = NOW()
+WHERE id = sqlc.arg('id') AND deleted_at IS NULL
+RETURNING *;
+
+-- name: ListChirpRevisions :many
+SELECT * FROM chirp_revisions
+WHERE chirp_id = $1
+ORDER BY created_at DESC, id DESC;
@@ -1,12 +1,13 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to)
 VALUES (
-    gen_random_uuid(), NOW(), NOW(), $1, $2
+    gen_random_uuid(), NOW(), NOW(), $1, $2, $3
 )
 RETURNING *;
 
 -- name: GetAllChirps :many
 SELECT * FROM chirps
+WHERE deleted_at IS NULL
 ORDER BY created_at ASC;
 
 -- name: GetChirp :one
@@ -17,7 +18,102 @@ WHERE id = $1;
 DELETE FROM chirps
 WHERE id = $1;
 
+-- name: TombstoneChirp :exec
+-- Blanks the chirp and deletes its revisions in one statement, so old
+-- revisions cannot keep the deleted text readable.
+WITH revisions AS (
+  DELETE FROM chirp_revisions
+  WHERE chirp_id = $1
+)
+UPDATE chirps
+SET body = NULL, deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1;
+
+-- name: DeleteChirpAndTombstones :exec
+-- Deletes the chirp along with the tombstones above it that it was the last
+-- reply to, all in one statement. A tombstone is only kept for its replies.
+WITH RECURSIVE doomed AS (
+  SELECT id, in_reply_to FROM chirps
+  WHERE id = $1
+  UNION ALL
+  SELECT parent.id, parent.in_reply_to
+  FROM chirps parent
+  JOIN doomed ON parent.id = doomed.in_reply_to
+  WHERE parent.deleted_at IS NOT NULL
+    AND NOT EXISTS (
+      SELECT 1 FROM chirps reply
+      WHERE reply.in_reply_to = parent.id AND reply.id <> doomed.id
+    )
+)
+DELETE FROM chirps
+WHERE id IN (SELECT id FROM doomed);
+
+-- name: ChirpHasReplies :one
+SELECT EXISTS (
+  SELECT 1 FROM chirps
+  WHERE in_reply_to = $1
+);
+
 -- name: GetAuthorChirps :many
 SELECT * FROM chirps
-WHERE user_id = $1
-ORDER BY created_at ASC;
\ No newline at end of file
+WHERE user_id = $1 AND deleted_at IS NULL
+ORDER BY created_at ASC;
+
+-- name: CountAuthorChirps :one
+SELECT COUNT(*) FROM chirps
+WHERE user_id = $1 AND deleted_at IS NULL;
+
+-- name: ListChirpsAsc :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+  AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('page_size');
+
+-- name: ListChirpsDesc :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+  AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_size');
+
+-- name: GetReplyCounts :many
+SELECT in_reply_to, COUNT(*) AS reply_count
+FROM chirps
+WHERE in_reply_to = ANY(sqlc.arg('chirp_ids')::uuid[]) AND deleted_at IS NULL
+GROUP BY in_reply_to;
+
+-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+  SELECT parent.id, parent.in_reply_to, 1 AS depth
+  FROM chirps parent
+  WHERE parent.id = (SELECT c.in_reply_to FROM chirps c WHERE c.id = $1)
+  UNION ALL
+  SELECT parent.id, parent.in_reply_to, ancestors.depth + 1
+  FROM chirps parent
+  JOIN ancestors ON parent.id = ancestors.in_reply_to
+)
+SELECT chirps.* FROM chirps
+JOIN ancestors ON chirps.id = ancestors.id
+ORDER BY ancestors.depth DESC;
+
+-- name: ListChirpDescendants :many
+WITH RECURSIVE descendants AS (
+  SELECT reply.id
+  FROM chirps reply
+  WHERE reply.in_reply_to = sqlc.arg('root_id')
+  UNION ALL
+  SELECT reply.id
+  FROM chirps reply
+  JOIN descendants ON reply.in_reply_to = descendants.id
+)
+SELECT chirps.* FROM chirps
+JOIN descendants ON chirps.id = descendants.id
+WHERE sqlc.narg('cursor_created_at')::timestamp IS NULL
+  OR (chirps.created_at, chirps.id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+ORDER BY chirps.created_at ASC, chirps.id ASC
+LIMIT sqlc.arg('page_size');
@@ -0,0 +1,45 @@
+-- name: FollowUser :exec
+INSERT INTO follows (follower_id, followee_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (follower_id, followee_id) DO NOTHING;
+
+-- name: UnfollowUser :exec
+DELETE FROM follows
+WHERE follower_id = $1 AND followee_id = $2;
+
+-- name: ListFollowers :many
+SELECT * FROM follows
+WHERE followee_id = sqlc.arg('user_id')
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, follower_id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at DESC, follower_id DESC
+LIMIT sqlc.arg('page_size');
+
+-- name: ListFollowing :many
+SELECT * FROM follows
+WHERE follower_id = sqlc.arg('user_id')
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, followee_id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at DESC, followee_id DESC
+LIMIT sqlc.arg('page_size');
+
+-- name: ListTimeline :many
+-- Reads at most one page from each followed account, and from the caller,
+-- through chirps_user_id_created_at_id_idx, then keeps the newest page of
+-- those.
+SELECT c.* FROM (
+  SELECT followee_id AS user_id FROM follows WHERE follower_id = sqlc.arg('user_id')
+  UNION ALL
+  SELECT sqlc.arg('user_id')::uuid
+) f
+CROSS JOIN LATERAL (
+  SELECT * FROM chirps
+  WHERE chirps.user_id = f.user_id
+    AND deleted_at IS NULL
+    AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+      OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+  ORDER BY created_at DESC, id DESC
+  LIMIT sqlc.arg('page_size')
+) c
+ORDER BY c.created_at DESC, c.id DESC
+LIMIT sqlc.arg('page_size');
@@ -0,0 +1,26 @@
+-- name: GetLoginAttempts :one
+SELECT * FROM login_attempts
+WHERE key = $1;
+
+-- name: RecordLoginFailure :one
+-- Failures older than since are forgotten before counting this one.
+INSERT INTO login_attempts (key, failures, last_failure_at)
+VALUES (sqlc.arg('key'), 1, sqlc.arg('failed_at'))
+ON CONFLICT (key) DO UPDATE
+SET failures = CASE
+    WHEN login_attempts.last_failure_at < sqlc.arg('since')::timestamp THEN 1
+    ELSE login_attempts.failures + 1
+  END,
+  last_failure_at = EXCLUDED.last_failure_at
+RETURNING *;
+
+-- name: ClearLoginAttempts :exec
+DELETE FROM login_attempts
+WHERE key = $1;
+
+-- name: ReleaseLoginFailure :exec
+-- Takes back the most recent failure, for an attempt counted up front that
+-- turned out to succeed.
+UPDATE login_attempts
+SET failures = failures - 1
+WHERE key = $1 AND failures > 0;
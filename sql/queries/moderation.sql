@@ -0,0 +1,9 @@
+-- name: ListBannedWords :many
+SELECT phrase FROM banned_words
+ORDER BY phrase;
+
+-- name: FlagChirp :exec
+INSERT INTO chirp_flags (chirp_id, matches, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (chirp_id) DO UPDATE
+SET matches = EXCLUDED.matches, created_at = EXCLUDED.created_at;
@@ -0,0 +1,21 @@
+-- name: CreatePasswordResetToken :one
+INSERT INTO password_reset_tokens (id, token_hash, user_id, created_at, expires_at)
+VALUES (
+  gen_random_uuid(), $1, $2, NOW(), $3
+)
+RETURNING *;
+
+-- name: GetPasswordResetToken :one
+SELECT * FROM password_reset_tokens
+WHERE token_hash = $1 AND used_at IS NULL AND NOW() <= expires_at;
+
+-- name: ConsumePasswordResetToken :one
+-- Marks the token used only if it is still usable, so it works exactly once.
+UPDATE password_reset_tokens
+SET used_at = NOW()
+WHERE token_hash = $1 AND used_at IS NULL AND NOW() <= expires_at
+RETURNING *;
+
+-- name: DeletePasswordResetTokens :exec
+DELETE FROM password_reset_tokens
+WHERE user_id = $1;
@@ -1,16 +1,76 @@
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens(token, created_at, updated_at, user_id, expires_at, revoked_at)
+INSERT INTO refresh_tokens(id, token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, user_agent, ip_address)
 VALUES(
-  $1, NOW(), NOW(), $2, $3, NULL
+  $1, $2, NOW(), NOW(), $3, $4, NULL, $5, $6, $7
 )
 RETURNING *;
 
 -- name: GetUserFromRefreshToken :one
-SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+SELECT created_at, updated_at, user_id, expires_at, revoked_at, family_id, user_agent, ip_address, id, token_hash
 FROM refresh_tokens
-WHERE revoked_at IS NULL AND NOW() <= expires_at AND token = $1 LIMIT 1;
+WHERE revoked_at IS NULL AND NOW() <= expires_at AND id = $1;
+
+-- name: GetRefreshToken :one
+SELECT * FROM refresh_tokens
+WHERE id = $1;
 
 -- name: RevokeRefreshToken :exec
 UPDATE refresh_tokens
 SET revoked_at = NOW()
-WHERE token = $1;
\ No newline at end of file
+WHERE id = $1;
+
+-- name: RotateRefreshToken :one
+-- Revokes the token, if it is still usable, and inserts its successor in the
+-- same family in one statement, returning the successor. Two requests racing
+-- with the same token cannot both succeed, and a failure leaves the session
+-- with either the old token or the new one.
+WITH rotated AS (
+  UPDATE refresh_tokens
+  SET revoked_at = NOW(), updated_at = NOW()
+  WHERE id = sqlc.arg('id') AND revoked_at IS NULL AND NOW() <= expires_at
+  RETURNING user_id, family_id
+)
+INSERT INTO refresh_tokens(id, token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, user_agent, ip_address)
+SELECT sqlc.arg('new_id'), sqlc.arg('new_token_hash'), NOW(), NOW(), rotated.user_id, sqlc.arg('expires_at'), NULL, rotated.family_id, sqlc.arg('user_agent'), sqlc.arg('ip_address')
+FROM rotated
+RETURNING *;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1 AND revoked_at IS NULL;
+
+-- name: ListActiveSessions :many
+-- A session is a token family. Only its newest token is active, so that
+-- token's created_at is when the session was last used.
+SELECT
+  active.family_id,
+  (SELECT MIN(started.created_at) FROM refresh_tokens started WHERE started.family_id = active.family_id)::timestamp AS started_at,
+  active.created_at AS last_used_at,
+  active.expires_at,
+  active.user_agent,
+  active.ip_address
+FROM refresh_tokens active
+WHERE active.user_id = $1 AND active.revoked_at IS NULL AND NOW() <= active.expires_at
+ORDER BY active.created_at DESC;
+
+-- name: ListUserRefreshTokens :many
+-- Every token the user was ever issued, revoked and expired ones included.
+SELECT * FROM refresh_tokens
+WHERE user_id = $1
+ORDER BY created_at ASC;
+
+-- name: RevokeUserSession :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND family_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeOtherUserSessions :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL;
+
+-- name: RevokeAllUserRefreshTokens :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
@@ -0,0 +1,28 @@
+-- name: StartTOTPEnrollment :one
+-- Replaces a pending enrollment, but never an enabled credential.
+INSERT INTO totp_credentials (user_id, secret, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (user_id) DO UPDATE
+SET secret = EXCLUDED.secret, created_at = EXCLUDED.created_at, last_used_step = 0
+WHERE totp_credentials.enabled_at IS NULL
+RETURNING *;
+
+-- name: GetTOTPCredential :one
+SELECT * FROM totp_credentials
+WHERE user_id = $1;
+
+-- name: EnableTOTPCredential :execrows
+UPDATE totp_credentials
+SET enabled_at = NOW(), last_used_step = $2
+WHERE user_id = $1 AND enabled_at IS NULL;
+
+-- name: UseTOTPStep :execrows
+-- Each time step is accepted at most once, so an observed code cannot be
+-- replayed.
+UPDATE totp_credentials
+SET last_used_step = $2
+WHERE user_id = $1 AND enabled_at IS NOT NULL AND last_used_step < $2;
+
+-- name: DeleteTOTPCredential :exec
+DELETE FROM totp_credentials
+WHERE user_id = $1;
@@ -1,7 +1,7 @@
 -- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, handle)
 VALUES (
-    gen_random_uuid(), NOW(), NOW(), $1, $2
+    gen_random_uuid(), NOW(), NOW(), $1, $2, $3
 )
 RETURNING *;
 
@@ -9,16 +9,97 @@ RETURNING *;
 DELETE FROM users;
 
 -- name: GetUser :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red
-FROM users
-WHERE email = $1 LIMIT 1;
+-- Addresses are compared case-insensitively, the way they are kept unique.
+SELECT * FROM users
+WHERE LOWER(email) = LOWER($1) LIMIT 1;
 
--- name: UpdateUserPassword :exec
+-- name: RequestEmailChange :one
+-- The new address only replaces the old one once ConfirmEmailChange runs.
 UPDATE users
-SET email = $1, hashed_password = $2, updated_at = NOW()
-WHERE email = $1;
+SET pending_email = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: ConfirmEmailChange :execrows
+-- Only swaps in the address the token was issued for, so a link to an
+-- address that has since been replaced by another request does nothing.
+UPDATE users
+SET email = pending_email, pending_email = NULL, email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND pending_email = $2;
 
 -- name: UpgradeToChirpyRed :exec
 UPDATE users
 SET is_chirpy_red = TRUE
-WHERE id = $1;
\ No newline at end of file
+WHERE id = $1;
+
+-- name: GetUserByID :one
+SELECT * FROM users
+WHERE id = $1;
+
+-- name: GetUserByHandle :one
+SELECT * FROM users
+WHERE LOWER(handle) = LOWER(sqlc.arg('handle')::text);
+
+-- name: GetUsersByIDs :many
+SELECT * FROM users
+WHERE id = ANY(sqlc.arg('ids')::uuid[]);
+
+-- name: UpdateUserProfile :one
+UPDATE users
+SET handle = $2, display_name = $3, bio = $4, avatar_url = $5, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: VerifyUserEmail :execrows
+-- Only verifies the address the token was issued for, so a token sent before
+-- an email change is useless afterwards.
+UPDATE users
+SET email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+WHERE id = $1 AND email = $2;
+
+-- name: SetUserPassword :exec
+UPDATE users
+SET hashed_password = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: RehashUserPassword :execrows
+-- Replaces the hash only if it is still old_hashed_password, so a rehash
+-- racing a password change cannot undo it.
+UPDATE users
+SET hashed_password = sqlc.arg('hashed_password'), updated_at = NOW()
+WHERE id = sqlc.arg('id') AND hashed_password = sqlc.arg('old_hashed_password');
+
+-- name: ScheduleUserDeletion :exec
+UPDATE users
+SET delete_after = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: CancelUserDeletion :execrows
+UPDATE users
+SET delete_after = NULL, updated_at = NOW()
+WHERE id = $1 AND delete_after IS NOT NULL;
+
+-- name: ListUsersDueForDeletion :many
+SELECT id FROM users
+WHERE delete_after <= $1
+ORDER BY delete_after;
+
+-- name: PurgeUser :execrows
+-- Everything the user owns goes with them through ON DELETE CASCADE. The
+-- deadline is checked again in case a login cancelled the deletion.
+DELETE FROM users
+WHERE id = sqlc.arg(id) AND delete_after <= sqlc.arg(due_by);
+
+-- name: PurgeUserKeepChirps :execrows
+-- Like PurgeUser, but the user's chirps stay, without an author. Both
+-- statements see the same snapshot, so they agree on whether the user is
+-- due.
+WITH detached AS (
+  UPDATE chirps
+  SET user_id = NULL
+  WHERE chirps.user_id = sqlc.arg(id) AND EXISTS (
+    SELECT 1 FROM users WHERE users.id = sqlc.arg(id) AND users.delete_after <= sqlc.arg(due_by)
+  )
+)
+DELETE FROM users
+WHERE id = sqlc.arg(id) AND delete_after <= sqlc.arg(due_by);
@@ -0,0 +1,18 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD edited_at timestamp;
+
+CREATE TABLE chirp_revisions(
+  id UUID PRIMARY KEY,
+  chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+  body text NOT NULL,
+  created_at timestamp NOT NULL
+);
+
+CREATE INDEX chirp_revisions_chirp_id_created_at_idx ON chirp_revisions (chirp_id, created_at);
+
+-- +goose Down
+DROP TABLE chirp_revisions;
+
+ALTER TABLE chirps
+DROP edited_at;
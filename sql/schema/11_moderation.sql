@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE banned_words(
+  phrase TEXT PRIMARY KEY,
+  created_at timestamp NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE chirp_flags(
+  chirp_id UUID PRIMARY KEY REFERENCES chirps(id) ON DELETE CASCADE,
+  matches TEXT NOT NULL,
+  created_at timestamp NOT NULL
+);
+
+-- +goose Down
+DROP TABLE chirp_flags;
+DROP TABLE banned_words;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- Plaintext tokens are not carried over. Everyone has to log in again.
+DELETE FROM refresh_tokens;
+
+ALTER TABLE refresh_tokens
+DROP token;
+
+ALTER TABLE refresh_tokens
+ADD id UUID PRIMARY KEY,
+ADD token_hash TEXT NOT NULL;
+
+-- +goose Down
+DELETE FROM refresh_tokens;
+
+ALTER TABLE refresh_tokens
+DROP id,
+DROP token_hash;
+
+ALTER TABLE refresh_tokens
+ADD token TEXT PRIMARY KEY;
@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE users
+ADD email_verified_at TIMESTAMP;
+
+-- Accounts created before verification existed keep working.
+UPDATE users
+SET email_verified_at = NOW();
+
+-- +goose Down
+ALTER TABLE users
+DROP email_verified_at;
@@ -0,0 +1,17 @@
+-- +goose Up
+ALTER TABLE users
+  ADD COLUMN handle TEXT,
+  ADD COLUMN display_name TEXT NOT NULL DEFAULT '',
+  ADD COLUMN bio TEXT NOT NULL DEFAULT '',
+  ADD COLUMN avatar_url TEXT NOT NULL DEFAULT '';
+
+-- Handles keep the case they were chosen in but are unique ignoring it.
+CREATE UNIQUE INDEX users_handle_lower_idx ON users (LOWER(handle));
+
+-- +goose Down
+DROP INDEX users_handle_lower_idx;
+ALTER TABLE users
+  DROP COLUMN avatar_url,
+  DROP COLUMN bio,
+  DROP COLUMN display_name,
+  DROP COLUMN handle;
@@ -0,0 +1,34 @@
+-- +goose Up
+-- A changed address waits here until it is verified, so an unverified
+-- address never receives password resets.
+ALTER TABLE users ADD COLUMN pending_email TEXT;
+
+-- Addresses are unique ignoring case, like handles. Accounts that already
+-- share an address have to be merged or renamed by hand first; the index
+-- would fail on them anyway, only with a less helpful error.
+-- +goose StatementBegin
+DO $$
+DECLARE
+  conflicts TEXT;
+BEGIN
+  SELECT string_agg(address, ', ') INTO conflicts
+  FROM (
+    SELECT LOWER(email) AS address FROM users
+    WHERE email IS NOT NULL
+    GROUP BY LOWER(email)
+    HAVING COUNT(*) > 1
+  ) duplicates;
+
+  IF conflicts IS NOT NULL THEN
+    RAISE EXCEPTION 'users share an email address ignoring case: %', conflicts
+      USING HINT = 'Give each of these accounts its own address, then migrate again.';
+  END IF;
+END
+$$;
+-- +goose StatementEnd
+
+CREATE UNIQUE INDEX users_email_lower_idx ON users (LOWER(email));
+
+-- +goose Down
+DROP INDEX users_email_lower_idx;
+ALTER TABLE users DROP COLUMN pending_email;
@@ -0,0 +1,13 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD in_reply_to UUID REFERENCES chirps(id) ON DELETE SET NULL,
+ADD deleted_at timestamp;
+
+CREATE INDEX chirps_in_reply_to_idx ON chirps (in_reply_to, created_at, id);
+
+-- +goose Down
+DROP INDEX chirps_in_reply_to_idx;
+
+ALTER TABLE chirps
+DROP in_reply_to,
+DROP deleted_at;
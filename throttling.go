@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jcuello/chirpy/internal/database"
+	"github.com/jcuello/chirpy/internal/throttle"
+)
+
+// dbThrottleStore keeps throttling state in login_attempts, so every instance
+// behind a load balancer sees the same counts.
+type dbThrottleStore struct {
+	db database.Store
+}
+
+func (s dbThrottleStore) Get(ctx context.Context, key string) (throttle.Record, error) {
+	attempt, err := s.db.GetLoginAttempts(ctx, key)
+	if err == sql.ErrNoRows {
+		return throttle.Record{}, nil
+	}
+	if err != nil {
+		return throttle.Record{}, err
+	}
+	return throttle.Record{Failures: int(attempt.Failures), LastFailure: attempt.LastFailureAt}, nil
+}
+
+func (s dbThrottleStore) Fail(ctx context.Context, key string, at, since time.Time) (throttle.Record, error) {
+	attempt, err := s.db.RecordLoginFailure(ctx, database.RecordLoginFailureParams{
+		Key:      key,
+		FailedAt: at.UTC(),
+		Since:    since.UTC(),
+	})
+	if err != nil {
+		return throttle.Record{}, err
+	}
+	return throttle.Record{Failures: int(attempt.Failures), LastFailure: attempt.LastFailureAt}, nil
+}
+
+func (s dbThrottleStore) Release(ctx context.Context, key string) error {
+	return s.db.ReleaseLoginFailure(ctx, key)
+}
+
+func (s dbThrottleStore) Reset(ctx context.Context, key string) error {
+	return s.db.ClearLoginAttempts(ctx, key)
+}
+
+// loginKeys names the two things a login attempt is throttled on.
+type loginKeys struct {
+	account string
+	ip      string
+}
+
+func newLoginKeys(r *http.Request, email string) loginKeys {
+	return loginKeys{
+		account: "account:" + strings.ToLower(email),
+		ip:      "ip:" + clientIP(r),
+	}
+}
+
+// loginAllowed writes a 429 with Retry-After while either key is throttled,
+// and reports whether the caller may go on to check the password. The
+// attempt is counted as failed before the password is checked, so parallel
+// guesses cannot all slip in before the first one fails; the caller takes it
+// back with loginSucceeded or firstFactorPassed.
+func (cfg *apiConfig) loginAllowed(w http.ResponseWriter, r *http.Request, keys loginKeys) bool {
+	now := cfg.now()
+	wait, err := cfg.accountThrottle.Attempt(r.Context(), keys.account, now)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return false
+	}
+	if wait == 0 {
+		wait, err = cfg.ipThrottle.Attempt(r.Context(), keys.ip, now)
+		if err != nil {
+			respondWithInternalServerError(w)
+			return false
+		}
+		if wait > 0 {
+			cfg.releaseLoginAttempt(r.Context(), cfg.accountThrottle, keys.account)
+		}
+	}
+
+	if wait == 0 {
+		return true
+	}
+	respondWithTooManyRequests(w, wait, "Too many login attempts, try again later")
+	return false
+}
+
+func respondWithTooManyRequests(w http.ResponseWriter, wait time.Duration, msg string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	respondWithError(w, 429, msg)
+}
+
+// loginSucceeded takes back the attempt and clears the account's failures.
+// The address keeps its earlier count, or an attacker could reset it by
+// logging into an account of their own.
+func (cfg *apiConfig) loginSucceeded(ctx context.Context, keys loginKeys) {
+	cfg.resetLoginFailures(ctx, keys)
+	cfg.releaseLoginAttempt(ctx, cfg.ipThrottle, keys.ip)
+}
+
+// firstFactorPassed takes back the attempt for a password that matched but
+// still needs a second factor. Wrong codes guessed before stay counted.
+func (cfg *apiConfig) firstFactorPassed(ctx context.Context, keys loginKeys) {
+	cfg.releaseLoginAttempt(ctx, cfg.accountThrottle, keys.account)
+	cfg.releaseLoginAttempt(ctx, cfg.ipThrottle, keys.ip)
+}
+
+// resetLoginFailures forgets the account's failures, as after a completed
+// login or a password reset.
+func (cfg *apiConfig) resetLoginFailures(ctx context.Context, keys loginKeys) {
+	if err := cfg.accountThrottle.Reset(ctx, keys.account); err != nil {
+		fmt.Printf("Unable to reset failed logins for %v: %v\n", keys.account, err)
+	}
+}
+
+func (cfg *apiConfig) releaseLoginAttempt(ctx context.Context, limiter *throttle.Limiter, key string) {
+	if err := limiter.Release(ctx, key); err != nil {
+		fmt.Printf("Unable to release login attempt for %v: %v\n", key, err)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jcuello/chirpy/internal/auth"
+	"github.com/jcuello/chirpy/internal/database"
+)
+
+const (
+	mfaTokenExpiration = 5 * time.Minute
+	recoveryCodeCount  = 10
+	totpIssuer         = "Chirpy"
+)
+
+// handleStartTOTP begins 2FA enrollment with a fresh secret. It takes effect
+// once handleConfirmTOTP sees a code from it, so an abandoned enrollment
+// never locks anyone out.
+func (cfg *apiConfig) handleStartTOTP(w http.ResponseWriter, r *http.Request) {
+	userId, _ := auth.UserIDFromContext(r.Context())
+
+	user, err := cfg.db.GetUserByID(r.Context(), userId)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	_, err = cfg.db.StartTOTPEnrollment(r.Context(), database.StartTOTPEnrollmentParams{
+		UserID: userId,
+		Secret: secret,
+	})
+	if err == sql.ErrNoRows {
+		respondWithError(w, 409, "Two-factor authentication is already enabled")
+		return
+	}
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	respondWithJson(w, 200, totpEnrollment{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(totpIssuer, user.Email.String, secret),
+	})
+}
+
+// handleConfirmTOTP turns 2FA on given a code from the pending secret, and
+// returns the recovery codes. They are shown only this once.
+func (cfg *apiConfig) handleConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	userId, _ := auth.UserIDFromContext(r.Context())
+
+	params := totpCode{}
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil || params.Code == "" {
+		respondWithError(w, 400, "Invalid body")
+		return
+	}
+
+	cred, err := cfg.db.GetTOTPCredential(r.Context(), userId)
+	if err == sql.ErrNoRows {
+		respondWithError(w, 400, "No two-factor enrollment in progress")
+		return
+	}
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+	if cred.EnabledAt.Valid {
+		respondWithError(w, 409, "Two-factor authentication is already enabled")
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(cred.Secret, params.Code, cfg.now())
+	if !ok {
+		respondWithError(w, 400, "Invalid code")
+		return
+	}
+
+	enabled, err := cfg.db.EnableTOTPCredential(r.Context(), database.EnableTOTPCredentialParams{
+		UserID:       userId,
+		LastUsedStep: step,
+	})
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+	if enabled == 0 {
+		respondWithError(w, 409, "Two-factor authentication is already enabled")
+		return
+	}
+
+	codes, err := cfg.issueRecoveryCodes(r.Context(), userId)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	respondWithJson(w, 200, recoveryCodeList{RecoveryCodes: codes})
+}
+
+// handleDisableTOTP turns 2FA off. It takes a code like a login would, so a
+// stolen access token alone cannot remove the second factor, and wrong codes
+// count against the login throttle so it cannot guess one either.
+func (cfg *apiConfig) handleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	userId, _ := auth.UserIDFromContext(r.Context())
+
+	params := totpCode{}
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil || params.Code == "" {
+		respondWithError(w, 400, "Invalid body")
+		return
+	}
+
+	enabled, err := cfg.mfaEnabled(r.Context(), userId)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+	if !enabled {
+		respondWithError(w, 409, "Two-factor authentication is not enabled")
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), userId)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+	throttleKeys := newLoginKeys(r, user.Email.String)
+	if !cfg.loginAllowed(w, r, throttleKeys) {
+		return
+	}
+
+	ok, err := cfg.checkSecondFactor(r.Context(), userId, params.Code)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+	if !ok {
+		respondWithError(w, 403, "Invalid code")
+		return
+	}
+	cfg.loginSucceeded(r.Context(), throttleKeys)
+
+	err = cfg.db.DeleteTOTPCredential(r.Context(), userId)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+	err = cfg.db.DeleteRecoveryCodes(r.Context(), userId)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	respondWithJson(w, 204, struct{}{})
+}
+
+// handleLoginMFA finishes a login that handleLogin answered with an MFA
+// challenge. Wrong codes count against the same throttle as wrong
+// passwords.
+func (cfg *apiConfig) handleLoginMFA(w http.ResponseWriter, r *http.Request) {
+	params := mfaLogin{}
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil || params.MFAToken == "" || params.Code == "" {
+		respondWithError(w, 400, "Invalid body")
+		return
+	}
+
+	userId, err := auth.ValidateMFAToken(params.MFAToken, cfg.keys)
+	if err != nil {
+		respondWithError(w, 401, "Invalid or expired MFA token")
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), userId)
+	if err == sql.ErrNoRows {
+		respondWithError(w, 401, "Invalid or expired MFA token")
+		return
+	}
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+
+	throttleKeys := newLoginKeys(r, user.Email.String)
+	if !cfg.loginAllowed(w, r, throttleKeys) {
+		return
+	}
+
+	ok, err := cfg.checkSecondFactor(r.Context(), userId, params.Code)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+	if !ok {
+		respondWithError(w, 401, "Incorrect code")
+		return
+	}
+	cfg.loginSucceeded(r.Context(), throttleKeys)
+
+	cfg.startSession(w, r, user)
+}
+
+// mfaEnabled reports whether the user has confirmed a TOTP enrollment.
+func (cfg *apiConfig) mfaEnabled(ctx context.Context, userId uuid.UUID) (bool, error) {
+	cred, err := cfg.db.GetTOTPCredential(ctx, userId)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return cred.EnabledAt.Valid, nil
+}
+
+// checkSecondFactor accepts a current TOTP code or an unused recovery code,
+// and uses it up either way.
+func (cfg *apiConfig) checkSecondFactor(ctx context.Context, userId uuid.UUID, code string) (bool, error) {
+	cred, err := cfg.db.GetTOTPCredential(ctx, userId)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !cred.EnabledAt.Valid {
+		return false, nil
+	}
+
+	if step, ok := auth.ValidateTOTP(cred.Secret, code, cfg.now()); ok {
+		used, err := cfg.db.UseTOTPStep(ctx, database.UseTOTPStepParams{
+			UserID:       userId,
+			LastUsedStep: step,
+		})
+		return used == 1, err
+	}
+
+	used, err := cfg.db.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+		UserID:   userId,
+		CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(code)),
+	})
+	return used == 1, err
+}
+
+// issueRecoveryCodes replaces the user's recovery codes with new ones.
+func (cfg *apiConfig) issueRecoveryCodes(ctx context.Context, userId uuid.UUID) ([]string, error) {
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.db.DeleteRecoveryCodes(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		err = cfg.db.CreateRecoveryCode(ctx, database.CreateRecoveryCodeParams{
+			UserID:   userId,
+			CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(code)),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jcuello/chirpy/internal/auth"
+	"github.com/jcuello/chirpy/internal/database"
+	"github.com/jcuello/chirpy/internal/mail"
+	"github.com/jcuello/chirpy/internal/throttle"
+)
+
+const emailTokenExpiration = 24 * time.Hour
+
+var (
+	// verificationEmailPolicy allows a few links a day per address, so
+	// accounts cannot take turns flooding someone else's inbox.
+	verificationEmailPolicy = throttle.Policy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       24 * time.Hour,
+	}
+
+	// verificationUserPolicy stops one account sending links to many
+	// addresses.
+	verificationUserPolicy = throttle.Policy{
+		FreeAttempts: 5,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       24 * time.Hour,
+	}
+)
+
+// sendVerificationEmail mails address a link that proves the user owns it.
+// address is either the account's email or the one it is changing to.
+func (cfg *apiConfig) sendVerificationEmail(ctx context.Context, userId uuid.UUID, address string) error {
+	token, err := auth.MakeEmailToken(userId, address, cfg.keys, emailTokenExpiration)
+	if err != nil {
+		return err
+	}
+
+	link := cfg.baseURL + "/api/users/verify?token=" + url.QueryEscape(token)
+	return cfg.mailer.Send(ctx, mail.Message{
+		To:      address,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf("Welcome to Chirpy!\n\n"+
+			"Open this link within %v to verify your email address:\n\n%s\n\n"+
+			"If you did not sign up, you can ignore this email.\n",
+			emailTokenExpiration, link),
+	})
+}
+
+// handleVerifyEmail is the target of the link in the verification email. A
+// link for a pending address makes it the account's email.
+func (cfg *apiConfig) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	userId, email, err := auth.ValidateEmailToken(r.URL.Query().Get("token"), cfg.keys)
+	if err != nil {
+		respondWithError(w, 400, "Invalid or expired verification link")
+		return
+	}
+
+	verified, err := cfg.db.ConfirmEmailChange(r.Context(), database.ConfirmEmailChangeParams{
+		ID:           userId,
+		PendingEmail: sql.NullString{String: email, Valid: true},
+	})
+	if database.IsUniqueViolation(err) {
+		// Someone else registered the address in the meantime.
+		respondWithError(w, 409, "Email address already in use")
+		return
+	}
+	if err == nil && verified == 0 {
+		verified, err = cfg.db.VerifyUserEmail(r.Context(), database.VerifyUserEmailParams{
+			ID:    userId,
+			Email: sql.NullString{String: email, Valid: true},
+		})
+	}
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+	if verified == 0 {
+		// The account is gone or its address has changed since.
+		respondWithError(w, 400, "Invalid or expired verification link")
+		return
+	}
+
+	respondWithJson(w, 200, struct {
+		ID            uuid.UUID `json:"id"`
+		Email         string    `json:"email"`
+		EmailVerified bool      `json:"email_verified"`
+	}{
+		ID:            userId,
+		Email:         email,
+		EmailVerified: true,
+	})
+}
+
+// handleResendVerification sends a fresh verification email to the caller,
+// to the address they are changing to if there is one.
+func (cfg *apiConfig) handleResendVerification(w http.ResponseWriter, r *http.Request) {
+	userId, _ := auth.UserIDFromContext(r.Context())
+
+	user, err := cfg.db.GetUserByID(r.Context(), userId)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return
+	}
+	address := user.Email.String
+	if user.PendingEmail.Valid {
+		address = user.PendingEmail.String
+	} else if user.EmailVerifiedAt.Valid {
+		respondWithError(w, 409, "Email address already verified")
+		return
+	}
+	if !cfg.verificationAllowed(w, r, user.ID, address) {
+		return
+	}
+
+	err = cfg.sendVerificationEmail(r.Context(), user.ID, address)
+	if err != nil {
+		fmt.Printf("Unable to send verification email to %v: %v\n", user.ID, err)
+		respondWithError(w, 502, "Unable to send verification email")
+		return
+	}
+
+	respondWithJson(w, 204, struct{}{})
+}
+
+// verificationAllowed writes a 429 with Retry-After once the user or the
+// address has been sent too many verification links, and reports whether to
+// go on.
+func (cfg *apiConfig) verificationAllowed(w http.ResponseWriter, r *http.Request, userId uuid.UUID, address string) bool {
+	now := cfg.now()
+	wait, err := cfg.verificationUserThrottle.Attempt(r.Context(), "verify-user:"+userId.String(), now)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return false
+	}
+	if wait == 0 {
+		wait, err = cfg.verificationEmailThrottle.Attempt(r.Context(), "verify-email:"+strings.ToLower(address), now)
+		if err != nil {
+			respondWithInternalServerError(w)
+			return false
+		}
+	}
+
+	if wait == 0 {
+		return true
+	}
+	respondWithTooManyRequests(w, wait, "Too many verification emails, try again later")
+	return false
+}
+
+// emailVerified writes a 403 when REQUIRE_VERIFIED_EMAIL is on and the
+// caller has not verified their address yet, and reports whether they may go
+// on.
+func (cfg *apiConfig) emailVerified(w http.ResponseWriter, r *http.Request, userId uuid.UUID) bool {
+	if !cfg.requireVerifiedEmail {
+		return true
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), userId)
+	if err != nil {
+		respondWithInternalServerError(w)
+		return false
+	}
+	if !user.EmailVerifiedAt.Valid {
+		respondWithError(w, 403, "Verify your email address first")
+		return false
+	}
+	return true
+}